@@ -1,22 +1,28 @@
 package main
 
 import (
-    "bytes"
     "context"
     "crypto/sha256"
-    "encoding/json"
     "fmt"
+    "io"
     "log"
     "net"
     "net/http"
     "strconv"
     "time"
 
-    "github.com/goperfapps/microservices/catalog"
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promhttp"
+    "github.com/sys-apps-go/microservices/auth"
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/catalog"
+    "github.com/sys-apps-go/microservices/certs"
+    "github.com/sys-apps-go/microservices/jwks"
     "google.golang.org/grpc"
-    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/emptypb"
 )
 
 var (
@@ -34,14 +40,117 @@ func init() {
 type server struct {
     catalog.UnimplementedCatalogServiceServer
     catalogClient catalog.CatalogServiceClient
+    cache         *productCache
+}
+
+// forwardAuth passes the caller's token on to catalogserver, which enforces
+// its own policy.
+func forwardAuth(ctx context.Context) context.Context {
+    if md, ok := metadata.FromIncomingContext(ctx); ok {
+        if values := md.Get("authorization"); len(values) > 0 {
+            return metadata.AppendToOutgoingContext(ctx, "authorization", values[0])
+        }
+    }
+    return ctx
+}
+
+// seesDrafts reports whether the caller may read draft products. Their
+// reads skip the cache, which only ever holds what anyone may see.
+func seesDrafts(ctx context.Context) bool {
+    claims, ok := authz.FromContext(ctx)
+    return ok && (claims.HasRole(authz.RoleCatalogEditor) || claims.HasRole(authz.RoleAdmin))
 }
 
 func (s *server) GetProductById(ctx context.Context, req *catalog.GetProductByIdRequest) (*catalog.Product, error) {
-    product, err := s.catalogClient.GetProductById(ctx, req)
+    // The cache only holds catalog prices, so localized ones are asked for
+    // every time
+    if seesDrafts(ctx) || req.Currency != "" || req.Region != "" {
+        return s.catalogClient.GetProductById(forwardAuth(ctx), req)
+    }
+    // The cache is filled without the caller's token, so that it never
+    // holds what they alone could see
+    entry, err := s.cache.getProduct(ctx, s.catalogClient, req.Id)
     if err != nil {
         return nil, err
     }
-    return product, nil
+    entry, err = entry.withMask(req.ReadMask)
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    return entry.product, nil
+}
+
+func (s *server) BatchGetProducts(ctx context.Context, req *catalog.BatchGetProductsRequest) (*catalog.BatchGetProductsResponse, error) {
+    return s.catalogClient.BatchGetProducts(forwardAuth(ctx), req)
+}
+
+func (s *server) ListProducts(ctx context.Context, req *catalog.ListProductsRequest) (*catalog.ListProductsResponse, error) {
+    return s.catalogClient.ListProducts(forwardAuth(ctx), req)
+}
+
+func (s *server) CreateProduct(ctx context.Context, req *catalog.CreateProductRequest) (*catalog.Product, error) {
+    return s.catalogClient.CreateProduct(forwardAuth(ctx), req)
+}
+
+func (s *server) UpdateProduct(ctx context.Context, req *catalog.UpdateProductRequest) (*catalog.Product, error) {
+    return s.catalogClient.UpdateProduct(forwardAuth(ctx), req)
+}
+
+func (s *server) DeleteProduct(ctx context.Context, req *catalog.DeleteProductRequest) (*emptypb.Empty, error) {
+    return s.catalogClient.DeleteProduct(forwardAuth(ctx), req)
+}
+
+func (s *server) CreateVariant(ctx context.Context, req *catalog.CreateVariantRequest) (*catalog.Product, error) {
+    return s.catalogClient.CreateVariant(forwardAuth(ctx), req)
+}
+
+func (s *server) UpdateVariant(ctx context.Context, req *catalog.UpdateVariantRequest) (*catalog.Product, error) {
+    return s.catalogClient.UpdateVariant(forwardAuth(ctx), req)
+}
+
+func (s *server) DeleteVariant(ctx context.Context, req *catalog.DeleteVariantRequest) (*catalog.Product, error) {
+    return s.catalogClient.DeleteVariant(forwardAuth(ctx), req)
+}
+
+func (s *server) GetCategory(ctx context.Context, req *catalog.GetCategoryRequest) (*catalog.Category, error) {
+    return s.catalogClient.GetCategory(forwardAuth(ctx), req)
+}
+
+func (s *server) ListCategories(ctx context.Context, req *catalog.ListCategoriesRequest) (*catalog.ListCategoriesResponse, error) {
+    return s.catalogClient.ListCategories(forwardAuth(ctx), req)
+}
+
+func (s *server) CreateCategory(ctx context.Context, req *catalog.CreateCategoryRequest) (*catalog.Category, error) {
+    return s.catalogClient.CreateCategory(forwardAuth(ctx), req)
+}
+
+func (s *server) UpdateCategory(ctx context.Context, req *catalog.UpdateCategoryRequest) (*catalog.Category, error) {
+    return s.catalogClient.UpdateCategory(forwardAuth(ctx), req)
+}
+
+func (s *server) DeleteCategory(ctx context.Context, req *catalog.DeleteCategoryRequest) (*emptypb.Empty, error) {
+    return s.catalogClient.DeleteCategory(forwardAuth(ctx), req)
+}
+
+// WatchProducts relays catalogserver's change stream. The upstream stream
+// ends with the caller's, and its final status is passed on unchanged.
+func (s *server) WatchProducts(req *catalog.WatchProductsRequest, stream catalog.CatalogService_WatchProductsServer) error {
+    upstream, err := s.catalogClient.WatchProducts(forwardAuth(stream.Context()), req)
+    if err != nil {
+        return err
+    }
+    for {
+        event, err := upstream.Recv()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        if err := stream.Send(event); err != nil {
+            return err
+        }
+    }
 }
 
 func hashPassword(password string) string {
@@ -50,24 +159,64 @@ func hashPassword(password string) string {
 }
 
 type AuthResponse struct {
-    Success bool   `json:"success"`
-    Message string `json:"message"`
+    Success  bool   `json:"success"`
+    Message  string `json:"message"`
+    Token    string `json:"token,omitempty"`
+    MFAToken string `json:"mfaToken,omitempty"`
+}
+
+func clientIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
 }
 
 func main() {
-    conn, err := grpc.Dial("localhost:50052", grpc.WithTransportCredentials(insecure.NewCredentials()))
+    tlsConfig, err := certs.FromEnv("apiserver")
+    if err != nil {
+        log.Fatalf("Failed to load TLS certificates: %v", err)
+    }
+    authClient = certs.HTTPClient(tlsConfig)
+
+    authConn, err := grpc.Dial(authz.AuthGRPCAddr(), certs.DialCredentials(tlsConfig), grpc.WithUnaryInterceptor(authClientInterceptor))
+    if err != nil {
+        log.Fatalf("Failed to connect to auth server: %v", err)
+    }
+    defer authConn.Close()
+    authRPC := auth.NewAuthServiceClient(authConn)
+
+    conn, err := grpc.Dial("localhost:50052", certs.DialCredentials(tlsConfig))
     if err != nil {
         log.Fatalf("Failed to connect to catalog server: %v", err)
     }
     defer conn.Close()
     catalogClient := catalog.NewCatalogServiceClient(conn)
+    cache := newProductCache(productCacheSize, productCacheTTL)
+    go cache.watch(context.Background(), catalogClient)
+
+    policy, err := authz.LoadPolicy(authz.PolicyPath(), "apiserver")
+    if err != nil {
+        log.Fatalf("Failed to load authorization policy: %v", err)
+    }
+    keys := jwks.NewVerifier(authz.JWKSURL())
+    keys.Client = authClient
+    apiKeys := authz.NewAPIKeyClient(authz.APIKeyVerifyURL())
+    apiKeys.Client = authClient
+    // Tokens are checked with authserver too, so that logging out or
+    // revoking a session locks the token out here within seconds.
+    verifier := &authz.Verifier{Keys: keys.Keyfunc, APIKeys: apiKeys, Sessions: authz.NewSessionClient(authRPC)}
 
     lis, err := net.Listen("tcp", ":50051")
     if err != nil {
         log.Fatalf("Failed to listen: %v", err)
     }
-    s := grpc.NewServer()
-    catalog.RegisterCatalogServiceServer(s, &server{catalogClient: catalogClient})
+    opts := append(certs.ServerCredentials(tlsConfig),
+        grpc.UnaryInterceptor(authz.UnaryServerInterceptor(policy, verifier)),
+        grpc.StreamInterceptor(authz.StreamServerInterceptor(policy, verifier)))
+    s := grpc.NewServer(opts...)
+    catalog.RegisterCatalogServiceServer(s, &server{catalogClient: catalogClient, cache: cache})
     go func() {
         log.Println("Starting gRPC server on port 50051...")
         if err := s.Serve(lis); err != nil {
@@ -75,6 +224,14 @@ func main() {
         }
     }()
 
+    if err := registerCatalogRoutes(http.DefaultServeMux, cache, catalogClient); err != nil {
+        log.Fatalf("Failed to register REST gateway: %v", err)
+    }
+    http.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Write(catalog.OpenAPI)
+    })
+
     http.HandleFunc("/getProduct", func(w http.ResponseWriter, r *http.Request) {
         totalStart := time.Now()
         log.Println("Received HTTP request for /getProduct")
@@ -86,18 +243,20 @@ func main() {
             return
         }
 
-        req := &catalog.GetProductByIdRequest{Id: int32(productId)}
-
         grpcStart := time.Now()
-        res, err := catalogClient.GetProductById(context.Background(), req)
+        entry, err := cache.getProduct(r.Context(), catalogClient, int32(productId))
         if err != nil {
             http.Error(w, "Failed to get product", http.StatusInternalServerError)
             return
         }
         grpcDuration := time.Since(grpcStart)
 
-        log.Printf("Received gRPC response: %v, gRPC call took %s", res, grpcDuration)
-        fmt.Fprintf(w, "Product: %v", res)
+        if notModified(w, r, entry) {
+            return
+        }
+
+        log.Printf("Received gRPC response: %v, gRPC call took %s", entry.product, grpcDuration)
+        fmt.Fprintf(w, "Product: %v", entry.product)
 
         totalDuration := time.Since(totalStart)
         httpDuration.WithLabelValues(r.URL.Path).Observe(totalDuration.Seconds())
@@ -115,33 +274,18 @@ func main() {
             return
         }
 
-        hashedPassword := hashPassword(password)
-        authReq := map[string]string{
-            "firstName": firstName,
-            "lastName":  lastName,
-            "email":     email,
-            "password":  hashedPassword,
-        }
-
-        authReqJson, err := json.Marshal(authReq)
-        if err != nil {
-            http.Error(w, "Failed to marshal request", http.StatusInternalServerError)
-            return
-        }
-
-        resp, err := http.Post("http://localhost:50053/signup", "application/json", bytes.NewBuffer(authReqJson))
+        res, err := authRPC.Signup(r.Context(), &auth.SignupRequest{
+            FirstName: firstName,
+            LastName:  lastName,
+            Email:     email,
+            Password:  hashPassword(password),
+        })
         if err != nil {
-            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
-            return
-        }
-        defer resp.Body.Close()
-
-        var authRes AuthResponse
-        if err := json.NewDecoder(resp.Body).Decode(&authRes); err != nil {
-            http.Error(w, "Failed to decode response", http.StatusInternalServerError)
+            writeAuthError(w, "Signup", err)
             return
         }
 
+        authRes := AuthResponse{Success: true, Message: res.Message}
         fmt.Fprintf(w, "Signup: %v", authRes)
     })
 
@@ -154,35 +298,42 @@ func main() {
             return
         }
 
-        hashedPassword := hashPassword(password)
-        authReq := map[string]string{"email": email, "password": hashedPassword}
-
-        authReqJson, err := json.Marshal(authReq)
+        // Let authserver record where the session was opened from
+        res, err := authRPC.Login(r.Context(), &auth.LoginRequest{
+            Email:     email,
+            Password:  hashPassword(password),
+            UserAgent: r.UserAgent(),
+            Ip:        clientIP(r),
+        })
         if err != nil {
-            http.Error(w, "Failed to marshal request", http.StatusInternalServerError)
+            writeAuthError(w, "Login", err)
             return
         }
 
-        resp, err := http.Post("http://localhost:50053/login", "application/json", bytes.NewBuffer(authReqJson))
-        if err != nil {
-            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
-            return
+        authRes := AuthResponse{
+            Success:  res.Token != "",
+            Message:  res.Message,
+            Token:    res.Token,
+            MFAToken: res.MfaToken,
         }
-        defer resp.Body.Close()
+        fmt.Fprintf(w, "Login: %v", authRes)
+    })
 
-        var authRes AuthResponse
-        if err := json.NewDecoder(resp.Body).Decode(&authRes); err != nil {
-            http.Error(w, "Failed to decode response", http.StatusInternalServerError)
+    http.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+        res, err := authRPC.Logout(r.Context(), &auth.LogoutRequest{Token: r.Header.Get("Authorization")})
+        if err != nil {
+            writeAuthError(w, "Logout", err)
             return
         }
-
-        fmt.Fprintf(w, "Login: %v", authRes)
+        fmt.Fprintf(w, "Logout: %v", AuthResponse{Success: true, Message: res.Message})
     })
 
+    registerAuthProxies(http.DefaultServeMux)
+
     http.Handle("/metrics", promhttp.Handler())
 
     log.Println("Starting HTTP server on port 50061...")
-    if err := http.ListenAndServe(":50061", nil); err != nil {
+    if err := certs.ListenAndServe(":50061", authz.Middleware(policy, verifier, http.DefaultServeMux), tlsConfig); err != nil {
         log.Fatalf("Failed to serve: %v", err)
     }
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc"
+    "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeCatalog answers GetProductById, localizing the price when asked to,
+// and counts the calls. All calls fail with err if it is set.
+type fakeCatalog struct {
+    catalog.CatalogServiceClient
+    calls int
+    batch  *catalog.BatchGetProductsRequest
+    delete *catalog.DeleteProductRequest
+    err    error
+}
+
+// DeleteProduct records the request.
+func (f *fakeCatalog) DeleteProduct(ctx context.Context, req *catalog.DeleteProductRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+    f.delete = req
+    if f.err != nil {
+        return nil, f.err
+    }
+    return &emptypb.Empty{}, nil
+}
+
+// BatchGetProducts records the request and finds every product but 9.
+func (f *fakeCatalog) BatchGetProducts(ctx context.Context, req *catalog.BatchGetProductsRequest, opts ...grpc.CallOption) (*catalog.BatchGetProductsResponse, error) {
+    f.batch = req
+    if f.err != nil {
+        return nil, f.err
+    }
+    resp := &catalog.BatchGetProductsResponse{}
+    for _, id := range req.Ids {
+        if id == 9 {
+            resp.MissingIds = append(resp.MissingIds, id)
+            continue
+        }
+        resp.Products = append(resp.Products, &catalog.Product{Id: id, Name: "Product"})
+    }
+    return resp, nil
+}
+
+func (f *fakeCatalog) GetProductById(ctx context.Context, req *catalog.GetProductByIdRequest, opts ...grpc.CallOption) (*catalog.Product, error) {
+    f.calls++
+    if f.err != nil {
+        return nil, f.err
+    }
+    p := &catalog.Product{Id: req.Id, Name: "Product", Price: 19.99, Version: 1}
+    if req.Currency != "" {
+        p.LocalizedPrice = &catalog.Money{CurrencyCode: req.Currency, Units: 18}
+    }
+    return p, nil
+}
+
+func TestGetProductByIdCache(t *testing.T) {
+    client := &fakeCatalog{}
+    s := &server{catalogClient: client, cache: newProductCache(10, time.Minute)}
+    ctx := context.Background()
+
+    for i := 0; i < 2; i++ {
+        if _, err := s.GetProductById(ctx, &catalog.GetProductByIdRequest{Id: 1}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if client.calls != 1 {
+        t.Errorf("%d calls for two reads, want 1", client.calls)
+    }
+
+    // Localized prices are not cached, and do not end up in the cache.
+    p, err := s.GetProductById(ctx, &catalog.GetProductByIdRequest{Id: 1, Currency: "EUR"})
+    if err != nil || p.LocalizedPrice.GetCurrencyCode() != "EUR" {
+        t.Errorf("read in EUR: %v, %v", p, err)
+    }
+    if p, _ := s.GetProductById(ctx, &catalog.GetProductByIdRequest{Id: 1}); p.LocalizedPrice != nil {
+        t.Errorf("localized price %v was cached", p.LocalizedPrice)
+    }
+
+    // Catalog editors may see drafts, which are never cached.
+    editor := authz.NewContext(ctx, &authz.Claims{Roles: []string{authz.RoleCatalogEditor}})
+    if _, err := s.GetProductById(editor, &catalog.GetProductByIdRequest{Id: 1}); err != nil {
+        t.Fatal(err)
+    }
+    if client.calls != 3 {
+        t.Errorf("%d calls in all, want 3", client.calls)
+    }
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// authRPCTimeout bounds every call to authserver.
+const authRPCTimeout = 5 * time.Second
+
+var authRPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+    Name:    "auth_rpc_duration_seconds",
+    Help:    "Duration of gRPC calls to authserver.",
+    Buckets: prometheus.DefBuckets,
+}, []string{"method", "code"})
+
+func init() {
+    prometheus.MustRegister(authRPCDuration)
+}
+
+// authClientInterceptor gives each call to authserver a deadline, unless the
+// caller's context already has a tighter one, and records its duration.
+func authClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+    ctx, cancel := context.WithTimeout(ctx, authRPCTimeout)
+    defer cancel()
+    start := time.Now()
+    err := invoker(ctx, method, req, reply, cc, opts...)
+    authRPCDuration.WithLabelValues(method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+    return err
+}
+
+// writeAuthError reports a failed authserver call to the HTTP client, with
+// the status code matching the gRPC one.
+func writeAuthError(w http.ResponseWriter, op string, err error) {
+    st := status.Convert(err)
+    code := http.StatusInternalServerError
+    switch st.Code() {
+    case codes.InvalidArgument, codes.AlreadyExists:
+        code = http.StatusBadRequest
+    case codes.Unauthenticated:
+        code = http.StatusUnauthorized
+    case codes.FailedPrecondition:
+        code = http.StatusForbidden
+    case codes.DeadlineExceeded, codes.Unavailable:
+        http.Error(w, "Failed to communicate with auth server", http.StatusServiceUnavailable)
+        return
+    }
+    w.WriteHeader(code)
+    fmt.Fprintf(w, "%s: %v", op, AuthResponse{Success: false, Message: st.Message()})
+}
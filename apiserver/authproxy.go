@@ -0,0 +1,248 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "html/template"
+    "net/http"
+    "strings"
+
+    "github.com/sys-apps-go/microservices/authz"
+)
+
+var (
+    authServerURL = authz.AuthURL()
+    // authClient trusts the internal CA once TLS is configured
+    authClient = http.DefaultClient
+)
+
+// callAuth sends body as JSON to path on authserver on behalf of the caller
+// of r, passing on its bearer token, and decodes the reply into out. It
+// returns authserver's status code for the handler to pass on, so that a
+// rejected request is not reported as a success.
+func callAuth(r *http.Request, method, path string, body, out interface{}) (int, error) {
+    var reqBody bytes.Buffer
+    if body != nil {
+        if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+            return 0, err
+        }
+    }
+    authHttpReq, err := http.NewRequestWithContext(r.Context(), method, authServerURL+path, &reqBody)
+    if err != nil {
+        return 0, err
+    }
+    authHttpReq.Header.Set("Content-Type", "application/json")
+    authHttpReq.Header.Set("User-Agent", r.UserAgent())
+    authHttpReq.Header.Set("X-Forwarded-For", clientIP(r))
+    if auth := r.Header.Get("Authorization"); auth != "" {
+        authHttpReq.Header.Set("Authorization", auth)
+    }
+
+    resp, err := authClient.Do(authHttpReq)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+
+    // Some errors come back as plain text; the status still says what
+    // went wrong.
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil && resp.StatusCode < 300 {
+        return 0, err
+    }
+    return resp.StatusCode, nil
+}
+
+// allowMethods answers 405 and returns false unless r uses one of methods.
+// Handlers that change state take their fields from a form body, so that
+// passwords stay out of URLs and logs.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+    for _, m := range methods {
+        if r.Method == m {
+            return true
+        }
+    }
+    w.Header().Set("Allow", strings.Join(methods, ", "))
+    http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    return false
+}
+
+// resetPage is the form password reset links open. It is executed with the
+// token from the link.
+var resetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<title>Reset your password</title>
+<form method="post" action="/password/reset">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Reset password</button>
+</form>
+`))
+
+// registerAuthProxies mounts the endpoints apiserver forwards to
+// authserver's HTTP API.
+func registerAuthProxies(mux *http.ServeMux) {
+    mux.HandleFunc("/login/mfa", func(w http.ResponseWriter, r *http.Request) {
+        mfaToken := r.URL.Query().Get("mfaToken")
+        code := r.URL.Query().Get("code")
+        recoveryCode := r.URL.Query().Get("recoveryCode")
+        if mfaToken == "" || (code == "" && recoveryCode == "") {
+            http.Error(w, "MFA token and a code or recovery code are required", http.StatusBadRequest)
+            return
+        }
+
+        authReq := map[string]string{"mfaToken": mfaToken, "code": code, "recoveryCode": recoveryCode}
+        var authRes AuthResponse
+        status, err := callAuth(r, http.MethodPost, "/login/mfa", authReq, &authRes)
+        if err != nil {
+            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "Login: %v", authRes)
+    })
+
+    mux.HandleFunc("/password/forgot", func(w http.ResponseWriter, r *http.Request) {
+        email := r.URL.Query().Get("email")
+        if email == "" {
+            http.Error(w, "Email is required", http.StatusBadRequest)
+            return
+        }
+
+        var authRes AuthResponse
+        status, err := callAuth(r, http.MethodPost, "/password/forgot", map[string]string{"email": email}, &authRes)
+        if err != nil {
+            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "Forgot password: %v", authRes)
+    })
+
+    // The emailed reset link opens the form; submitting it POSTs the new
+    // password in the body so it stays out of URLs and logs.
+    mux.HandleFunc("/password/reset", func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet || r.Method == http.MethodHead {
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            resetPage.Execute(w, r.URL.Query().Get("token"))
+            return
+        }
+        if r.Method != http.MethodPost {
+            w.Header().Set("Allow", "GET, HEAD, POST")
+            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        if err := r.ParseForm(); err != nil {
+            http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+            return
+        }
+        token := r.PostForm.Get("token")
+        password := r.PostForm.Get("password")
+        if token == "" || password == "" {
+            http.Error(w, "Token and password are required", http.StatusBadRequest)
+            return
+        }
+
+        authReq := map[string]string{"token": token, "password": hashPassword(password)}
+        var authRes AuthResponse
+        status, err := callAuth(r, http.MethodPost, "/password/reset", authReq, &authRes)
+        if err != nil {
+            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "Reset password: %v", authRes)
+    })
+
+    // Profile endpoints act on the user owning the bearer token in the
+    // Authorization header. Changes are POSTed as forms.
+    mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+        var profile map[string]interface{}
+        status, err := callAuth(r, http.MethodGet, "/profile", nil, &profile)
+        if err != nil {
+            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "Profile: %v", profile)
+    })
+
+    mux.HandleFunc("/profile/update", func(w http.ResponseWriter, r *http.Request) {
+        if !allowMethods(w, r, http.MethodPost, http.MethodPatch) {
+            return
+        }
+        if err := r.ParseForm(); err != nil {
+            http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+            return
+        }
+        authReq := map[string]string{}
+        for _, field := range []string{"firstName", "lastName"} {
+            if value := r.PostForm.Get(field); value != "" {
+                authReq[field] = value
+            }
+        }
+
+        var profile map[string]interface{}
+        status, err := callAuth(r, http.MethodPatch, "/profile", authReq, &profile)
+        if err != nil {
+            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "Profile: %v", profile)
+    })
+
+    mux.HandleFunc("/profile/email", func(w http.ResponseWriter, r *http.Request) {
+        if !allowMethods(w, r, http.MethodPost) {
+            return
+        }
+        if err := r.ParseForm(); err != nil {
+            http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+            return
+        }
+        email := r.PostForm.Get("email")
+        password := r.PostForm.Get("password")
+        if email == "" || password == "" {
+            http.Error(w, "Email and password are required", http.StatusBadRequest)
+            return
+        }
+
+        authReq := map[string]string{"email": email, "password": hashPassword(password)}
+        var authRes AuthResponse
+        status, err := callAuth(r, http.MethodPost, "/profile/email", authReq, &authRes)
+        if err != nil {
+            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "Change email: %v", authRes)
+    })
+
+    mux.HandleFunc("/profile/password", func(w http.ResponseWriter, r *http.Request) {
+        if !allowMethods(w, r, http.MethodPost) {
+            return
+        }
+        if err := r.ParseForm(); err != nil {
+            http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+            return
+        }
+        currentPassword := r.PostForm.Get("currentPassword")
+        newPassword := r.PostForm.Get("newPassword")
+        if currentPassword == "" || newPassword == "" {
+            http.Error(w, "Current and new password are required", http.StatusBadRequest)
+            return
+        }
+
+        authReq := map[string]string{
+            "currentPassword": hashPassword(currentPassword),
+            "newPassword":     hashPassword(newPassword),
+        }
+        var authRes AuthResponse
+        status, err := callAuth(r, http.MethodPost, "/profile/password", authReq, &authRes)
+        if err != nil {
+            http.Error(w, "Failed to communicate with auth server", http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(status)
+        fmt.Fprintf(w, "Change password: %v", authRes)
+    })
+}
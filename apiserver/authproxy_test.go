@@ -0,0 +1,165 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+// fakeAuth stands in for authserver's HTTP API. It records the last
+// request and answers every one with status and reply.
+type fakeAuth struct {
+    status int
+    reply  string
+
+    method, path, auth string
+    body               map[string]string
+}
+
+func (f *fakeAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    f.method, f.path, f.auth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+    f.body = nil
+    json.NewDecoder(r.Body).Decode(&f.body)
+    w.WriteHeader(f.status)
+    io.WriteString(w, f.reply)
+}
+
+func newAuthProxy(t *testing.T) (*fakeAuth, *http.ServeMux) {
+    t.Helper()
+    fake := &fakeAuth{status: http.StatusOK, reply: `{}`}
+    upstream := httptest.NewServer(fake)
+    t.Cleanup(upstream.Close)
+    old := authServerURL
+    authServerURL = upstream.URL
+    t.Cleanup(func() { authServerURL = old })
+
+    mux := http.NewServeMux()
+    registerAuthProxies(mux)
+    return fake, mux
+}
+
+func TestProfileProxies(t *testing.T) {
+    fake, mux := newAuthProxy(t)
+
+    tests := []struct {
+        name     string
+        target   string
+        form     url.Values
+        status   int
+        reply    string
+        method   string
+        path     string
+        wantBody map[string]string
+    }{
+        {"get", "/profile", nil, http.StatusOK, `{"id":2,"firstName":"Jane"}`, "GET", "/profile", nil},
+        {"get without a session", "/profile", nil, http.StatusUnauthorized, `{"success":false,"message":"Authentication required"}`, "GET", "/profile", nil},
+        {"update", "/profile/update", url.Values{"lastName": {"Roe"}}, http.StatusOK, `{"id":2,"lastName":"Roe"}`, "PATCH", "/profile",
+            map[string]string{"lastName": "Roe"}},
+        {"empty name", "/profile/update", url.Values{"firstName": {" "}}, http.StatusBadRequest, `{"success":false,"message":"Names cannot be empty"}`, "PATCH", "/profile",
+            map[string]string{"firstName": " "}},
+        {"change email", "/profile/email", url.Values{"email": {"new@example.com"}, "password": {"pw"}}, http.StatusOK, `{"success":true}`, "POST", "/profile/email",
+            map[string]string{"email": "new@example.com", "password": hashPassword("pw")}},
+        {"email in use", "/profile/email", url.Values{"email": {"taken@example.com"}, "password": {"pw"}}, http.StatusConflict, `{"success":false}`, "POST", "/profile/email",
+            map[string]string{"email": "taken@example.com", "password": hashPassword("pw")}},
+        {"wrong password", "/profile/password", url.Values{"currentPassword": {"a"}, "newPassword": {"b"}}, http.StatusUnauthorized, `{"success":false}`, "POST", "/profile/password",
+            map[string]string{"currentPassword": hashPassword("a"), "newPassword": hashPassword("b")}},
+        {"plain text error", "/profile", nil, http.StatusNotFound, "User not found\n", "GET", "/profile", nil},
+    }
+    for _, tt := range tests {
+        fake.status, fake.reply = tt.status, tt.reply
+        r := httptest.NewRequest("GET", tt.target, nil)
+        if tt.form != nil {
+            r = httptest.NewRequest("POST", tt.target, strings.NewReader(tt.form.Encode()))
+            r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+        }
+        r.Header.Set("Authorization", "Bearer abc")
+        w := httptest.NewRecorder()
+        mux.ServeHTTP(w, r)
+
+        if w.Code != tt.status {
+            t.Errorf("%s: status %d, want authserver's %d", tt.name, w.Code, tt.status)
+        }
+        if fake.method != tt.method || fake.path != tt.path {
+            t.Errorf("%s: forwarded as %s %s, want %s %s", tt.name, fake.method, fake.path, tt.method, tt.path)
+        }
+        if fake.auth != "Bearer abc" {
+            t.Errorf("%s: Authorization %q not passed on", tt.name, fake.auth)
+        }
+        for k, v := range tt.wantBody {
+            if fake.body[k] != v {
+                t.Errorf("%s: forwarded %s = %q, want %q", tt.name, k, fake.body[k], v)
+            }
+        }
+        if len(tt.wantBody) > 0 && len(fake.body) != len(tt.wantBody) {
+            t.Errorf("%s: forwarded %v, want only %v", tt.name, fake.body, tt.wantBody)
+        }
+    }
+}
+
+func TestProfileChangesRequirePost(t *testing.T) {
+    fake, mux := newAuthProxy(t)
+    for _, target := range []string{
+        "/profile/update?lastName=Roe",
+        "/profile/email?email=new@example.com&password=pw",
+        "/profile/password?currentPassword=a&newPassword=b",
+    } {
+        fake.path = ""
+        r := httptest.NewRequest("GET", target, nil)
+        r.Header.Set("Authorization", "Bearer abc")
+        w := httptest.NewRecorder()
+        mux.ServeHTTP(w, r)
+        if w.Code != http.StatusMethodNotAllowed || fake.path != "" {
+            t.Errorf("GET %s: status %d, forwarded to %q", target, w.Code, fake.path)
+        }
+    }
+
+    // Fields in the query string of a POST are not taken either.
+    r := httptest.NewRequest("POST", "/profile/password?currentPassword=a&newPassword=b", nil)
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, r)
+    if w.Code != http.StatusBadRequest || fake.path != "" {
+        t.Errorf("passwords in the query: status %d, forwarded to %q", w.Code, fake.path)
+    }
+}
+
+func TestPasswordResetProxy(t *testing.T) {
+    fake, mux := newAuthProxy(t)
+
+    r := httptest.NewRequest("GET", `/password/reset?token="><script>`, nil)
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, r)
+    if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post"`) {
+        t.Fatalf("reset page: status %d, %s", w.Code, w.Body)
+    }
+    if strings.Contains(w.Body.String(), "<script>") {
+        t.Errorf("token not escaped: %s", w.Body)
+    }
+    if fake.path != "" {
+        t.Errorf("showing the form called authserver's %s", fake.path)
+    }
+
+    // A password in the query string is not taken.
+    r = httptest.NewRequest("POST", "/password/reset?token=t&password=pw", nil)
+    w = httptest.NewRecorder()
+    mux.ServeHTTP(w, r)
+    if w.Code != http.StatusBadRequest || fake.path != "" {
+        t.Errorf("password in the query: status %d, forwarded to %q", w.Code, fake.path)
+    }
+
+    fake.status, fake.reply = http.StatusBadRequest, `{"success":false,"message":"Invalid or expired reset link"}`
+    form := url.Values{"token": {"t"}, "password": {"pw"}}
+    r = httptest.NewRequest("POST", "/password/reset", strings.NewReader(form.Encode()))
+    r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    w = httptest.NewRecorder()
+    mux.ServeHTTP(w, r)
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("expired link: status %d, want 400", w.Code)
+    }
+    if fake.path != "/password/reset" || fake.body["token"] != "t" || fake.body["password"] != hashPassword("pw") {
+        t.Errorf("forwarded %s %v", fake.path, fake.body)
+    }
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+    "container/list"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/fieldmaskpb"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+    productCacheSize = 1000
+    productCacheTTL  = 30 * time.Second
+)
+
+var (
+    productCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "product_cache_lookups_total",
+        Help: "Product cache lookups by result (hit or miss).",
+    }, []string{"result"})
+    productCacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+        Name: "product_cache_evictions_total",
+        Help: "Products removed from the cache by reason (capacity, expired or invalidated).",
+    }, []string{"reason"})
+)
+
+func init() {
+    prometheus.MustRegister(productCacheLookups, productCacheEvictions)
+}
+
+// cachedProduct is a product together with the validators it is served
+// with.
+type cachedProduct struct {
+    product  *catalog.Product
+    etag     string
+    modified time.Time
+    expires  time.Time
+}
+
+// productCache is an LRU cache of products keyed by ID. Entries expire
+// after ttl even if no change event arrives for them.
+type productCache struct {
+    mu      sync.Mutex
+    size    int
+    ttl     time.Duration
+    now     func() time.Time
+    order   *list.List // of int32 IDs, most recently used first
+    entries map[int32]*list.Element
+    values  map[int32]*cachedProduct
+    // gen counts invalidations, so that a product fetched while one
+    // happened is not cached, as it may predate the change.
+    gen uint64
+    // flights coalesces concurrent misses for the same product.
+    flights *flightGroup
+}
+
+func newProductCache(size int, ttl time.Duration) *productCache {
+    return &productCache{
+        size:    size,
+        ttl:     ttl,
+        now:     time.Now,
+        order:   list.New(),
+        entries: map[int32]*list.Element{},
+        values:  map[int32]*cachedProduct{},
+        flights: newFlightGroup(productFetchTimeout),
+    }
+}
+
+// productETag derives a strong ETag from the contents of p as served. It
+// starts with the product's version so that If-Match can be checked
+// against it, see etagVersion.
+func productETag(version int64, p *catalog.Product) string {
+    data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(p)
+    sum := sha256.Sum256(data)
+    return fmt.Sprintf(`"%d-%s"`, version, hex.EncodeToString(sum[:12]))
+}
+
+// withMask returns the entry as served for a read mask, which has its own
+// ETag.
+func (e *cachedProduct) withMask(mask *fieldmaskpb.FieldMask) (*cachedProduct, error) {
+    masked, err := catalog.ApplyReadMask(e.product, mask)
+    if err != nil || masked == e.product {
+        return e, err
+    }
+    return &cachedProduct{
+        product:  masked,
+        etag:     productETag(e.product.Version, masked),
+        modified: e.modified,
+        expires:  e.expires,
+    }, nil
+}
+
+// get returns the cached product with id, if there is a fresh one, and the
+// generation to pass to add after fetching it otherwise.
+func (c *productCache) get(id int32) (*cachedProduct, uint64, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    entry, ok := c.values[id]
+    if ok && c.now().After(entry.expires) {
+        c.remove(id, "expired")
+        ok = false
+    }
+    if !ok {
+        productCacheLookups.WithLabelValues("miss").Inc()
+        return nil, c.gen, false
+    }
+    c.order.MoveToFront(c.entries[id])
+    productCacheLookups.WithLabelValues("hit").Inc()
+    return entry, c.gen, true
+}
+
+// lastModified returns when p as served last changed: when it was updated
+// or, if later, when its sale price changed. Promotions do not touch
+// updated_at, so Last-Modified would otherwise hide sales from clients
+// that revalidate with If-Modified-Since.
+func lastModified(p *catalog.Product) time.Time {
+    var modified time.Time
+    for _, t := range []*timestamppb.Timestamp{p.UpdatedAt, p.SaleUpdatedAt} {
+        if t != nil && t.AsTime().After(modified) {
+            modified = t.AsTime()
+        }
+    }
+    return modified
+}
+
+// saleChange returns when the sale price of p changes next on schedule:
+// when the sale it is on ends or the next promotion for it starts.
+func saleChange(p *catalog.Product) (time.Time, bool) {
+    var next time.Time
+    for _, t := range []*timestamppb.Timestamp{p.SaleEndTime, p.NextSaleStartTime} {
+        if t != nil && (next.IsZero() || t.AsTime().Before(next)) {
+            next = t.AsTime()
+        }
+    }
+    return next, !next.IsZero()
+}
+
+// add caches p, evicting the least recently used product if the cache is
+// full. Nothing is cached if there were invalidations since gen. A product
+// expires when its sale price changes on schedule, since no change event
+// is sent then.
+func (c *productCache) add(p *catalog.Product, gen uint64) *cachedProduct {
+    entry := &cachedProduct{product: p, etag: productETag(p.Version, p), modified: lastModified(p), expires: c.now().Add(c.ttl)}
+    if next, ok := saleChange(p); ok && next.Before(entry.expires) {
+        entry.expires = next
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if gen != c.gen {
+        return entry
+    }
+    if elem, ok := c.entries[p.Id]; ok {
+        c.order.MoveToFront(elem)
+    } else {
+        c.entries[p.Id] = c.order.PushFront(p.Id)
+        if c.order.Len() > c.size {
+            c.remove(c.order.Back().Value.(int32), "capacity")
+        }
+    }
+    c.values[p.Id] = entry
+    return entry
+}
+
+func (c *productCache) invalidate(id int32) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.gen++
+    if _, ok := c.values[id]; ok {
+        c.remove(id, "invalidated")
+    }
+}
+
+// purge empties the cache, for when change events may have been missed.
+func (c *productCache) purge() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.gen++
+    for id := range c.values {
+        c.remove(id, "invalidated")
+    }
+}
+
+func (c *productCache) remove(id int32, reason string) {
+    c.order.Remove(c.entries[id])
+    delete(c.entries, id)
+    delete(c.values, id)
+    productCacheEvictions.WithLabelValues(reason).Inc()
+}
+
+// getProduct reads a product through the cache. Concurrent misses for the
+// same product share one call to catalogserver.
+func (c *productCache) getProduct(ctx context.Context, client catalog.CatalogServiceClient, id int32) (*cachedProduct, error) {
+    entry, gen, ok := c.get(id)
+    if ok {
+        return entry, nil
+    }
+    return c.flights.do(ctx, id, func(ctx context.Context) (*cachedProduct, error) {
+        product, err := client.GetProductById(ctx, &catalog.GetProductByIdRequest{Id: id})
+        if err != nil {
+            return nil, err
+        }
+        return c.add(product, gen), nil
+    })
+}
+
+// watch invalidates cached products as catalogserver reports changes to
+// them. Whenever the stream breaks the cache is purged, since events may
+// have been lost, and watching resumes from the last event seen. Until
+// then entries still expire after the TTL.
+func (c *productCache) watch(ctx context.Context, client catalog.CatalogServiceClient) {
+    var resumeToken string
+    backoff := time.Second
+    for ctx.Err() == nil {
+        stream, err := client.WatchProducts(ctx, &catalog.WatchProductsRequest{ResumeToken: resumeToken})
+        for err == nil {
+            var event *catalog.ProductEvent
+            event, err = stream.Recv()
+            if err == nil {
+                c.invalidate(event.Product.GetId())
+                resumeToken = event.ResumeToken
+                backoff = time.Second
+            }
+        }
+        // The token is too old, or catalogserver restarted and no longer
+        // knows it; start over from the current state.
+        if code := status.Code(err); code == codes.OutOfRange || code == codes.InvalidArgument {
+            resumeToken = ""
+        }
+        c.purge()
+        log.Printf("Product change stream interrupted: %v, retrying in %s", err, backoff)
+        select {
+        case <-time.After(backoff):
+        case <-ctx.Done():
+        }
+        if backoff < time.Minute {
+            backoff *= 2
+        }
+    }
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "testing"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestProductCache(t *testing.T) {
+    now := time.Now()
+    cache := newProductCache(2, time.Minute)
+    cache.now = func() time.Time { return now }
+
+    _, gen, _ := cache.get(1)
+    cache.add(&catalog.Product{Id: 1, Name: "Product 1"}, gen)
+    cache.add(&catalog.Product{Id: 2, Name: "Product 2"}, gen)
+    if _, _, ok := cache.get(1); !ok {
+        t.Fatal("product 1 not cached")
+    }
+    // Product 2 is now the least recently used and makes room for 3.
+    cache.add(&catalog.Product{Id: 3, Name: "Product 3"}, gen)
+    if _, _, ok := cache.get(2); ok {
+        t.Error("product 2 was not evicted")
+    }
+
+    now = now.Add(2 * time.Minute)
+    if _, _, ok := cache.get(1); ok {
+        t.Error("product 1 did not expire")
+    }
+
+    // A product expires early when its next promotion starts.
+    cache.add(&catalog.Product{Id: 5, Name: "Product 5", NextSaleStartTime: timestamppb.New(now.Add(10 * time.Second))}, gen)
+    if _, _, ok := cache.get(5); !ok {
+        t.Fatal("product 5 not cached")
+    }
+    now = now.Add(11 * time.Second)
+    if _, _, ok := cache.get(5); ok {
+        t.Error("product 5 outlived the start of its promotion")
+    }
+
+    // A product fetched before an invalidation must not be cached, as it
+    // may be older than the change that caused it.
+    _, gen, _ = cache.get(4)
+    cache.invalidate(4)
+    cache.add(&catalog.Product{Id: 4, Name: "Product 4"}, gen)
+    if _, _, ok := cache.get(4); ok {
+        t.Error("stale product 4 was cached")
+    }
+}
+
+func TestProductETag(t *testing.T) {
+    a := productETag(1, &catalog.Product{Id: 1, Name: "Product 1", Price: 19.99})
+    b := productETag(1, &catalog.Product{Id: 1, Name: "Product 1", Price: 24.99})
+    if a == b {
+        t.Error("price change did not change the ETag")
+    }
+    if a != productETag(1, &catalog.Product{Id: 1, Name: "Product 1", Price: 19.99}) {
+        t.Error("ETag is not stable")
+    }
+}
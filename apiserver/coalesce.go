@@ -0,0 +1,88 @@
+package main
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var productLookupsCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+    Name: "product_lookups_coalesced_total",
+    Help: "Product lookups that waited for an identical call already in flight instead of making their own.",
+})
+
+func init() {
+    prometheus.MustRegister(productLookupsCoalesced)
+}
+
+// flight is one upstream call and the callers waiting for it.
+type flight struct {
+    done    chan struct{}
+    product *cachedProduct
+    err     error
+    waiters int
+    cancel  context.CancelFunc
+}
+
+// productFetchTimeout bounds every shared product lookup, so that a hung
+// call to catalogserver does not hold up later callers for good.
+const productFetchTimeout = 10 * time.Second
+
+// flightGroup coalesces concurrent lookups of the same product into one
+// upstream call. Each caller can give up on its own; the call itself is
+// only cancelled once no caller is waiting for it any more, or once it has
+// taken longer than timeout.
+type flightGroup struct {
+    mu      sync.Mutex
+    calls   map[int32]*flight
+    timeout time.Duration
+}
+
+func newFlightGroup(timeout time.Duration) *flightGroup {
+    return &flightGroup{calls: map[int32]*flight{}, timeout: timeout}
+}
+
+// do returns the result of fetch for id, sharing it with every concurrent
+// caller for the same id. fetch runs with a context that carries the values
+// of the first caller's ctx but not its cancellation or deadline, and has
+// the group's own timeout instead.
+func (g *flightGroup) do(ctx context.Context, id int32, fetch func(context.Context) (*cachedProduct, error)) (*cachedProduct, error) {
+    g.mu.Lock()
+    f, ok := g.calls[id]
+    if ok {
+        productLookupsCoalesced.Inc()
+    } else {
+        callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), g.timeout)
+        f = &flight{done: make(chan struct{}), cancel: cancel}
+        g.calls[id] = f
+        go func() {
+            f.product, f.err = fetch(callCtx)
+            cancel()
+            g.mu.Lock()
+            if g.calls[id] == f {
+                delete(g.calls, id)
+            }
+            g.mu.Unlock()
+            close(f.done)
+        }()
+    }
+    f.waiters++
+    g.mu.Unlock()
+
+    select {
+    case <-f.done:
+        return f.product, f.err
+    case <-ctx.Done():
+        g.mu.Lock()
+        f.waiters--
+        if f.waiters == 0 && g.calls[id] == f {
+            // Nobody wants the result; later callers start a new call.
+            delete(g.calls, id)
+            f.cancel()
+        }
+        g.mu.Unlock()
+        return nil, ctx.Err()
+    }
+}
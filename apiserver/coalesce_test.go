@@ -0,0 +1,112 @@
+package main
+
+import (
+    "context"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+)
+
+func waitForWaiters(g *flightGroup, id int32, n int) {
+    for waiters := 0; waiters < n; {
+        time.Sleep(time.Millisecond)
+        g.mu.Lock()
+        if f := g.calls[id]; f != nil {
+            waiters = f.waiters
+        }
+        g.mu.Unlock()
+    }
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+    g := newFlightGroup(time.Minute)
+    release := make(chan struct{})
+    var calls int32
+    fetch := func(ctx context.Context) (*cachedProduct, error) {
+        atomic.AddInt32(&calls, 1)
+        <-release
+        return &cachedProduct{product: &catalog.Product{Id: 1}}, nil
+    }
+
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if p, err := g.do(context.Background(), 1, fetch); err != nil || p.product.Id != 1 {
+                t.Errorf("got %v, %v", p, err)
+            }
+        }()
+    }
+    // Let every caller join the flight before it lands.
+    waitForWaiters(g, 1, 10)
+    close(release)
+    wg.Wait()
+    if calls != 1 {
+        t.Errorf("%d upstream calls, want 1", calls)
+    }
+}
+
+func TestFlightGroupCancellation(t *testing.T) {
+    g := newFlightGroup(time.Minute)
+    started := make(chan struct{})
+    upstreamDone := make(chan error, 1)
+    fetch := func(ctx context.Context) (*cachedProduct, error) {
+        close(started)
+        <-ctx.Done()
+        upstreamDone <- ctx.Err()
+        return nil, ctx.Err()
+    }
+
+    first, cancelFirst := context.WithCancel(context.Background())
+    second, cancelSecond := context.WithCancel(context.Background())
+    errs := make(chan error, 2)
+    go func() { _, err := g.do(first, 1, fetch); errs <- err }()
+    <-started
+    go func() { _, err := g.do(second, 1, fetch); errs <- err }()
+    waitForWaiters(g, 1, 2)
+
+    // The first caller leaving must not cancel the call for the second.
+    cancelFirst()
+    if err := <-errs; err != context.Canceled {
+        t.Fatalf("first caller: %v", err)
+    }
+    select {
+    case <-upstreamDone:
+        t.Fatal("upstream call cancelled while a caller was still waiting")
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    cancelSecond()
+    if err := <-errs; err != context.Canceled {
+        t.Fatalf("second caller: %v", err)
+    }
+    select {
+    case <-upstreamDone:
+    case <-time.After(time.Second):
+        t.Fatal("upstream call not cancelled after every caller left")
+    }
+}
+
+func TestFlightGroupTimeout(t *testing.T) {
+    g := newFlightGroup(20 * time.Millisecond)
+    stuck := func(ctx context.Context) (*cachedProduct, error) {
+        <-ctx.Done()
+        return nil, ctx.Err()
+    }
+
+    // A caller without a deadline of its own is still let go, and the next
+    // caller gets a call of its own.
+    if _, err := g.do(context.Background(), 1, stuck); err != context.DeadlineExceeded {
+        t.Fatalf("stuck fetch: %v, want DeadlineExceeded", err)
+    }
+    p, err := g.do(context.Background(), 1, func(ctx context.Context) (*cachedProduct, error) {
+        return &cachedProduct{product: &catalog.Product{Id: 1}}, nil
+    })
+    if err != nil || p.product.Id != 1 {
+        t.Errorf("after the stuck fetch: %v, %v", p, err)
+    }
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// etagVersion returns the product version a strong ETag made by
+// productETag refers to.
+func etagVersion(etag string) (int64, bool) {
+    etag = strings.TrimSpace(etag)
+    if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+        return 0, false
+    }
+    version, _, ok := strings.Cut(etag[1:len(etag)-1], "-")
+    if !ok {
+        return 0, false
+    }
+    v, err := strconv.ParseInt(version, 10, 64)
+    return v, err == nil && v > 0
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// validators match too, as allowed for GET.
+func etagMatches(header, etag string) bool {
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+        if candidate == etag || candidate == "*" {
+            return true
+        }
+    }
+    return false
+}
+
+// notModified sets the validators and caching headers for entry and
+// writes a 304 response if the request's conditions say the client's copy
+// is current. If-Modified-Since is only consulted without If-None-Match.
+// Clients may not keep a sale price past the end of the sale, or a price
+// past the start of the next promotion.
+func notModified(w http.ResponseWriter, r *http.Request, entry *cachedProduct) bool {
+    w.Header().Set("ETag", entry.etag)
+    maxAge := productCacheTTL
+    if next, ok := saleChange(entry.product); ok && time.Until(next) < maxAge {
+        maxAge = max(time.Until(next), 0)
+    }
+    w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+    modified := entry.modified.Truncate(time.Second)
+    if !modified.IsZero() {
+        w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+    }
+
+    current := false
+    if match := r.Header.Get("If-None-Match"); match != "" {
+        current = etagMatches(match, entry.etag)
+    } else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() {
+        current = !modified.After(since)
+    }
+    if current {
+        w.WriteHeader(http.StatusNotModified)
+    }
+    return current
+}
+
+// readMask returns the read mask given in the query as the gateway would
+// accept it, with either the proto or the JSON name of the parameter.
+func readMask(r *http.Request) *fieldmaskpb.FieldMask {
+    query := r.URL.Query()
+    value := query.Get("read_mask")
+    if value == "" {
+        value = query.Get("readMask")
+    }
+    if value == "" {
+        return nil
+    }
+    return &fieldmaskpb.FieldMask{Paths: strings.Split(value, ",")}
+}
+
+// productHandler serves GET /v1/products/{id} from the cache, answering
+// conditional requests with 304. Responses and errors are encoded the way
+// the gateway would encode them. The cache only holds catalog prices of
+// products anyone may see, so requests for localized prices and requests
+// from catalog editors go straight to the gateway.
+func productHandler(cache *productCache, client catalog.CatalogServiceClient, gateway *runtime.ServeMux) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if query := r.URL.Query(); query.Has("currency") || query.Has("region") || seesDrafts(r.Context()) {
+            gateway.ServeHTTP(w, r)
+            return
+        }
+        _, marshaler := runtime.MarshalerForRequest(gateway, r)
+        id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+        if err != nil {
+            runtime.HTTPError(r.Context(), gateway, marshaler, w, r, status.Error(codes.InvalidArgument, "invalid product ID"))
+            return
+        }
+        entry, err := cache.getProduct(r.Context(), client, int32(id))
+        if err != nil {
+            runtime.HTTPError(r.Context(), gateway, marshaler, w, r, err)
+            return
+        }
+        entry, err = entry.withMask(readMask(r))
+        if err != nil {
+            runtime.HTTPError(r.Context(), gateway, marshaler, w, r, status.Error(codes.InvalidArgument, err.Error()))
+            return
+        }
+        if notModified(w, r, entry) {
+            return
+        }
+        data, err := marshaler.Marshal(entry.product)
+        if err != nil {
+            runtime.HTTPError(r.Context(), gateway, marshaler, w, r, err)
+            return
+        }
+        w.Header().Set("Content-Type", marshaler.ContentType(entry.product))
+        w.Write(data)
+    }
+}
+
+// preconditionWriter turns the 409 the gateway sends for ABORTED into 412,
+// which is what a failed If-Match calls for.
+type preconditionWriter struct {
+    http.ResponseWriter
+}
+
+func (w preconditionWriter) WriteHeader(code int) {
+    if code == http.StatusConflict {
+        code = http.StatusPreconditionFailed
+    }
+    w.ResponseWriter.WriteHeader(code)
+}
+
+// ifMatch lets clients give the version an update or delete is based on
+// with If-Match instead of in the body or the version query parameter. The
+// ETag names the version the client last saw, which is passed on for
+// catalogserver to check.
+func ifMatch(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        match := r.Header.Get("If-Match")
+        if match == "" || strings.TrimSpace(match) == "*" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        version, ok := etagVersion(match)
+        if !ok {
+            http.Error(w, "If-Match must be a single strong ETag of the product", http.StatusPreconditionFailed)
+            return
+        }
+
+        if r.Method == http.MethodDelete {
+            query := r.URL.Query()
+            query.Set("version", strconv.FormatInt(version, 10))
+            r.URL.RawQuery = query.Encode()
+            next.ServeHTTP(preconditionWriter{w}, r)
+            return
+        }
+
+        var product map[string]interface{}
+        if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+            http.Error(w, "Invalid product", http.StatusBadRequest)
+            return
+        }
+        // int64 fields are strings in the protobuf JSON mapping.
+        product["version"] = strconv.FormatInt(version, 10)
+        body, _ := json.Marshal(product)
+        r.Body = io.NopCloser(bytes.NewReader(body))
+        r.ContentLength = int64(len(body))
+        next.ServeHTTP(preconditionWriter{w}, r)
+    })
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestNotModified(t *testing.T) {
+    updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    product := &catalog.Product{Id: 1, Name: "Product 1", Version: 3, UpdatedAt: timestamppb.New(updated)}
+    entry := &cachedProduct{product: product, etag: productETag(product.Version, product), modified: updated}
+    if v, ok := etagVersion(entry.etag); !ok || v != 3 {
+        t.Fatalf("etagVersion(%s) = %d, %v", entry.etag, v, ok)
+    }
+
+    tests := []struct {
+        header, value string
+        want          bool
+    }{
+        {"If-None-Match", entry.etag, true},
+        {"If-None-Match", `"2-abc", ` + entry.etag, true},
+        {"If-None-Match", `"2-abc"`, false},
+        {"If-Modified-Since", updated.Format(http.TimeFormat), true},
+        {"If-Modified-Since", updated.Add(-time.Hour).Format(http.TimeFormat), false},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest("GET", "/v1/products/1", nil)
+        r.Header.Set(tt.header, tt.value)
+        w := httptest.NewRecorder()
+        if got := notModified(w, r, entry); got != tt.want {
+            t.Errorf("%s: %s gave %v, want %v", tt.header, tt.value, got, tt.want)
+        }
+        if w.Header().Get("ETag") != entry.etag {
+            t.Errorf("ETag header not set")
+        }
+    }
+}
+
+func TestNotModifiedAfterSaleChange(t *testing.T) {
+    // A promotion started an hour after the product was last updated, so a
+    // copy from before then has the wrong price.
+    updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    sale := updated.Add(time.Hour)
+    cache := newProductCache(1, time.Minute)
+    entry := cache.add(&catalog.Product{Id: 1, Version: 1, UpdatedAt: timestamppb.New(updated),
+        SalePrice: catalog.NewMoney("USD", 900), SaleUpdatedAt: timestamppb.New(sale)}, 0)
+
+    for since, want := range map[time.Time]bool{updated: false, sale: true} {
+        r := httptest.NewRequest("GET", "/v1/products/1", nil)
+        r.Header.Set("If-Modified-Since", since.Format(http.TimeFormat))
+        w := httptest.NewRecorder()
+        if got := notModified(w, r, entry); got != want {
+            t.Errorf("If-Modified-Since %v gave %v, want %v", since, got, want)
+        }
+        if got := w.Header().Get("Last-Modified"); got != sale.Format(http.TimeFormat) {
+            t.Errorf("Last-Modified %s, want %s", got, sale.Format(http.TimeFormat))
+        }
+    }
+}
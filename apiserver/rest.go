@@ -0,0 +1,41 @@
+package main
+
+import (
+    "context"
+    "net/http"
+
+    "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+    "github.com/sys-apps-go/microservices/catalog"
+)
+
+// registerCatalogRoutes mounts the REST routes generated from the HTTP
+// annotations in catalog.proto. The gateway passes the Authorization header
+// on to catalogserver. Routes are mounted one by one so the policy can tell
+// them apart. Single products are read through the cache so conditional
+// GETs can be answered without asking catalogserver.
+func registerCatalogRoutes(mux *http.ServeMux, cache *productCache, client catalog.CatalogServiceClient) error {
+    gateway := runtime.NewServeMux()
+    if err := catalog.RegisterCatalogServiceHandlerClient(context.Background(), gateway, client); err != nil {
+        return err
+    }
+    mux.Handle("GET /v1/products", gateway)
+    mux.Handle("POST /v1/products", gateway)
+    mux.Handle("GET /v1/products:batchGet", gateway)
+    mux.Handle("GET /v1/products/{id}", productHandler(cache, client, gateway))
+    mux.Handle("PUT /v1/products/{id}", ifMatch(gateway))
+    mux.Handle("DELETE /v1/products/{id}", ifMatch(gateway))
+    mux.Handle("POST /v1/products/{id}/variants", gateway)
+    mux.Handle("PUT /v1/products/{id}/variants/{variant}", gateway)
+    mux.Handle("DELETE /v1/products/{id}/variants/{variant}", gateway)
+    mux.Handle("GET /v1/categories", gateway)
+    mux.Handle("POST /v1/categories", gateway)
+    mux.Handle("GET /v1/categories/{id}", gateway)
+    mux.Handle("PUT /v1/categories/{id}", gateway)
+    mux.Handle("DELETE /v1/categories/{id}", gateway)
+    mux.Handle("GET /v1/promotions", gateway)
+    mux.Handle("POST /v1/promotions", gateway)
+    mux.Handle("GET /v1/promotions/{id}", gateway)
+    mux.Handle("PUT /v1/promotions/{id}", gateway)
+    mux.Handle("DELETE /v1/promotions/{id}", gateway)
+    return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// newRESTMux returns the catalog REST routes in front of client.
+func newRESTMux(t *testing.T, client *fakeCatalog) *http.ServeMux {
+    t.Helper()
+    mux := http.NewServeMux()
+    if err := registerCatalogRoutes(mux, newProductCache(10, time.Minute), client); err != nil {
+        t.Fatal(err)
+    }
+    return mux
+}
+
+func TestBatchGetRoute(t *testing.T) {
+    client := &fakeCatalog{}
+    mux := newRESTMux(t, client)
+
+    r := httptest.NewRequest("GET", "/v1/products:batchGet?ids=3&ids=9&ids=1&currency=EUR", nil)
+    w := httptest.NewRecorder()
+    mux.ServeHTTP(w, r)
+    if w.Code != http.StatusOK {
+        t.Fatalf("status %d: %s", w.Code, w.Body)
+    }
+    if ids := client.batch.GetIds(); len(ids) != 3 || ids[0] != 3 || ids[1] != 9 || ids[2] != 1 || client.batch.Currency != "EUR" {
+        t.Errorf("forwarded %v", client.batch)
+    }
+    var resp struct {
+        Products []struct {
+            Id int32 `json:"id"`
+        } `json:"products"`
+        MissingIds []int32 `json:"missingIds"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+        t.Fatal(err)
+    }
+    if len(resp.Products) != 2 || resp.Products[0].Id != 3 || resp.Products[1].Id != 1 || len(resp.MissingIds) != 1 || resp.MissingIds[0] != 9 {
+        t.Errorf("got %s", w.Body)
+    }
+
+    // Errors from catalogserver come back as HTTP statuses.
+    client.err = status.Error(codes.InvalidArgument, "at most 100 IDs may be requested at once")
+    w = httptest.NewRecorder()
+    mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/products:batchGet?ids=1", nil))
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("too many IDs: status %d, want 400", w.Code)
+    }
+}
+
+func TestProductRoutes(t *testing.T) {
+    client := &fakeCatalog{}
+    mux := newRESTMux(t, client)
+    serve := func(method, target string, header http.Header) *httptest.ResponseRecorder {
+        r := httptest.NewRequest(method, target, nil)
+        for k, v := range header {
+            r.Header[k] = v
+        }
+        w := httptest.NewRecorder()
+        mux.ServeHTTP(w, r)
+        return w
+    }
+
+    w := serve("GET", "/v1/products/7", nil)
+    var product struct {
+        Id             int32 `json:"id"`
+        LocalizedPrice *struct {
+            CurrencyCode string `json:"currencyCode"`
+        } `json:"localizedPrice"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &product); err != nil || w.Code != http.StatusOK || product.Id != 7 || w.Header().Get("ETag") == "" {
+        t.Errorf("GET: status %d, %s, ETag %q", w.Code, w.Body, w.Header().Get("ETag"))
+    }
+    // Query parameters other than the read mask reach the request too.
+    w = serve("GET", "/v1/products/7?currency=EUR", nil)
+    if err := json.Unmarshal(w.Body.Bytes(), &product); err != nil || product.LocalizedPrice == nil || product.LocalizedPrice.CurrencyCode != "EUR" {
+        t.Errorf("GET in EUR: status %d, %s", w.Code, w.Body)
+    }
+    if w := serve("GET", "/v1/products/seven", nil); w.Code != http.StatusBadRequest {
+        t.Errorf("GET with a bad ID: status %d, want 400", w.Code)
+    }
+
+    etag := http.Header{"If-Match": {productETag(3, &catalog.Product{Id: 7})}}
+    if w := serve("DELETE", "/v1/products/7", etag); w.Code != http.StatusOK || client.delete.GetId() != 7 || client.delete.GetVersion() != 3 {
+        t.Errorf("DELETE: status %d, forwarded %v", w.Code, client.delete)
+    }
+
+    for _, tt := range []struct {
+        err            error
+        method, target string
+        header         http.Header
+        want           int
+    }{
+        {status.Error(codes.NotFound, "product 8 not found"), "GET", "/v1/products/8", nil, http.StatusNotFound},
+        {status.Error(codes.Unavailable, "catalogserver is down"), "GET", "/v1/products/8?currency=EUR", nil, http.StatusServiceUnavailable},
+        {status.Error(codes.Aborted, "product has been changed"), "DELETE", "/v1/products/7", etag, http.StatusPreconditionFailed},
+        {status.Error(codes.PermissionDenied, "catalog editors only"), "DELETE", "/v1/products/7", nil, http.StatusForbidden},
+    } {
+        client.err = tt.err
+        if w := serve(tt.method, tt.target, tt.header); w.Code != tt.want {
+            t.Errorf("%s %s failing with %v: status %d, want %d", tt.method, tt.target, tt.err, w.Code, tt.want)
+        }
+    }
+}
@@ -0,0 +1,5 @@
+package auth
+
+// Regenerate with go generate ./auth. protogen refuses changes that would
+// break existing clients, see tools/protogen.
+//go:generate go run ../tools/protogen -root .. -I . -snapshot auth/auth.binpb -plugin go=paths=source_relative -plugin go-grpc=paths=source_relative auth/auth.proto
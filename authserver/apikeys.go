@@ -0,0 +1,236 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/sys-apps-go/microservices/authz"
+)
+
+const (
+    // apiKeyDefaultTTL applies when a key is created without expiresInDays.
+    apiKeyDefaultTTL = 90 * 24 * time.Hour
+
+    // apiKeyMaxTTL caps how long a key may live.
+    apiKeyMaxTTL = 365 * 24 * time.Hour
+)
+
+// APIKey lets a machine client act as the user who created it, limited to
+// Scopes. Only a hash of the secret part is kept.
+type APIKey struct {
+    ID         string     `json:"id"`
+    Name       string     `json:"name"`
+    UserID     int        `json:"-"`
+    Scopes     []string   `json:"scopes"`
+    CreatedAt  time.Time  `json:"createdAt"`
+    ExpiresAt  time.Time  `json:"expiresAt"`
+    LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+    SecretHash string     `json:"-"`
+}
+
+type APIKeysResponse struct {
+    APIKeys []APIKey `json:"apiKeys"`
+}
+
+type CreateAPIKeyResponse struct {
+    APIKey
+    // Key is only ever shown in the creation response
+    Key string `json:"key"`
+}
+
+// API keys keyed by ID, guarded by dbMu
+var apiKeys = map[string]*APIKey{}
+
+// splitAPIKey returns the ID and secret parts of a key formatted as
+// mk_<id>_<secret>.
+func splitAPIKey(key string) (id, secret string, ok bool) {
+    if !authz.IsAPIKey(key) {
+        return "", "", false
+    }
+    id, secret, ok = strings.Cut(strings.TrimPrefix(key, authz.APIKeyPrefix), "_")
+    return id, secret, ok && id != "" && secret != ""
+}
+
+// grantedScopes returns the scopes of key that its owner still holds, so a
+// key never outlives a role being taken away. The caller must hold dbMu.
+func grantedScopes(key *APIKey, user *User) []string {
+    var scopes []string
+    for _, scope := range key.Scopes {
+        if hasRole(user, scope) {
+            scopes = append(scopes, scope)
+        }
+    }
+    return scopes
+}
+
+// CreateAPIKeyHandler issues a key for the caller. Scopes must be a subset
+// of the caller's own roles.
+func CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    var req struct {
+        Name          string   `json:"name"`
+        Scopes        []string `json:"scopes"`
+        ExpiresInDays int      `json:"expiresInDays"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    ttl := apiKeyDefaultTTL
+    if req.ExpiresInDays != 0 {
+        ttl = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+    }
+    if req.Name == "" || len(req.Scopes) == 0 || ttl <= 0 || ttl > apiKeyMaxTTL {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Name, at least one scope and an expiry of at most 365 days are required",
+        })
+        return
+    }
+
+    id, err := randomToken()
+    if err != nil {
+        http.Error(w, "Failed to generate key", http.StatusInternalServerError)
+        return
+    }
+    secret, err := randomToken()
+    if err != nil {
+        http.Error(w, "Failed to generate key", http.StatusInternalServerError)
+        return
+    }
+    now := time.Now()
+    key := APIKey{
+        ID:         id[:16],
+        Name:       req.Name,
+        UserID:     current.UserID,
+        Scopes:     req.Scopes,
+        CreatedAt:  now,
+        ExpiresAt:  now.Add(ttl),
+        SecretHash: hashToken(secret),
+    }
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    allowed := user != nil
+    for _, scope := range req.Scopes {
+        if user != nil && !hasRole(user, scope) {
+            allowed = false
+        }
+    }
+    if allowed {
+        apiKeys[key.ID] = &key
+    }
+    dbMu.Unlock()
+
+    if !allowed {
+        writeJSON(w, http.StatusForbidden, AuthResponse{
+            Success: false,
+            Message: "Scopes must be roles you hold",
+        })
+        return
+    }
+    writeJSON(w, http.StatusCreated, CreateAPIKeyResponse{
+        APIKey: key,
+        Key:    authz.APIKeyPrefix + key.ID + "_" + secret,
+    })
+}
+
+// ListAPIKeysHandler returns the caller's keys, newest first.
+func ListAPIKeysHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    list := []APIKey{}
+    dbMu.Lock()
+    for _, key := range apiKeys {
+        if key.UserID == current.UserID {
+            list = append(list, *key)
+        }
+    }
+    dbMu.Unlock()
+
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].CreatedAt.After(list[j].CreatedAt)
+    })
+    writeJSON(w, http.StatusOK, APIKeysResponse{APIKeys: list})
+}
+
+// RevokeAPIKeyHandler deletes one of the caller's keys. Services cache key
+// lookups briefly, so a revoked key may keep working for a few seconds.
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    id := r.PathValue("id")
+
+    dbMu.Lock()
+    key, ok := apiKeys[id]
+    // Keys of other users are reported as missing so IDs cannot be probed
+    ok = ok && key.UserID == current.UserID
+    if ok {
+        delete(apiKeys, id)
+    }
+    dbMu.Unlock()
+
+    if !ok {
+        http.Error(w, "API key not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "API key revoked",
+    })
+}
+
+// VerifyAPIKeyHandler tells services whether a key is valid and which
+// scopes it currently grants.
+func VerifyAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Key string `json:"key"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    w.Header().Set("Cache-Control", "no-store")
+    id, secret, ok := splitAPIKey(req.Key)
+    if !ok {
+        writeJSON(w, http.StatusOK, authz.APIKeyVerification{Valid: false})
+        return
+    }
+
+    now := time.Now()
+    var res authz.APIKeyVerification
+    dbMu.Lock()
+    key, ok := apiKeys[id]
+    if ok && now.After(key.ExpiresAt) {
+        delete(apiKeys, id)
+        ok = false
+    }
+    res.UnknownID = !ok
+    ok = ok && subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(key.SecretHash)) == 1
+    var user *User
+    if ok {
+        user = findUserByID(key.UserID)
+    }
+    var scopes []string
+    if user != nil {
+        scopes = grantedScopes(key, user)
+    }
+    // A key left with no scopes would still pass as an authenticated caller
+    if len(scopes) > 0 {
+        key.LastUsedAt = &now
+        res = authz.APIKeyVerification{
+            Valid:     true,
+            KeyID:     key.ID,
+            UserID:    strconv.Itoa(user.ID),
+            Scopes:    scopes,
+            ExpiresAt: key.ExpiresAt,
+        }
+    }
+    dbMu.Unlock()
+
+    writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/authz"
+)
+
+func createAPIKey(t *testing.T, base, token string, body map[string]interface{}) (*CreateAPIKeyResponse, int) {
+    t.Helper()
+    b, _ := json.Marshal(body)
+    req, _ := http.NewRequest(http.MethodPost, base+"/apikeys", bytes.NewReader(b))
+    req.Header.Set("Authorization", "Bearer "+token)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    var created CreateAPIKeyResponse
+    json.NewDecoder(resp.Body).Decode(&created)
+    return &created, resp.StatusCode
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+    srv := newTestAuthServer(t)
+    token := login(t, http.DefaultClient, srv.URL, "admin@example.com")
+
+    if _, status := createAPIKey(t, srv.URL, token, map[string]interface{}{"name": "job", "scopes": []string{authz.RoleCatalogEditor}}); status != http.StatusForbidden {
+        t.Errorf("key with a scope the user lacks: status %d, want 403", status)
+    }
+    created, status := createAPIKey(t, srv.URL, token, map[string]interface{}{"name": "job", "scopes": []string{authz.RoleAdmin}})
+    if status != http.StatusCreated || !authz.IsAPIKey(created.Key) {
+        t.Fatalf("create failed: %d %+v", status, created)
+    }
+
+    dbMu.Lock()
+    stored := apiKeys[created.ID]
+    dbMu.Unlock()
+    if stored == nil || bytes.Contains([]byte(stored.SecretHash), []byte(created.Key)) {
+        t.Fatalf("key not stored hashed: %+v", stored)
+    }
+
+    resolve := func() (*authz.Claims, error) {
+        v := &authz.Verifier{APIKeys: authz.NewAPIKeyClient(srv.URL + "/apikeys/verify")}
+        return v.Verify("Bearer " + created.Key)
+    }
+    claims, err := resolve()
+    if err != nil || claims.Subject != "1" || !claims.HasRole(authz.RoleAdmin) {
+        t.Fatalf("verify failed: %+v %v", claims, err)
+    }
+    if _, err := (&authz.Verifier{APIKeys: authz.NewAPIKeyClient(srv.URL + "/apikeys/verify")}).Verify(created.Key + "x"); err == nil {
+        t.Error("tampered key accepted")
+    }
+
+    // Keys lose whatever roles their owner loses
+    dbMu.Lock()
+    findUserByID(1).Roles = []string{authz.RoleCustomer}
+    dbMu.Unlock()
+    if _, err := resolve(); err == nil {
+        t.Error("key still valid after its only scope was taken from the user")
+    }
+    dbMu.Lock()
+    findUserByID(1).Roles = []string{authz.RoleAdmin}
+    dbMu.Unlock()
+
+    req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/apikeys/"+created.ID, nil)
+    req.Header.Set("Authorization", "Bearer "+token)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("revoke: status %d", resp.StatusCode)
+    }
+    if _, err := resolve(); err == nil {
+        t.Error("revoked key accepted")
+    }
+}
+
+func TestAPIKeysNeedFirstPartySession(t *testing.T) {
+    srv := newTestAuthServer(t)
+    dbMu.Lock()
+    admin := *findUserByID(1)
+    dbMu.Unlock()
+    _, clientToken, err := startSession(admin, "partner/1.0", "10.0.0.1", "client", "openid")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    body := map[string]interface{}{"name": "job", "scopes": []string{authz.RoleAdmin}}
+    if _, status := createAPIKey(t, srv.URL, clientToken, body); status != http.StatusForbidden {
+        t.Errorf("key created with an OAuth client's token: status %d, want 403", status)
+    }
+    dbMu.Lock()
+    n := len(apiKeys)
+    dbMu.Unlock()
+    if n != 0 {
+        t.Errorf("%d keys stored", n)
+    }
+    if _, status := createAPIKey(t, srv.URL, login(t, http.DefaultClient, srv.URL, "admin@example.com"), body); status != http.StatusCreated {
+        t.Errorf("key created with a login session: status %d, want 201", status)
+    }
+}
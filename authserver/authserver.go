@@ -1,9 +1,18 @@
 package main
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "log"
+    "net"
     "net/http"
+    "sync"
+
+    "github.com/sys-apps-go/microservices/auth"
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/certs"
+    "google.golang.org/grpc"
 )
 
 type User struct {
@@ -12,28 +21,111 @@ type User struct {
     LastName  string `json:"lastName"`
     Email     string `json:"email"`
     Password  string `json:"password"`
+    // EmailVerified is only ever set by following the emailed link
+    EmailVerified bool `json:"-"`
+    // PendingEmail replaces Email once the owner confirms it
+    PendingEmail string `json:"-"`
+    // MFASecret is set at enrollment; it is only enforced once MFAEnabled
+    MFASecret      []byte `json:"-"`
+    MFAEnabled     bool   `json:"-"`
+    MFALastCounter int64  `json:"-"`
+    // RecoveryCodes holds hashes of the unused recovery codes
+    RecoveryCodes []string `json:"-"`
+    // Roles can only be changed by an admin through /users/{id}/roles
+    Roles []string `json:"-"`
 }
 
 type AuthResponse struct {
     Success bool   `json:"success"`
     Message string `json:"message"`
+    Token   string `json:"token,omitempty"`
+    // MFAToken is returned instead of Token when the user still has to
+    // present a second factor to /login/mfa
+    MFAToken string `json:"mfaToken,omitempty"`
 }
 
-// Simulated database to store users
-var users []User
+// Simulated database to store users and their sessions
+var (
+    dbMu     sync.Mutex
+    users    []User
+    sessions = map[string]*Session{}
+)
 
 func main() {
+    if passwordResetURL == "" {
+        log.Fatal("PASSWORD_RESET_URL must be set to the page password reset links open")
+    }
+    mailer = newMailerFromEnv()
+    keySet = loadKeySet()
+    verifier = &authz.Verifier{Keys: keySet.Keyfunc, AllowAudience: true}
+    go keySet.RotateEvery(context.Background(), keyRotationInterval(), func(err error) {
+        log.Printf("Failed to rotate signing keys: %v", err)
+    })
+
     // Register HTTP handlers
-    http.HandleFunc("/signup", SignupHandler)
-    http.HandleFunc("/login", LoginHandler)
+    registerHandlers(http.DefaultServeMux)
+
+    tlsConfig, err := certs.FromEnv("authserver")
+    if err != nil {
+        log.Fatalf("Failed to load TLS certificates: %v", err)
+    }
+
+    // gRPC interface for the other services
+    lis, err := net.Listen("tcp", ":50054")
+    if err != nil {
+        log.Fatalf("Failed to listen: %v", err)
+    }
+    s := grpc.NewServer(certs.ServerCredentials(tlsConfig)...)
+    auth.RegisterAuthServiceServer(s, authService{})
+    go func() {
+        log.Println("Starting auth gRPC server on :50054...")
+        if err := s.Serve(lis); err != nil {
+            log.Fatalf("Failed to serve gRPC: %v", err)
+        }
+    }()
 
     // Start HTTP server
     log.Println("Starting auth server on :50053...")
-    if err := http.ListenAndServe(":50053", nil); err != nil {
+    if err := certs.ListenAndServe(":50053", http.DefaultServeMux, tlsConfig); err != nil {
         log.Fatalf("Failed to start auth server: %v", err)
     }
 }
 
+// registerHandlers mounts every endpoint of the auth server on mux.
+func registerHandlers(mux *http.ServeMux) {
+    mux.HandleFunc("/signup", SignupHandler)
+    mux.HandleFunc("/login", LoginHandler)
+    mux.HandleFunc("POST /login/mfa", LoginMFAHandler)
+    mux.HandleFunc("GET /verify", VerifyEmailHandler)
+    mux.HandleFunc("POST /verify/resend", ResendVerificationHandler)
+    mux.HandleFunc("POST /password/forgot", ForgotPasswordHandler)
+    mux.HandleFunc("POST /password/reset", ResetPasswordHandler)
+    mux.HandleFunc("GET /sessions", requireSession(ListSessionsHandler))
+    mux.HandleFunc("DELETE /sessions/{id}", requireSession(RevokeSessionHandler))
+    mux.HandleFunc("GET /profile", requireSession(GetProfileHandler))
+    mux.HandleFunc("PATCH /profile", requireSession(UpdateProfileHandler))
+    mux.HandleFunc("POST /profile/email", requireSession(ChangeEmailHandler))
+    mux.HandleFunc("POST /profile/password", requireSession(ChangePasswordHandler))
+    mux.HandleFunc("POST /mfa/enroll", requireSession(EnrollMFAHandler))
+    mux.HandleFunc("POST /mfa/activate", requireSession(ActivateMFAHandler))
+    mux.HandleFunc("POST /mfa/disable", requireSession(DisableMFAHandler))
+    mux.HandleFunc("PUT /users/{id}/roles", requireSession(SetRolesHandler))
+    mux.HandleFunc("GET /apikeys", requireSession(ListAPIKeysHandler))
+    mux.HandleFunc("POST /apikeys", requireSession(CreateAPIKeyHandler))
+    mux.HandleFunc("DELETE /apikeys/{id}", requireSession(RevokeAPIKeyHandler))
+    mux.HandleFunc("POST /apikeys/verify", VerifyAPIKeyHandler)
+    mux.HandleFunc("GET /jwks.json", JWKSHandler)
+
+    // OpenID Connect provider
+    mux.HandleFunc("GET /.well-known/openid-configuration", DiscoveryHandler)
+    mux.HandleFunc("GET /.well-known/jwks.json", JWKSHandler)
+    mux.HandleFunc("GET /oauth2/authorize", AuthorizeHandler)
+    mux.HandleFunc("POST /oauth2/authorize", ConsentHandler)
+    mux.HandleFunc("POST /oauth2/token", TokenHandler)
+    mux.HandleFunc("GET /oauth2/userinfo", requireClientSession(UserInfoHandler))
+    mux.HandleFunc("POST /oauth2/clients", requireSession(RegisterClientHandler))
+}
+
 func SignupHandler(w http.ResponseWriter, r *http.Request) {
     // Parse request body
     var newUser User
@@ -44,9 +136,8 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
     }
     defer r.Body.Close()
 
-    // Simulate signup logic (replace with actual signup logic)
-    // For example, check if user already exists
-    if userExists(newUser.Email) {
+    message, err := registerUser(r.Context(), &newUser)
+    if err != nil {
         response := AuthResponse{
             Success: false,
             Message: "User already exists",
@@ -57,17 +148,9 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // If user does not exist, proceed with signup
-    // Replace with actual signup logic to create user in database
-
-    // Simulate adding user to in-memory database
-    newUser.ID = len(users) + 1
-    users = append(users, newUser)
-
-    // Assuming signup was successful
     response := AuthResponse{
         Success: true,
-        Message: "User registered successfully",
+        Message: message,
     }
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
@@ -84,9 +167,8 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
     }
     defer r.Body.Close()
 
-    // Simulate login logic (replace with actual login logic)
-    _, err = loginUser(loginReq.Email, loginReq.Password)
-    if err != nil {
+    token, mfaToken, err := startLogin(loginReq.Email, loginReq.Password, r.UserAgent(), clientIP(r))
+    if errors.Is(err, errInvalidCredentials) {
         response := AuthResponse{
             Success: false,
             Message: "Invalid email or password",
@@ -97,16 +179,44 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // If login successful, respond with user information
+    if errors.Is(err, errEmailNotVerified) {
+        writeJSON(w, http.StatusForbidden, AuthResponse{
+            Success: false,
+            Message: "Email address not verified",
+        })
+        return
+    }
+    if err != nil {
+        http.Error(w, "Failed to log in", http.StatusInternalServerError)
+        return
+    }
+
+    if mfaToken != "" {
+        writeJSON(w, http.StatusOK, AuthResponse{
+            Success:  false,
+            Message:  "MFA code required",
+            MFAToken: mfaToken,
+        })
+        return
+    }
+
+    setSessionCookie(w, token)
     response := AuthResponse{
         Success: true,
         Message: "Login successful",
+        Token:   token,
     }
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(response)
 }
 
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
 // Simulated database operations (replace with actual database interactions)
 func userExists(email string) bool {
     for _, user := range users {
@@ -117,12 +227,60 @@ func userExists(email string) bool {
     return false
 }
 
+var (
+    errUserExists         = errors.New("user already exists")
+    errInvalidCredentials = errors.New("invalid email or password")
+    errEmailNotVerified   = errors.New("email address not verified")
+)
+
+// registerUser stores newUser, assigning its ID, and sends the verification
+// email. It returns the message to show the user.
+func registerUser(ctx context.Context, newUser *User) (string, error) {
+    dbMu.Lock()
+    if userExists(newUser.Email) {
+        dbMu.Unlock()
+        return "", errUserExists
+    }
+    newUser.ID = len(users) + 1
+    newUser.EmailVerified = false
+    newUser.Roles = initialRoles(newUser.Email)
+    users = append(users, *newUser)
+    dbMu.Unlock()
+
+    // The account stays locked until the emailed link is followed
+    if err := sendVerification(ctx, *newUser); err != nil {
+        log.Printf("Failed to send verification email to user %d: %v", newUser.ID, err)
+        return "User registered, but the verification email could not be sent", nil
+    }
+    return "User registered, check your email to verify your account", nil
+}
+
+// startLogin checks credentials and opens a session, returning its token.
+// Users with MFA enabled get an MFA token for the second step instead.
+func startLogin(email, password, userAgent, ip string) (token, mfaToken string, err error) {
+    user, err := loginUser(email, password)
+    if err != nil {
+        return "", "", err
+    }
+    if !user.EmailVerified {
+        return "", "", errEmailNotVerified
+    }
+    if user.MFAEnabled {
+        mfaToken, err := newMFAChallenge(user)
+        return "", mfaToken, err
+    }
+    _, token, err = startSession(user, userAgent, ip, "", "")
+    return token, "", err
+}
+
 func loginUser(email, password string) (User, error) {
+    dbMu.Lock()
+    defer dbMu.Unlock()
     for _, user := range users {
         if user.Email == email && user.Password == password {
             return user, nil
         }
     }
-    return User{}, nil // Replace with appropriate error handling
+    return User{}, errInvalidCredentials
 }
 
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "net/http"
+    "net/url"
+
+    "github.com/sys-apps-go/microservices/authz"
+)
+
+// OAuthClient is a relying party registered to sign users in through the
+// OpenID Connect endpoints.
+type OAuthClient struct {
+    ID           string   `json:"clientId"`
+    Name         string   `json:"name"`
+    RedirectURIs []string `json:"redirectUris"`
+    // Public clients (browser and mobile apps) cannot keep a secret and
+    // rely on PKCE alone.
+    Public bool `json:"public"`
+    // FirstParty clients are our own apps, which users are not asked to
+    // consent to. Everyone else's are.
+    FirstParty bool   `json:"firstParty"`
+    SecretHash string `json:"-"`
+}
+
+type RegisterClientResponse struct {
+    OAuthClient
+    // ClientSecret is only ever shown in the registration response
+    ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+// Registered OAuth clients keyed by client ID, guarded by dbMu
+var oauthClients = map[string]*OAuthClient{}
+
+// validRedirectURI only admits absolute https URIs, or plain http for
+// local development.
+func validRedirectURI(raw string) bool {
+    u, err := url.Parse(raw)
+    if err != nil || u.Fragment != "" || u.Host == "" {
+        return false
+    }
+    return u.Scheme == "https" || (u.Scheme == "http" && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1"))
+}
+
+func (c *OAuthClient) allowsRedirect(uri string) bool {
+    for _, registered := range c.RedirectURIs {
+        if registered == uri {
+            return true
+        }
+    }
+    return false
+}
+
+// authenticateClient returns the client making a token request, checking
+// its secret unless it is public. Credentials are read from HTTP Basic auth
+// or the form body.
+func authenticateClient(r *http.Request) (*OAuthClient, bool) {
+    id, secret, basic := r.BasicAuth()
+    if !basic {
+        id = r.PostForm.Get("client_id")
+        secret = r.PostForm.Get("client_secret")
+    }
+
+    dbMu.Lock()
+    client, ok := oauthClients[id]
+    dbMu.Unlock()
+    if !ok {
+        return nil, false
+    }
+    if client.Public {
+        return client, secret == ""
+    }
+    if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+        return nil, false
+    }
+    return client, true
+}
+
+// RegisterClientHandler registers a relying party. Only admins may call it.
+func RegisterClientHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    var req struct {
+        Name         string   `json:"name"`
+        RedirectURIs []string `json:"redirectUris"`
+        Public       bool     `json:"public"`
+        FirstParty   bool     `json:"firstParty"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    if req.Name == "" || len(req.RedirectURIs) == 0 {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Name and at least one redirect URI are required",
+        })
+        return
+    }
+    for _, uri := range req.RedirectURIs {
+        if !validRedirectURI(uri) {
+            writeJSON(w, http.StatusBadRequest, AuthResponse{
+                Success: false,
+                Message: "Invalid redirect URI " + uri,
+            })
+            return
+        }
+    }
+
+    id, err := randomToken()
+    if err != nil {
+        http.Error(w, "Failed to generate client ID", http.StatusInternalServerError)
+        return
+    }
+    response := RegisterClientResponse{
+        OAuthClient: OAuthClient{
+            ID:           id[:32],
+            Name:         req.Name,
+            RedirectURIs: req.RedirectURIs,
+            Public:       req.Public,
+            FirstParty:   req.FirstParty,
+        },
+    }
+    if !req.Public {
+        secret, err := randomToken()
+        if err != nil {
+            http.Error(w, "Failed to generate client secret", http.StatusInternalServerError)
+            return
+        }
+        response.ClientSecret = secret
+        response.SecretHash = hashToken(secret)
+    }
+
+    dbMu.Lock()
+    caller := findUserByID(current.UserID)
+    admin := caller != nil && hasRole(caller, authz.RoleAdmin)
+    if admin {
+        client := response.OAuthClient
+        oauthClients[client.ID] = &client
+    }
+    dbMu.Unlock()
+
+    if !admin {
+        writeJSON(w, http.StatusForbidden, AuthResponse{
+            Success: false,
+            Message: "Admin role required",
+        })
+        return
+    }
+    writeJSON(w, http.StatusCreated, response)
+}
@@ -5,6 +5,63 @@ CREATE TABLE users1 (
     first_name VARCHAR(100) NOT NULL,
     last_name VARCHAR(100) NOT NULL,
     email VARCHAR(100) UNIQUE NOT NULL,
-    password_hash VARCHAR(100) NOT NULL
+    password_hash VARCHAR(100) NOT NULL,
+    email_verified BOOLEAN NOT NULL DEFAULT false,
+    pending_email VARCHAR(100),
+    mfa_secret BYTEA,
+    mfa_enabled BOOLEAN NOT NULL DEFAULT false,
+    mfa_last_counter BIGINT NOT NULL DEFAULT 0,
+    roles TEXT[] NOT NULL DEFAULT '{customer}'
 );
 
+
+CREATE TABLE sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users1(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
+
+CREATE TABLE email_verification_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users1(id) ON DELETE CASCADE,
+    email VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE password_reset_tokens (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users1(id) ON DELETE CASCADE,
+    email VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE mfa_recovery_codes (
+    code_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users1(id) ON DELETE CASCADE
+);
+
+CREATE TABLE mfa_challenges (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users1(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE api_keys (
+    id VARCHAR(16) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users1(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    secret_hash CHAR(64) NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
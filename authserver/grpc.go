@@ -0,0 +1,85 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "strconv"
+
+    "github.com/sys-apps-go/microservices/auth"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// authService exposes signup, login and session checks to the other
+// services over gRPC. It shares its logic with the HTTP handlers.
+type authService struct {
+    auth.UnimplementedAuthServiceServer
+}
+
+func (authService) Signup(ctx context.Context, req *auth.SignupRequest) (*auth.SignupResponse, error) {
+    if req.Email == "" || req.Password == "" {
+        return nil, status.Error(codes.InvalidArgument, "email and password are required")
+    }
+    user := User{
+        FirstName: req.FirstName,
+        LastName:  req.LastName,
+        Email:     req.Email,
+        Password:  req.Password,
+    }
+    message, err := registerUser(ctx, &user)
+    if errors.Is(err, errUserExists) {
+        return nil, status.Error(codes.AlreadyExists, "User already exists")
+    }
+    if err != nil {
+        return nil, status.Error(codes.Internal, "failed to register user")
+    }
+    return &auth.SignupResponse{UserId: int32(user.ID), Message: message}, nil
+}
+
+func (authService) Login(ctx context.Context, req *auth.LoginRequest) (*auth.LoginResponse, error) {
+    token, mfaToken, err := startLogin(req.Email, req.Password, req.UserAgent, req.Ip)
+    switch {
+    case errors.Is(err, errInvalidCredentials):
+        return nil, status.Error(codes.Unauthenticated, "Invalid email or password")
+    case errors.Is(err, errEmailNotVerified):
+        return nil, status.Error(codes.FailedPrecondition, "Email address not verified")
+    case err != nil:
+        return nil, status.Error(codes.Internal, "failed to log in")
+    case mfaToken != "":
+        return &auth.LoginResponse{MfaToken: mfaToken, Message: "MFA code required"}, nil
+    }
+    return &auth.LoginResponse{Token: token, Message: "Login successful"}, nil
+}
+
+func (authService) VerifyToken(ctx context.Context, req *auth.VerifyTokenRequest) (*auth.VerifyTokenResponse, error) {
+    claims, err := verifier.Verify(req.Token)
+    if err != nil {
+        return nil, status.Error(codes.Unauthenticated, "invalid token")
+    }
+    session, err := liveSession(claims)
+    if err != nil {
+        return nil, status.Error(codes.Unauthenticated, err.Error())
+    }
+    // Services calling this serve first-party sessions only
+    if session.ClientID != "" {
+        return nil, status.Error(codes.Unauthenticated, errClientSession.Error())
+    }
+    return &auth.VerifyTokenResponse{
+        UserId:    strconv.Itoa(session.UserID),
+        SessionId: session.ID,
+        Roles:     claims.Roles,
+        ExpiresAt: timestamppb.New(session.ExpiresAt),
+    }, nil
+}
+
+func (authService) Logout(ctx context.Context, req *auth.LogoutRequest) (*auth.LogoutResponse, error) {
+    session, err := authenticateToken(req.Token)
+    if err != nil {
+        return nil, status.Error(codes.Unauthenticated, err.Error())
+    }
+    dbMu.Lock()
+    delete(sessions, session.ID)
+    dbMu.Unlock()
+    return &auth.LogoutResponse{Message: "Logged out"}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+    "context"
+    "net"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/auth"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/status"
+    "google.golang.org/grpc/test/bufconn"
+)
+
+func newTestAuthClient(t *testing.T) auth.AuthServiceClient {
+    t.Helper()
+    newTestAuthServer(t)
+
+    lis := bufconn.Listen(1 << 20)
+    s := grpc.NewServer()
+    auth.RegisterAuthServiceServer(s, authService{})
+    go s.Serve(lis)
+    t.Cleanup(s.Stop)
+
+    conn, err := grpc.Dial("bufnet",
+        grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+            return lis.DialContext(ctx)
+        }),
+        grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    return auth.NewAuthServiceClient(conn)
+}
+
+func TestAuthServiceSessionLifecycle(t *testing.T) {
+    client := newTestAuthClient(t)
+    ctx := context.Background()
+
+    signup, err := client.Signup(ctx, &auth.SignupRequest{FirstName: "New", Email: "new@example.com", Password: "pw"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := client.Signup(ctx, &auth.SignupRequest{Email: "new@example.com", Password: "pw"}); status.Code(err) != codes.AlreadyExists {
+        t.Errorf("duplicate signup: %v, want AlreadyExists", err)
+    }
+    if _, err := client.Login(ctx, &auth.LoginRequest{Email: "new@example.com", Password: "pw"}); status.Code(err) != codes.FailedPrecondition {
+        t.Errorf("login before verification: %v, want FailedPrecondition", err)
+    }
+    if _, err := client.Login(ctx, &auth.LoginRequest{Email: "new@example.com", Password: "wrong"}); status.Code(err) != codes.Unauthenticated {
+        t.Errorf("login with wrong password: %v, want Unauthenticated", err)
+    }
+
+    dbMu.Lock()
+    findUserByID(int(signup.UserId)).EmailVerified = true
+    dbMu.Unlock()
+
+    login, err := client.Login(ctx, &auth.LoginRequest{Email: "new@example.com", Password: "pw", UserAgent: "batch/1.0", Ip: "10.0.0.1"})
+    if err != nil || login.Token == "" {
+        t.Fatalf("login failed: %v %v", login, err)
+    }
+    verified, err := client.VerifyToken(ctx, &auth.VerifyTokenRequest{Token: login.Token})
+    if err != nil {
+        t.Fatal(err)
+    }
+    dbMu.Lock()
+    session := sessions[verified.SessionId]
+    dbMu.Unlock()
+    if session == nil || session.UserAgent != "batch/1.0" || session.IP != "10.0.0.1" {
+        t.Errorf("session does not record the end user: %+v", session)
+    }
+
+    if _, err := client.Logout(ctx, &auth.LogoutRequest{Token: "Bearer " + login.Token}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := client.VerifyToken(ctx, &auth.VerifyTokenRequest{Token: login.Token}); status.Code(err) != codes.Unauthenticated {
+        t.Errorf("token valid after logout: %v", err)
+    }
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "crypto"
+    "log"
+    "net/http"
+    "os"
+    "time"
+
+    "github.com/sys-apps-go/microservices/jwks"
+)
+
+// keySet signs session and ID tokens. Verifiers fetch its public keys from
+// /jwks.json.
+var keySet *jwks.KeySet
+
+// loadKeySet builds the key set from the environment: SIGNING_ALG picks
+// RS256 (default) or EdDSA, and SIGNING_KEY_FILE optionally holds the PEM
+// key to start with so tokens survive a restart until the first rotation.
+func loadKeySet() *jwks.KeySet {
+    alg := envOr("SIGNING_ALG", jwks.RS256)
+
+    var seed crypto.Signer
+    if path := os.Getenv("SIGNING_KEY_FILE"); path != "" {
+        var err error
+        if seed, err = jwks.ReadPrivateKey(path); err != nil {
+            log.Fatalf("Failed to load signing key: %v", err)
+        }
+    } else {
+        log.Println("SIGNING_KEY_FILE not set, generating a temporary signing key")
+    }
+
+    // Retired keys stay published until every token they signed expired
+    ks, err := jwks.NewKeySet(alg, sessionTTL, seed)
+    if err != nil {
+        log.Fatalf("Failed to create signing keys: %v", err)
+    }
+    return ks
+}
+
+// keyRotationInterval reads KEY_ROTATION_INTERVAL, a Go duration, which
+// defaults to a day.
+func keyRotationInterval() time.Duration {
+    interval, err := time.ParseDuration(envOr("KEY_ROTATION_INTERVAL", "24h"))
+    if err != nil || interval <= 0 {
+        log.Fatalf("Invalid KEY_ROTATION_INTERVAL: %v", os.Getenv("KEY_ROTATION_INTERVAL"))
+    }
+    return interval
+}
+
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+    // Short enough that verifiers see the pre-published next key well
+    // before it starts signing
+    w.Header().Set("Cache-Control", "public, max-age=300")
+    writeJSON(w, http.StatusOK, keySet.Public())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/smtp"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Message is an email sent to a user.
+type Message struct {
+    To      string
+    Subject string
+    Body    string
+}
+
+// Mailer delivers messages to users.
+type Mailer interface {
+    Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends messages through an SMTP relay.
+type SMTPMailer struct {
+    Addr string // host:port of the relay
+    From string
+    Auth smtp.Auth
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+    var b strings.Builder
+    fmt.Fprintf(&b, "From: %s\r\n", m.From)
+    fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+    fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+    fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+    b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+    b.WriteString(msg.Body)
+
+    // net/smtp has no context support; run the send in the background so
+    // callers are not held past their deadline.
+    done := make(chan error, 1)
+    go func() {
+        done <- smtp.SendMail(m.Addr, m.Auth, m.From, []string{msg.To}, []byte(b.String()))
+    }()
+    select {
+    case err := <-done:
+        return err
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// LogMailer writes messages to w instead of delivering them. It is meant for
+// development and tests.
+type LogMailer struct {
+    mu sync.Mutex
+    w  io.Writer
+}
+
+func NewLogMailer(w io.Writer) *LogMailer {
+    return &LogMailer{w: w}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    _, err := fmt.Fprintf(m.w, "To: %s\nSubject: %s\n\n%s\n---\n", msg.To, msg.Subject, msg.Body)
+    return err
+}
+
+// newMailerFromEnv uses SMTP when SMTP_ADDR is set, otherwise appends
+// messages to MAIL_LOG_FILE or, failing that, the server log.
+func newMailerFromEnv() Mailer {
+    if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+        m := &SMTPMailer{Addr: addr, From: os.Getenv("SMTP_FROM")}
+        if m.From == "" {
+            m.From = "no-reply@localhost"
+        }
+        if user := os.Getenv("SMTP_USERNAME"); user != "" {
+            host := strings.Split(addr, ":")[0]
+            m.Auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+        }
+        log.Printf("Sending mail through SMTP relay %s", addr)
+        return m
+    }
+    if path := os.Getenv("MAIL_LOG_FILE"); path != "" {
+        f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+        if err != nil {
+            log.Fatalf("Failed to open mail log %s: %v", path, err)
+        }
+        log.Printf("Writing outgoing mail to %s", path)
+        return NewLogMailer(f)
+    }
+    log.Println("SMTP_ADDR not set, writing outgoing mail to the log")
+    return NewLogMailer(log.Writer())
+}
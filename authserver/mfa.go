@@ -0,0 +1,278 @@
+package main
+
+import (
+    "crypto/rand"
+    "crypto/subtle"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+)
+
+const (
+    mfaIssuer = "Microservices"
+
+    // mfaChallengeTTL is how long a user has to enter their code after
+    // giving the right password.
+    mfaChallengeTTL = 5 * time.Minute
+
+    // mfaMaxAttempts is how many wrong codes a challenge survives.
+    mfaMaxAttempts = 5
+
+    recoveryCodeCount = 10
+)
+
+// mfaChallenge is the state between the two login steps of an MFA user.
+type mfaChallenge struct {
+    UserID    int
+    ExpiresAt time.Time
+    Attempts  int
+}
+
+// Pending MFA challenges keyed by token hash, guarded by dbMu
+var mfaChallenges = map[string]*mfaChallenge{}
+
+type MFAEnrollResponse struct {
+    Secret     string `json:"secret"`
+    OtpauthURI string `json:"otpauthUri"`
+}
+
+type RecoveryCodesResponse struct {
+    Success       bool     `json:"success"`
+    Message       string   `json:"message"`
+    RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// newRecoveryCodes returns fresh recovery codes along with the hashes to
+// store for them.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+    for i := 0; i < recoveryCodeCount; i++ {
+        b := make([]byte, 5)
+        if _, err := rand.Read(b); err != nil {
+            return nil, nil, err
+        }
+        code := strings.ToLower(totpEncoding.EncodeToString(b))
+        code = code[:4] + "-" + code[4:]
+        codes = append(codes, code)
+        hashes = append(hashes, hashToken(code))
+    }
+    return codes, hashes, nil
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code for user, consuming whichever matched. The caller must hold dbMu.
+func checkSecondFactor(user *User, code, recoveryCode string) bool {
+    if code != "" {
+        counter, ok := defaultTOTP.Verify(user.MFASecret, code, time.Now(), user.MFALastCounter)
+        if ok {
+            user.MFALastCounter = counter
+        }
+        return ok
+    }
+    if recoveryCode != "" {
+        hash := hashToken(strings.ToLower(strings.TrimSpace(recoveryCode)))
+        for i, stored := range user.RecoveryCodes {
+            if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+                user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// newMFAChallenge starts the second login step for user and returns the
+// token the client must present along with a code.
+func newMFAChallenge(user User) (string, error) {
+    token, err := randomToken()
+    if err != nil {
+        return "", err
+    }
+    dbMu.Lock()
+    mfaChallenges[hashToken(token)] = &mfaChallenge{
+        UserID:    user.ID,
+        ExpiresAt: time.Now().Add(mfaChallengeTTL),
+    }
+    dbMu.Unlock()
+    return token, nil
+}
+
+// EnrollMFAHandler generates a new secret for the user. MFA is not enforced
+// until the first code is confirmed through /mfa/activate.
+func EnrollMFAHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    secret := make([]byte, 20)
+    if _, err := rand.Read(secret); err != nil {
+        http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+        return
+    }
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    var email string
+    enabled := user != nil && user.MFAEnabled
+    if user != nil && !enabled {
+        user.MFASecret = secret
+        user.MFALastCounter = 0
+        email = user.Email
+    }
+    dbMu.Unlock()
+
+    if user == nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    if enabled {
+        writeJSON(w, http.StatusConflict, AuthResponse{
+            Success: false,
+            Message: "MFA is already enabled",
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, MFAEnrollResponse{
+        Secret:     totpEncoding.EncodeToString(secret),
+        OtpauthURI: defaultTOTP.URI(mfaIssuer, email, secret),
+    })
+}
+
+// ActivateMFAHandler turns MFA on once the user proves their authenticator
+// produces valid codes, and hands out recovery codes.
+func ActivateMFAHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    var req struct {
+        Code string `json:"code"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    codes, hashes, err := newRecoveryCodes()
+    if err != nil {
+        http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+        return
+    }
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    ok := user != nil && !user.MFAEnabled && user.MFASecret != nil &&
+        checkSecondFactor(user, req.Code, "")
+    if ok {
+        user.MFAEnabled = true
+        user.RecoveryCodes = hashes
+    }
+    dbMu.Unlock()
+
+    if !ok {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Invalid code or no pending enrollment",
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, RecoveryCodesResponse{
+        Success:       true,
+        Message:       "MFA enabled, store these recovery codes somewhere safe",
+        RecoveryCodes: codes,
+    })
+}
+
+// DisableMFAHandler turns MFA off. The user has to present their password and
+// a second factor again, so a stolen session alone cannot do it.
+func DisableMFAHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    var req struct {
+        Password     string `json:"password"`
+        Code         string `json:"code"`
+        RecoveryCode string `json:"recoveryCode"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    ok := user != nil && user.MFAEnabled && user.Password == req.Password &&
+        checkSecondFactor(user, req.Code, req.RecoveryCode)
+    if ok {
+        user.MFAEnabled = false
+        user.MFASecret = nil
+        user.MFALastCounter = 0
+        user.RecoveryCodes = nil
+    }
+    dbMu.Unlock()
+
+    if !ok {
+        writeJSON(w, http.StatusUnauthorized, AuthResponse{
+            Success: false,
+            Message: "Invalid password or code",
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "MFA disabled",
+    })
+}
+
+// LoginMFAHandler completes a login started by LoginHandler for a user with
+// MFA enabled.
+func LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        MFAToken     string `json:"mfaToken"`
+        Code         string `json:"code"`
+        RecoveryCode string `json:"recoveryCode"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    hash := hashToken(req.MFAToken)
+
+    dbMu.Lock()
+    challenge, ok := mfaChallenges[hash]
+    if ok && time.Now().After(challenge.ExpiresAt) {
+        delete(mfaChallenges, hash)
+        ok = false
+    }
+    var user *User
+    if ok {
+        user = findUserByID(challenge.UserID)
+    }
+    verified := user != nil && user.MFAEnabled && checkSecondFactor(user, req.Code, req.RecoveryCode)
+    if verified {
+        delete(mfaChallenges, hash)
+    } else if ok {
+        challenge.Attempts++
+        if challenge.Attempts >= mfaMaxAttempts {
+            delete(mfaChallenges, hash)
+        }
+    }
+    var loggedIn User
+    if verified {
+        loggedIn = *user
+    }
+    dbMu.Unlock()
+
+    if !verified {
+        writeJSON(w, http.StatusUnauthorized, AuthResponse{
+            Success: false,
+            Message: "Invalid or expired code",
+        })
+        return
+    }
+
+    token, err := createSession(loggedIn, r)
+    if err != nil {
+        http.Error(w, "Failed to create session", http.StatusInternalServerError)
+        return
+    }
+    setSessionCookie(w, token)
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "Login successful",
+        Token:   token,
+    })
+}
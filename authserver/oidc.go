@@ -0,0 +1,419 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "errors"
+    "html/template"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+)
+
+const (
+    // authCodeTTL is how long a client has to redeem an authorization code.
+    authCodeTTL = time.Minute
+
+    // idTokenTTL is the lifetime of issued ID tokens.
+    idTokenTTL = time.Hour
+
+    // consentTTL is how long a user has to answer the consent page.
+    consentTTL = 10 * time.Minute
+)
+
+// authCode is an issued authorization code waiting to be redeemed.
+type authCode struct {
+    ClientID      string
+    RedirectURI   string
+    Scope         string
+    Nonce         string
+    CodeChallenge string
+    UserID        int
+    AuthTime      time.Time
+    ExpiresAt     time.Time
+}
+
+// Pending authorization codes keyed by code hash, guarded by dbMu
+var authCodes = map[string]*authCode{}
+
+// consentRequest is an authorization waiting for the user to allow or deny
+// it on the consent page. It may only be answered from the session that
+// asked for it.
+type consentRequest struct {
+    Code      authCode
+    State     string
+    SessionID string
+    ExpiresAt time.Time
+}
+
+// Pending consent requests keyed by the hash of the token in the consent
+// form, guarded by dbMu
+var consentRequests = map[string]*consentRequest{}
+
+// consentPage asks the user whether to let a client sign them in.
+var consentPage = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<title>Sign in to {{.Client}}</title>
+<p>{{.Client}} wants to sign you in and see:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<form method="post" action="/oauth2/authorize">
+<input type="hidden" name="consent" value="{{.Token}}">
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+`))
+
+// scopeDescriptions says what each scope shows a client, for the consent
+// page.
+var scopeDescriptions = map[string]string{
+    "openid":  "your account ID",
+    "email":   "your email address",
+    "profile": "your name",
+}
+
+type TokenResponse struct {
+    AccessToken string `json:"access_token"`
+    TokenType   string `json:"token_type"`
+    ExpiresIn   int    `json:"expires_in"`
+    IDToken     string `json:"id_token"`
+    Scope       string `json:"scope"`
+}
+
+// OAuthError is an error response as defined in RFC 6749 section 5.2.
+type OAuthError struct {
+    Error            string `json:"error"`
+    ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func hasScope(scope, want string) bool {
+    for _, s := range strings.Fields(scope) {
+        if s == want {
+            return true
+        }
+    }
+    return false
+}
+
+// userClaims returns the standard claims about user that scope grants
+// access to.
+func userClaims(user *User, scope string) jwt.MapClaims {
+    claims := jwt.MapClaims{"sub": strconv.Itoa(user.ID)}
+    if hasScope(scope, "email") {
+        claims["email"] = user.Email
+        claims["email_verified"] = user.EmailVerified
+    }
+    if hasScope(scope, "profile") {
+        claims["given_name"] = user.FirstName
+        claims["family_name"] = user.LastName
+        claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+    }
+    return claims
+}
+
+func signIDToken(user *User, code *authCode) (string, error) {
+    now := time.Now()
+    claims := userClaims(user, code.Scope)
+    claims["iss"] = publicURL
+    claims["aud"] = code.ClientID
+    claims["iat"] = now.Unix()
+    claims["exp"] = now.Add(idTokenTTL).Unix()
+    claims["auth_time"] = code.AuthTime.Unix()
+    if code.Nonce != "" {
+        claims["nonce"] = code.Nonce
+    }
+    return keySet.Sign(claims)
+}
+
+// verifyPKCE checks verifier against an S256 code challenge (RFC 7636).
+func verifyPKCE(verifier, challenge string) bool {
+    if len(verifier) < 43 || len(verifier) > 128 {
+        return false
+    }
+    sum := sha256.Sum256([]byte(verifier))
+    computed := base64.RawURLEncoding.EncodeToString(sum[:])
+    return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// redirectWith sends the user agent back to the client's redirect URI with
+// params added to its query.
+func redirectWith(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+    u, err := url.Parse(redirectURI)
+    if err != nil {
+        http.Error(w, "Invalid redirect URI", http.StatusBadRequest)
+        return
+    }
+    q := u.Query()
+    for k, vs := range params {
+        for _, v := range vs {
+            if v != "" {
+                q.Add(k, v)
+            }
+        }
+    }
+    u.RawQuery = q.Encode()
+    http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func DiscoveryHandler(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "issuer":                                publicURL,
+        "authorization_endpoint":                publicURL + "/oauth2/authorize",
+        "token_endpoint":                        publicURL + "/oauth2/token",
+        "userinfo_endpoint":                     publicURL + "/oauth2/userinfo",
+        "jwks_uri":                              publicURL + "/jwks.json",
+        "response_types_supported":              []string{"code"},
+        "grant_types_supported":                 []string{"authorization_code"},
+        "subject_types_supported":               []string{"public"},
+        "id_token_signing_alg_values_supported": []string{keySet.Alg()},
+        "scopes_supported":                      []string{"openid", "email", "profile"},
+        "token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+        "code_challenge_methods_supported":      []string{"S256"},
+        "claims_supported": []string{
+            "sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+            "email", "email_verified", "name", "given_name", "family_name",
+        },
+    })
+}
+
+// AuthorizeHandler implements the authorization endpoint of the code flow.
+// The user must already be logged in through /login, which leaves a
+// session cookie behind. Unless the client is first-party, the user is
+// then asked to consent on a page that posts to ConsentHandler.
+func AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    redirectURI := q.Get("redirect_uri")
+
+    dbMu.Lock()
+    client, ok := oauthClients[q.Get("client_id")]
+    dbMu.Unlock()
+    // Errors are only reported back to the client once its redirect URI is
+    // known to be genuine.
+    if !ok || !client.allowsRedirect(redirectURI) {
+        writeJSON(w, http.StatusBadRequest, OAuthError{
+            Error:            "invalid_request",
+            ErrorDescription: "unknown client or redirect URI",
+        })
+        return
+    }
+
+    state := q.Get("state")
+    fail := func(code, description string) {
+        redirectWith(w, r, redirectURI, url.Values{
+            "error":             {code},
+            "error_description": {description},
+            "state":             {state},
+        })
+    }
+    if q.Get("response_type") != "code" {
+        fail("unsupported_response_type", "only the authorization code flow is supported")
+        return
+    }
+    scope := q.Get("scope")
+    if !hasScope(scope, "openid") {
+        fail("invalid_scope", "scope must include openid")
+        return
+    }
+    if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+        fail("invalid_request", "PKCE with code_challenge_method S256 is required")
+        return
+    }
+
+    session, err := authenticateCookie(r)
+    if err != nil {
+        fail("login_required", "the user is not logged in")
+        return
+    }
+
+    code := authCode{
+        ClientID:      client.ID,
+        RedirectURI:   redirectURI,
+        Scope:         scope,
+        Nonce:         q.Get("nonce"),
+        CodeChallenge: q.Get("code_challenge"),
+        UserID:        session.UserID,
+        AuthTime:      session.CreatedAt,
+    }
+    if client.FirstParty {
+        issueCode(w, r, code, state)
+        return
+    }
+
+    token, err := randomToken()
+    if err != nil {
+        fail("server_error", "failed to ask for consent")
+        return
+    }
+    dbMu.Lock()
+    consentRequests[hashToken(token)] = &consentRequest{
+        Code:      code,
+        State:     state,
+        SessionID: session.ID,
+        ExpiresAt: time.Now().Add(consentTTL),
+    }
+    dbMu.Unlock()
+
+    var scopes []string
+    for _, s := range strings.Fields(scope) {
+        if description, ok := scopeDescriptions[s]; ok {
+            scopes = append(scopes, description)
+        }
+    }
+    // The page must not be framed, or another site could trick the user
+    // into clicking Allow.
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Frame-Options", "DENY")
+    w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+    consentPage.Execute(w, map[string]interface{}{"Client": client.Name, "Scopes": scopes, "Token": token})
+}
+
+// ConsentHandler takes the user's answer from the consent page and sends
+// them back to the client with a code, or with access_denied.
+func ConsentHandler(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    hash := hashToken(r.PostForm.Get("consent"))
+    session, err := authenticateCookie(r)
+
+    dbMu.Lock()
+    pending, ok := consentRequests[hash]
+    delete(consentRequests, hash)
+    dbMu.Unlock()
+    if !ok || time.Now().After(pending.ExpiresAt) || err != nil || session.ID != pending.SessionID {
+        writeJSON(w, http.StatusBadRequest, OAuthError{
+            Error:            "invalid_request",
+            ErrorDescription: "unknown or expired consent request",
+        })
+        return
+    }
+
+    if r.PostForm.Get("decision") != "allow" {
+        redirectWith(w, r, pending.Code.RedirectURI, url.Values{
+            "error":             {"access_denied"},
+            "error_description": {"the user denied the request"},
+            "state":             {pending.State},
+        })
+        return
+    }
+    issueCode(w, r, pending.Code, pending.State)
+}
+
+// issueCode stores code under a fresh authorization code and sends the
+// user agent back to the client with it.
+func issueCode(w http.ResponseWriter, r *http.Request, code authCode, state string) {
+    raw, err := randomToken()
+    if err != nil {
+        redirectWith(w, r, code.RedirectURI, url.Values{
+            "error":             {"server_error"},
+            "error_description": {"failed to issue code"},
+            "state":             {state},
+        })
+        return
+    }
+    code.ExpiresAt = time.Now().Add(authCodeTTL)
+    dbMu.Lock()
+    authCodes[hashToken(raw)] = &code
+    dbMu.Unlock()
+
+    redirectWith(w, r, code.RedirectURI, url.Values{"code": {raw}, "state": {state}})
+}
+
+var errInvalidGrant = errors.New("invalid authorization code")
+
+// redeemCode consumes an authorization code issued to client and returns it
+// along with a copy of the user it was issued for.
+func redeemCode(client *OAuthClient, code, redirectURI, verifier string) (*authCode, User, error) {
+    hash := hashToken(code)
+
+    dbMu.Lock()
+    defer dbMu.Unlock()
+    issued, ok := authCodes[hash]
+    delete(authCodes, hash)
+    if !ok || time.Now().After(issued.ExpiresAt) ||
+        issued.ClientID != client.ID || issued.RedirectURI != redirectURI ||
+        !verifyPKCE(verifier, issued.CodeChallenge) {
+        return nil, User{}, errInvalidGrant
+    }
+    user := findUserByID(issued.UserID)
+    if user == nil {
+        return nil, User{}, errInvalidGrant
+    }
+    return issued, *user, nil
+}
+
+// TokenHandler implements the token endpoint for the authorization_code
+// grant.
+func TokenHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Cache-Control", "no-store")
+    if err := r.ParseForm(); err != nil {
+        writeJSON(w, http.StatusBadRequest, OAuthError{Error: "invalid_request", ErrorDescription: "malformed request body"})
+        return
+    }
+
+    client, ok := authenticateClient(r)
+    if !ok {
+        w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+        writeJSON(w, http.StatusUnauthorized, OAuthError{Error: "invalid_client"})
+        return
+    }
+    if r.PostForm.Get("grant_type") != "authorization_code" {
+        writeJSON(w, http.StatusBadRequest, OAuthError{Error: "unsupported_grant_type"})
+        return
+    }
+
+    issued, user, err := redeemCode(client, r.PostForm.Get("code"), r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+    if err != nil {
+        writeJSON(w, http.StatusBadRequest, OAuthError{Error: "invalid_grant", ErrorDescription: err.Error()})
+        return
+    }
+
+    // The access token is an ordinary session token scoped to the client,
+    // so the user can see and revoke it from /sessions.
+    session, accessToken, err := openSession(user, r, client.ID, issued.Scope)
+    if err != nil {
+        writeJSON(w, http.StatusInternalServerError, OAuthError{Error: "server_error"})
+        return
+    }
+    idToken, err := signIDToken(&user, issued)
+    if err != nil {
+        writeJSON(w, http.StatusInternalServerError, OAuthError{Error: "server_error"})
+        return
+    }
+
+    writeJSON(w, http.StatusOK, TokenResponse{
+        AccessToken: accessToken,
+        TokenType:   "Bearer",
+        ExpiresIn:   int(time.Until(session.ExpiresAt) / time.Second),
+        IDToken:     idToken,
+        Scope:       issued.Scope,
+    })
+}
+
+// UserInfoHandler returns the claims the access token's scope grants.
+// Tokens from a plain login see every claim.
+func UserInfoHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    scope := current.Scope
+    if current.ClientID == "" {
+        scope = "openid email profile"
+    }
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    var claims jwt.MapClaims
+    if user != nil {
+        claims = userClaims(user, scope)
+    }
+    dbMu.Unlock()
+
+    if user == nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, http.StatusOK, claims)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/cookiejar"
+    "net/http/httptest"
+    "net/url"
+    "regexp"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/dgrijalva/jwt-go"
+    "github.com/sys-apps-go/microservices/auth"
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/jwks"
+    "google.golang.org/grpc/codes"
+    grpcstatus "google.golang.org/grpc/status"
+)
+
+// relyingParty is a minimal OpenID Connect client that knows nothing about
+// authserver beyond its issuer URL.
+type relyingParty struct {
+    issuer       string
+    clientID     string
+    clientSecret string
+    redirectURI  string
+
+    mu       sync.Mutex
+    verifier string
+    state    string
+    nonce    string
+    result   *rpResult
+}
+
+type rpResult struct {
+    err      error
+    claims   jwt.MapClaims
+    userinfo map[string]interface{}
+}
+
+func randomString(t *testing.T) string {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        t.Fatal(err)
+    }
+    return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (rp *relyingParty) discover() (map[string]interface{}, error) {
+    resp, err := http.Get(rp.issuer + "/.well-known/openid-configuration")
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    var doc map[string]interface{}
+    return doc, json.NewDecoder(resp.Body).Decode(&doc)
+}
+
+// authURL starts a login and returns where to send the browser.
+func (rp *relyingParty) authURL(t *testing.T) string {
+    doc, err := rp.discover()
+    if err != nil {
+        t.Fatal(err)
+    }
+    rp.mu.Lock()
+    defer rp.mu.Unlock()
+    rp.verifier, rp.state, rp.nonce = randomString(t), randomString(t), randomString(t)
+    sum := sha256.Sum256([]byte(rp.verifier))
+    q := url.Values{
+        "response_type":         {"code"},
+        "client_id":             {rp.clientID},
+        "redirect_uri":          {rp.redirectURI},
+        "scope":                 {"openid email"},
+        "state":                 {rp.state},
+        "nonce":                 {rp.nonce},
+        "code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+        "code_challenge_method": {"S256"},
+    }
+    return doc["authorization_endpoint"].(string) + "?" + q.Encode()
+}
+
+func (rp *relyingParty) exchange(code, verifier string) (*TokenResponse, int, error) {
+    doc, err := rp.discover()
+    if err != nil {
+        return nil, 0, err
+    }
+    form := url.Values{
+        "grant_type":    {"authorization_code"},
+        "code":          {code},
+        "redirect_uri":  {rp.redirectURI},
+        "code_verifier": {verifier},
+    }
+    req, _ := http.NewRequest(http.MethodPost, doc["token_endpoint"].(string), strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.SetBasicAuth(rp.clientID, rp.clientSecret)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer resp.Body.Close()
+    var tokens TokenResponse
+    if resp.StatusCode != http.StatusOK {
+        return nil, resp.StatusCode, nil
+    }
+    return &tokens, resp.StatusCode, json.NewDecoder(resp.Body).Decode(&tokens)
+}
+
+// verifyIDToken checks the ID token signature against the published JWKS
+// and its issuer, audience and nonce.
+func (rp *relyingParty) verifyIDToken(raw string) (jwt.MapClaims, error) {
+    doc, err := rp.discover()
+    if err != nil {
+        return nil, err
+    }
+    claims := jwt.MapClaims{}
+    _, err = jwt.ParseWithClaims(raw, claims, jwks.NewVerifier(doc["jwks_uri"].(string)).Keyfunc)
+    if err != nil {
+        return nil, err
+    }
+    if claims["iss"] != rp.issuer || !claims.VerifyAudience(rp.clientID, true) || claims["nonce"] != rp.nonce {
+        return nil, fmt.Errorf("unexpected claims %v", claims)
+    }
+    return claims, nil
+}
+
+func (rp *relyingParty) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    rp.mu.Lock()
+    verifier, state := rp.verifier, rp.state
+    rp.mu.Unlock()
+
+    res := &rpResult{}
+    defer func() {
+        rp.mu.Lock()
+        rp.result = res
+        rp.mu.Unlock()
+    }()
+    q := r.URL.Query()
+    if q.Get("state") != state || q.Get("code") == "" {
+        res.err = fmt.Errorf("bad callback %s", r.URL.RawQuery)
+        return
+    }
+    tokens, status, err := rp.exchange(q.Get("code"), verifier)
+    if err != nil || tokens == nil {
+        res.err = fmt.Errorf("exchange failed with status %d: %v", status, err)
+        return
+    }
+    if res.claims, res.err = rp.verifyIDToken(tokens.IDToken); res.err != nil {
+        return
+    }
+
+    doc, _ := rp.discover()
+    req, _ := http.NewRequest(http.MethodGet, doc["userinfo_endpoint"].(string), nil)
+    req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        res.err = err
+        return
+    }
+    defer resp.Body.Close()
+    res.err = json.NewDecoder(resp.Body).Decode(&res.userinfo)
+}
+
+var consentField = regexp.MustCompile(`name="consent" value="([^"]+)"`)
+
+// answerConsent reads the consent page in resp and answers it as browser.
+func answerConsent(t *testing.T, browser *http.Client, base string, resp *http.Response, decision string) *http.Response {
+    t.Helper()
+    page, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    m := consentField.FindSubmatch(page)
+    if m == nil {
+        t.Fatalf("no consent form in %s", page)
+    }
+    resp, err := browser.PostForm(base+"/oauth2/authorize", url.Values{"consent": {string(m[1])}, "decision": {decision}})
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    return resp
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+    srv := newTestAuthServer(t)
+
+    rp := &relyingParty{issuer: srv.URL}
+    rpSrv := httptest.NewServer(rp)
+    defer rpSrv.Close()
+    rp.redirectURI = rpSrv.URL + "/callback"
+
+    // An admin registers the relying party
+    adminToken := login(t, http.DefaultClient, srv.URL, "admin@example.com")
+    body, _ := json.Marshal(map[string]interface{}{"name": "partner", "redirectUris": []string{rp.redirectURI}})
+    req, _ := http.NewRequest(http.MethodPost, srv.URL+"/oauth2/clients", bytes.NewReader(body))
+    req.Header.Set("Authorization", "Bearer "+adminToken)
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var registered RegisterClientResponse
+    json.NewDecoder(resp.Body).Decode(&registered)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated || registered.ClientSecret == "" {
+        t.Fatalf("client registration failed: %d %+v", resp.StatusCode, registered)
+    }
+    rp.clientID, rp.clientSecret = registered.ID, registered.ClientSecret
+
+    // Without a session the user is sent back with login_required
+    jar, _ := cookiejar.New(nil)
+    browser := &http.Client{Jar: jar}
+    resp, err = browser.Get(rp.authURL(t))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if rp.result == nil || rp.result.err == nil {
+        t.Fatalf("expected login_required, got %+v", rp.result)
+    }
+
+    // The browser logs in and goes through the flow again. The partner is
+    // a third party, so Jane is asked first, and only her browser may
+    // answer.
+    login(t, browser, srv.URL, "jane@example.com")
+    rp.result = nil
+    resp, err = browser.Get(rp.authURL(t))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if resp.Header.Get("X-Frame-Options") != "DENY" || rp.result != nil {
+        t.Errorf("consent page framable or skipped: %v, %+v", resp.Header, rp.result)
+    }
+    otherJar, _ := cookiejar.New(nil)
+    other := &http.Client{Jar: otherJar}
+    login(t, other, srv.URL, "admin@example.com")
+    if resp := answerConsent(t, other, srv.URL, resp, "allow"); resp.StatusCode != http.StatusBadRequest || rp.result != nil {
+        t.Errorf("consent from another session: status %d, %+v", resp.StatusCode, rp.result)
+    }
+
+    resp, err = browser.Get(rp.authURL(t))
+    if err != nil {
+        t.Fatal(err)
+    }
+    answerConsent(t, browser, srv.URL, resp, "deny")
+    if rp.result == nil || rp.result.err == nil || !strings.Contains(rp.result.err.Error(), "access_denied") {
+        t.Fatalf("expected access_denied, got %+v", rp.result)
+    }
+
+    resp, err = browser.Get(rp.authURL(t))
+    if err != nil {
+        t.Fatal(err)
+    }
+    answerConsent(t, browser, srv.URL, resp, "allow")
+    if rp.result == nil || rp.result.err != nil {
+        t.Fatalf("flow failed: %+v", rp.result)
+    }
+    if rp.result.claims["sub"] != "2" || rp.result.claims["email"] != "jane@example.com" {
+        t.Errorf("unexpected ID token claims %v", rp.result.claims)
+    }
+    if _, ok := rp.result.claims["given_name"]; ok {
+        t.Errorf("profile claims issued without profile scope: %v", rp.result.claims)
+    }
+    if rp.result.userinfo["sub"] != "2" || rp.result.userinfo["email"] != "jane@example.com" {
+        t.Errorf("unexpected userinfo %v", rp.result.userinfo)
+    }
+}
+
+func TestOIDCCodeCannotBeReusedOrStolen(t *testing.T) {
+    srv := newTestAuthServer(t)
+
+    rp := &relyingParty{issuer: srv.URL, clientID: "client", clientSecret: "s3cret", redirectURI: "http://localhost/cb"}
+    dbMu.Lock()
+    oauthClients["client"] = &OAuthClient{ID: "client", RedirectURIs: []string{rp.redirectURI}, FirstParty: true, SecretHash: hashToken("s3cret")}
+    dbMu.Unlock()
+
+    jar, _ := cookiejar.New(nil)
+    browser := &http.Client{
+        Jar: jar,
+        CheckRedirect: func(*http.Request, []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+    login(t, browser, srv.URL, "jane@example.com")
+
+    authorize := func() string {
+        resp, err := browser.Get(rp.authURL(t))
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        loc, err := url.Parse(resp.Header.Get("Location"))
+        if err != nil || loc.Query().Get("code") == "" {
+            t.Fatalf("no code in redirect %q", resp.Header.Get("Location"))
+        }
+        return loc.Query().Get("code")
+    }
+
+    code := authorize()
+    if _, status, _ := rp.exchange(code, "wrong-verifier-wrong-verifier-wrong-verifier"); status != http.StatusBadRequest {
+        t.Errorf("exchange with wrong PKCE verifier: status %d, want 400", status)
+    }
+    if _, status, _ := rp.exchange(code, rp.verifier); status != http.StatusBadRequest {
+        t.Errorf("code accepted after a failed redemption: status %d", status)
+    }
+
+    code = authorize()
+    if tokens, status, err := rp.exchange(code, rp.verifier); err != nil || tokens == nil {
+        t.Fatalf("exchange failed: %d %v", status, err)
+    }
+    if _, status, _ := rp.exchange(code, rp.verifier); status != http.StatusBadRequest {
+        t.Errorf("code redeemed twice: status %d", status)
+    }
+
+    rp.clientSecret = "guess"
+    code = authorize()
+    if _, status, _ := rp.exchange(code, rp.verifier); status != http.StatusUnauthorized {
+        t.Errorf("exchange with wrong client secret: status %d, want 401", status)
+    }
+}
+
+func TestOIDCAccessTokenOnlyReachesUserInfo(t *testing.T) {
+    srv := newTestAuthServer(t)
+
+    rp := &relyingParty{issuer: srv.URL, clientID: "client", clientSecret: "s3cret", redirectURI: "http://localhost/cb"}
+    dbMu.Lock()
+    oauthClients["client"] = &OAuthClient{ID: "client", RedirectURIs: []string{rp.redirectURI}, FirstParty: true, SecretHash: hashToken("s3cret")}
+    dbMu.Unlock()
+
+    jar, _ := cookiejar.New(nil)
+    browser := &http.Client{
+        Jar: jar,
+        CheckRedirect: func(*http.Request, []*http.Request) error {
+            return http.ErrUseLastResponse
+        },
+    }
+    // An admin's token must not hand the client admin rights
+    session := login(t, browser, srv.URL, "admin@example.com")
+    resp, err := browser.Get(rp.authURL(t))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    loc, _ := url.Parse(resp.Header.Get("Location"))
+    tokens, status, err := rp.exchange(loc.Query().Get("code"), rp.verifier)
+    if err != nil || tokens == nil {
+        t.Fatalf("exchange failed: %d %v", status, err)
+    }
+
+    claims, err := verifier.Verify(tokens.AccessToken)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(claims.Roles) != 0 || claims.Audience != "client" || claims.Scope != "openid email" {
+        t.Errorf("access token claims %+v, want no roles, audience client and the granted scope", claims)
+    }
+
+    for _, tt := range []struct {
+        path, token string
+        want        int
+    }{
+        {"/oauth2/userinfo", tokens.AccessToken, http.StatusOK},
+        {"/sessions", tokens.AccessToken, http.StatusForbidden},
+        {"/apikeys", tokens.AccessToken, http.StatusForbidden},
+        {"/oauth2/userinfo", tokens.IDToken, http.StatusUnauthorized},
+        {"/sessions", tokens.IDToken, http.StatusUnauthorized},
+    } {
+        if status := doJSON(t, http.MethodGet, srv.URL+tt.path, tt.token, nil, nil); status != tt.want {
+            t.Errorf("GET %s: status %d, want %d", tt.path, status, tt.want)
+        }
+    }
+
+    // Other services take neither token as a session.
+    services := &authz.Verifier{Keys: keySet.Keyfunc}
+    if _, err := services.Verify(session); err != nil {
+        t.Errorf("session token rejected: %v", err)
+    }
+    for name, token := range map[string]string{"access token": tokens.AccessToken, "ID token": tokens.IDToken} {
+        if _, err := services.Verify(token); !errors.Is(err, authz.ErrAudience) {
+            t.Errorf("%s: %v, want ErrAudience", name, err)
+        }
+    }
+    if _, err := (authService{}).VerifyToken(context.Background(), &auth.VerifyTokenRequest{Token: tokens.AccessToken}); grpcstatus.Code(err) != codes.Unauthenticated {
+        t.Errorf("VerifyToken with an access token: %v, want Unauthenticated", err)
+    }
+}
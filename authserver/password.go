@@ -0,0 +1,150 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+)
+
+// resetTTL is how long a password reset link stays usable.
+const resetTTL = time.Hour
+
+// Pending password reset tokens keyed by token hash, guarded by dbMu
+var resetTokens = map[string]*emailToken{}
+
+// passwordResetURL is the page the reset link points at, such as
+// apiserver's /password/reset. It gets the token in the query string and
+// is expected to POST it with the new password to /password/reset here,
+// which only takes POSTs, so there is no default.
+var passwordResetURL = os.Getenv("PASSWORD_RESET_URL")
+
+// sendPasswordReset mails user a single-use link to choose a new password.
+// Earlier links for the same user stop working.
+func sendPasswordReset(ctx context.Context, user User) error {
+    token, err := randomToken()
+    if err != nil {
+        return err
+    }
+
+    dbMu.Lock()
+    for hash, t := range resetTokens {
+        if t.UserID == user.ID {
+            delete(resetTokens, hash)
+        }
+    }
+    resetTokens[hashToken(token)] = &emailToken{
+        UserID:    user.ID,
+        Email:     user.Email,
+        ExpiresAt: time.Now().Add(resetTTL),
+    }
+    dbMu.Unlock()
+
+    link := passwordResetURL + "?token=" + url.QueryEscape(token)
+    return mailer.Send(ctx, Message{
+        To:      user.Email,
+        Subject: "Reset your password",
+        Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for this account. To choose a new one, open:\n\n%s\n\nThe link expires in %s. If this wasn't you, you can ignore this email.\n",
+            user.FirstName, link, resetTTL),
+    })
+}
+
+// revokeUserSessions ends every session of the user. The caller must hold
+// dbMu.
+func revokeUserSessions(userID int) {
+    for id, session := range sessions {
+        if session.UserID == userID {
+            delete(sessions, id)
+        }
+    }
+}
+
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Email string `json:"email"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    dbMu.Lock()
+    var user *User
+    if u := findUserByEmail(req.Email); u != nil {
+        copied := *u
+        user = &copied
+    }
+    dbMu.Unlock()
+
+    // Mail is sent in the background so response time does not reveal
+    // whether the address is registered.
+    if user != nil {
+        go func(user User) {
+            ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+            defer cancel()
+            if err := sendPasswordReset(ctx, user); err != nil {
+                log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+            }
+        }(*user)
+    }
+
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "If the address is registered, a password reset link has been sent",
+    })
+}
+
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Token    string `json:"token"`
+        Password string `json:"password"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    if req.Password == "" {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Password is required",
+        })
+        return
+    }
+
+    hash := hashToken(req.Token)
+
+    dbMu.Lock()
+    t, ok := resetTokens[hash]
+    delete(resetTokens, hash)
+    var user *User
+    if ok && time.Now().Before(t.ExpiresAt) {
+        user = findUserByID(t.UserID)
+    }
+    if user != nil && user.Email == t.Email {
+        user.Password = req.Password
+        // Whoever had the old password must not stay logged in
+        revokeUserSessions(user.ID)
+    } else {
+        user = nil
+    }
+    dbMu.Unlock()
+
+    if user == nil {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Invalid or expired reset link",
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "Password has been reset",
+    })
+}
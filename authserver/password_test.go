@@ -0,0 +1,38 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+func TestPasswordReset(t *testing.T) {
+    srv := newTestAuthServer(t)
+    mail := &recordingMailer{}
+    mailer = mail
+    passwordResetURL = "https://shop.example.com/password/reset"
+    token := login(t, http.DefaultClient, srv.URL, "jane@example.com")
+
+    doJSON(t, http.MethodPost, srv.URL+"/password/forgot", "", map[string]string{"email": "jane@example.com"}, nil)
+    mail.wait(t, "jane@example.com", 1)
+    link, err := url.Parse(mail.lastLink(t, "jane@example.com"))
+    if err != nil || !strings.HasPrefix(link.String(), passwordResetURL+"?") {
+        t.Fatalf("reset link %v does not open %s", link, passwordResetURL)
+    }
+
+    reset := map[string]string{"token": link.Query().Get("token"), "password": "new secret"}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/password/reset", "", reset, nil); status != http.StatusOK {
+        t.Fatalf("reset: status %d", status)
+    }
+    if status := doJSON(t, http.MethodPost, srv.URL+"/password/reset", "", reset, nil); status != http.StatusBadRequest {
+        t.Errorf("link used twice: status %d, want 400", status)
+    }
+    if status := doJSON(t, http.MethodGet, srv.URL+"/sessions", token, nil, nil); status != http.StatusUnauthorized {
+        t.Errorf("session from before the reset: status %d, want 401", status)
+    }
+    credentials := map[string]string{"email": "jane@example.com", "password": "new secret"}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/login", "", credentials, nil); status != http.StatusOK {
+        t.Errorf("login with the new password: status %d", status)
+    }
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+)
+
+// Profile is the part of a User its owner may see.
+type Profile struct {
+    ID            int      `json:"id"`
+    FirstName     string   `json:"firstName"`
+    LastName      string   `json:"lastName"`
+    Email         string   `json:"email"`
+    EmailVerified bool     `json:"emailVerified"`
+    PendingEmail  string   `json:"pendingEmail,omitempty"`
+    MFAEnabled    bool     `json:"mfaEnabled"`
+    Roles         []string `json:"roles"`
+}
+
+func profileOf(user *User) Profile {
+    return Profile{
+        ID:            user.ID,
+        FirstName:     user.FirstName,
+        LastName:      user.LastName,
+        Email:         user.Email,
+        EmailVerified: user.EmailVerified,
+        PendingEmail:  user.PendingEmail,
+        MFAEnabled:    user.MFAEnabled,
+        Roles:         user.Roles,
+    }
+}
+
+func GetProfileHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    var profile Profile
+    if user != nil {
+        profile = profileOf(user)
+    }
+    dbMu.Unlock()
+
+    if user == nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, http.StatusOK, profile)
+}
+
+func UpdateProfileHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    // Fields left out of the body are not changed
+    var req struct {
+        FirstName *string `json:"firstName"`
+        LastName  *string `json:"lastName"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    if (req.FirstName != nil && strings.TrimSpace(*req.FirstName) == "") ||
+        (req.LastName != nil && strings.TrimSpace(*req.LastName) == "") {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Names cannot be empty",
+        })
+        return
+    }
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    var profile Profile
+    if user != nil {
+        if req.FirstName != nil {
+            user.FirstName = *req.FirstName
+        }
+        if req.LastName != nil {
+            user.LastName = *req.LastName
+        }
+        profile = profileOf(user)
+    }
+    dbMu.Unlock()
+
+    if user == nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, http.StatusOK, profile)
+}
+
+// ChangeEmailHandler starts an email change. The new address only replaces
+// the current one once the link mailed to it is followed.
+func ChangeEmailHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    var req struct {
+        Email    string `json:"email"`
+        Password string `json:"password"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    if !strings.Contains(req.Email, "@") {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "A valid email address is required",
+        })
+        return
+    }
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    if user == nil || user.Password != req.Password {
+        dbMu.Unlock()
+        writeJSON(w, http.StatusUnauthorized, AuthResponse{
+            Success: false,
+            Message: "Invalid password",
+        })
+        return
+    }
+    if userExists(req.Email) {
+        dbMu.Unlock()
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Email address is already in use",
+        })
+        return
+    }
+    user.PendingEmail = req.Email
+    pending := *user
+    pending.Email = req.Email
+    dbMu.Unlock()
+
+    if err := sendVerification(r.Context(), pending); err != nil {
+        log.Printf("Failed to send verification email to user %d: %v", pending.ID, err)
+        writeJSON(w, http.StatusInternalServerError, AuthResponse{
+            Success: false,
+            Message: "Failed to send verification email",
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "Check your new email address to confirm the change",
+    })
+}
+
+// ChangePasswordHandler replaces the password after checking the current
+// one. Every other session of the user is logged out.
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    var req struct {
+        CurrentPassword string `json:"currentPassword"`
+        NewPassword     string `json:"newPassword"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    if req.NewPassword == "" {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "New password is required",
+        })
+        return
+    }
+
+    dbMu.Lock()
+    user := findUserByID(current.UserID)
+    ok := user != nil && user.Password == req.CurrentPassword
+    if ok {
+        user.Password = req.NewPassword
+        revokeUserSessions(user.ID)
+        sessions[current.ID] = current
+    }
+    dbMu.Unlock()
+
+    if !ok {
+        writeJSON(w, http.StatusUnauthorized, AuthResponse{
+            Success: false,
+            Message: "Current password is incorrect",
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "Password changed",
+    })
+}
+
+// confirmEmailChange swaps in the pending address of user once it has been
+// verified. The caller must hold dbMu.
+func confirmEmailChange(user *User, email string) bool {
+    if user.PendingEmail != email || userExists(email) {
+        return false
+    }
+    user.Email = email
+    user.PendingEmail = ""
+    user.EmailVerified = true
+    return true
+}
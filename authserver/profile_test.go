@@ -0,0 +1,103 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+)
+
+func TestProfile(t *testing.T) {
+    srv := newTestAuthServer(t)
+    token := login(t, http.DefaultClient, srv.URL, "jane@example.com")
+
+    if status := doJSON(t, http.MethodGet, srv.URL+"/profile", "", nil, nil); status != http.StatusUnauthorized {
+        t.Errorf("profile without a session: status %d", status)
+    }
+    var profile Profile
+    if status := doJSON(t, http.MethodGet, srv.URL+"/profile", token, nil, &profile); status != http.StatusOK {
+        t.Fatalf("get profile: status %d", status)
+    }
+    if profile.ID != 2 || profile.Email != "jane@example.com" || !profile.EmailVerified || len(profile.Roles) != 1 {
+        t.Errorf("unexpected profile %+v", profile)
+    }
+
+    // Only the fields sent change.
+    if status := doJSON(t, http.MethodPatch, srv.URL+"/profile", token, map[string]string{"lastName": "Roe"}, &profile); status != http.StatusOK {
+        t.Fatalf("update profile: status %d", status)
+    }
+    if profile.FirstName != "Jane" || profile.LastName != "Roe" {
+        t.Errorf("after update: %+v", profile)
+    }
+    if status := doJSON(t, http.MethodPatch, srv.URL+"/profile", token, map[string]string{"firstName": " "}, nil); status != http.StatusBadRequest {
+        t.Errorf("empty first name: status %d, want 400", status)
+    }
+}
+
+func TestChangeEmail(t *testing.T) {
+    srv := newTestAuthServer(t)
+    mail := &recordingMailer{}
+    mailer = mail
+    token := login(t, http.DefaultClient, srv.URL, "jane@example.com")
+
+    tests := []struct {
+        email, password string
+        want            int
+    }{
+        {"not an address", "secret", http.StatusBadRequest},
+        {"jane2@example.com", "wrong", http.StatusUnauthorized},
+        {"admin@example.com", "secret", http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        req := map[string]string{"email": tt.email, "password": tt.password}
+        if status := doJSON(t, http.MethodPost, srv.URL+"/profile/email", token, req, nil); status != tt.want {
+            t.Errorf("change to %s with %q: status %d, want %d", tt.email, tt.password, status, tt.want)
+        }
+    }
+
+    req := map[string]string{"email": "jane2@example.com", "password": "secret"}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/profile/email", token, req, nil); status != http.StatusOK {
+        t.Fatalf("change email: status %d", status)
+    }
+    var profile Profile
+    doJSON(t, http.MethodGet, srv.URL+"/profile", token, nil, &profile)
+    if profile.Email != "jane@example.com" || profile.PendingEmail != "jane2@example.com" {
+        t.Errorf("address changed before it was confirmed: %+v", profile)
+    }
+    if status := doJSON(t, http.MethodGet, mail.lastLink(t, "jane2@example.com"), "", nil, nil); status != http.StatusOK {
+        t.Fatalf("confirm new address: status %d", status)
+    }
+    profile = Profile{}
+    doJSON(t, http.MethodGet, srv.URL+"/profile", token, nil, &profile)
+    if profile.Email != "jane2@example.com" || profile.PendingEmail != "" || !profile.EmailVerified {
+        t.Errorf("after confirming: %+v", profile)
+    }
+}
+
+func TestChangePassword(t *testing.T) {
+    srv := newTestAuthServer(t)
+    token := login(t, http.DefaultClient, srv.URL, "jane@example.com")
+    other := login(t, http.DefaultClient, srv.URL, "jane@example.com")
+
+    wrong := map[string]string{"currentPassword": "wrong", "newPassword": "new secret"}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/profile/password", token, wrong, nil); status != http.StatusUnauthorized {
+        t.Errorf("wrong current password: status %d, want 401", status)
+    }
+    empty := map[string]string{"currentPassword": "secret", "newPassword": ""}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/profile/password", token, empty, nil); status != http.StatusBadRequest {
+        t.Errorf("empty new password: status %d, want 400", status)
+    }
+
+    change := map[string]string{"currentPassword": "secret", "newPassword": "new secret"}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/profile/password", token, change, nil); status != http.StatusOK {
+        t.Fatalf("change password: status %d", status)
+    }
+    if status := doJSON(t, http.MethodGet, srv.URL+"/profile", token, nil, nil); status != http.StatusOK {
+        t.Errorf("the session that changed the password was logged out: status %d", status)
+    }
+    if status := doJSON(t, http.MethodGet, srv.URL+"/profile", other, nil, nil); status != http.StatusUnauthorized {
+        t.Errorf("other session still works: status %d", status)
+    }
+    credentials := map[string]string{"email": "jane@example.com", "password": "new secret"}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/login", "", credentials, nil); status != http.StatusOK {
+        t.Errorf("login with the new password: status %d", status)
+    }
+}
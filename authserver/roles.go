@@ -0,0 +1,92 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+
+    "github.com/sys-apps-go/microservices/authz"
+)
+
+// adminEmails are promoted to admin when they sign up, so a fresh
+// deployment has someone able to hand out roles. Set through ADMIN_EMAILS
+// as a comma separated list.
+var adminEmails = strings.Split(os.Getenv("ADMIN_EMAILS"), ",")
+
+func initialRoles(email string) []string {
+    for _, admin := range adminEmails {
+        if admin != "" && strings.TrimSpace(admin) == email {
+            return []string{authz.RoleAdmin}
+        }
+    }
+    return []string{authz.RoleCustomer}
+}
+
+func hasRole(user *User, role string) bool {
+    for _, r := range user.Roles {
+        if r == role {
+            return true
+        }
+    }
+    return false
+}
+
+// SetRolesHandler replaces the roles of a user. Only admins may call it.
+func SetRolesHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    id, err := strconv.Atoi(r.PathValue("id"))
+    if err != nil {
+        http.Error(w, "Invalid user ID", http.StatusBadRequest)
+        return
+    }
+
+    var req struct {
+        Roles []string `json:"roles"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    for _, role := range req.Roles {
+        if !authz.KnownRole(role) {
+            writeJSON(w, http.StatusBadRequest, AuthResponse{
+                Success: false,
+                Message: "Unknown role " + role,
+            })
+            return
+        }
+    }
+
+    dbMu.Lock()
+    // Checked against the stored user rather than the token, so a demoted
+    // admin loses access straight away
+    caller := findUserByID(current.UserID)
+    if caller == nil || !hasRole(caller, authz.RoleAdmin) {
+        dbMu.Unlock()
+        writeJSON(w, http.StatusForbidden, AuthResponse{
+            Success: false,
+            Message: "Admin role required",
+        })
+        return
+    }
+    user := findUserByID(id)
+    var profile Profile
+    if user != nil {
+        user.Roles = append([]string(nil), req.Roles...)
+        // Existing tokens carry the old roles, so end their sessions. The
+        // other services check sessions with authserver and refuse the
+        // tokens once their few seconds of caching run out.
+        revokeUserSessions(user.ID)
+        profile = profileOf(user)
+    }
+    dbMu.Unlock()
+
+    if user == nil {
+        http.Error(w, "User not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, http.StatusOK, profile)
+}
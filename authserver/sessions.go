@@ -0,0 +1,265 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "net"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+    "github.com/sys-apps-go/microservices/authz"
+)
+
+// sessionTTL is how long a session (and the token issued for it) stays valid.
+const sessionTTL = 24 * time.Hour
+
+// Session is a logged-in device of a user.
+type Session struct {
+    ID         string    `json:"id"`
+    UserID     int       `json:"-"`
+    UserAgent  string    `json:"userAgent"`
+    IP         string    `json:"ip"`
+    CreatedAt  time.Time `json:"createdAt"`
+    LastSeenAt time.Time `json:"lastSeenAt"`
+    ExpiresAt  time.Time `json:"expiresAt"`
+    Current    bool      `json:"current"`
+    // ClientID and Scope are set for sessions granted to an OAuth client
+    ClientID string `json:"clientId,omitempty"`
+    Scope    string `json:"scope,omitempty"`
+}
+
+type SessionsResponse struct {
+    Sessions []Session `json:"sessions"`
+}
+
+// verifier checks session tokens against keySet
+var verifier *authz.Verifier
+
+// randomToken returns a hex encoded 256-bit random string.
+func randomToken() (string, error) {
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+// clientIP returns the address of the end user, preferring the first hop
+// recorded by apiserver in X-Forwarded-For.
+func clientIP(r *http.Request) string {
+    if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+        return strings.TrimSpace(strings.Split(fwd, ",")[0])
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+// createSession records a new session for user and returns a signed token
+// referencing it.
+func createSession(user User, r *http.Request) (string, error) {
+    _, token, err := openSession(user, r, "", "")
+    return token, err
+}
+
+// openSession is createSession for a session optionally granted to an
+// OAuth client.
+func openSession(user User, r *http.Request, clientID, scope string) (*Session, string, error) {
+    return startSession(user, r.UserAgent(), clientIP(r), clientID, scope)
+}
+
+// startSession is openSession for callers other than HTTP handlers.
+func startSession(user User, userAgent, ip, clientID, scope string) (*Session, string, error) {
+    id, err := randomToken()
+    if err != nil {
+        return nil, "", err
+    }
+    now := time.Now()
+    session := &Session{
+        ID:         id,
+        UserID:     user.ID,
+        UserAgent:  userAgent,
+        IP:         ip,
+        CreatedAt:  now,
+        LastSeenAt: now,
+        ExpiresAt:  now.Add(sessionTTL),
+        ClientID:   clientID,
+        Scope:      scope,
+    }
+
+    // Roles are captured at login; changes apply from the next session.
+    // A client's token gets none: it only grants its scope, and its
+    // audience keeps the other services from taking it at all.
+    claims := authz.Claims{
+        StandardClaims: jwt.StandardClaims{
+            Id:        session.ID,
+            Subject:   strconv.Itoa(user.ID),
+            IssuedAt:  now.Unix(),
+            ExpiresAt: session.ExpiresAt.Unix(),
+        },
+        Roles: user.Roles,
+    }
+    if clientID != "" {
+        claims.Audience = clientID
+        claims.Scope = scope
+        claims.Roles = nil
+    }
+    token, err := keySet.Sign(claims)
+    if err != nil {
+        return nil, "", err
+    }
+
+    dbMu.Lock()
+    sessions[session.ID] = session
+    dbMu.Unlock()
+    return session, token, nil
+}
+
+// sessionCookie carries the session token for browser flows such as
+// /oauth2/authorize, where the Authorization header cannot be set.
+const sessionCookie = "auth_session"
+
+func setSessionCookie(w http.ResponseWriter, token string) {
+    http.SetCookie(w, &http.Cookie{
+        Name:     sessionCookie,
+        Value:    token,
+        Path:     "/",
+        MaxAge:   int(sessionTTL / time.Second),
+        HttpOnly: true,
+        Secure:   strings.HasPrefix(publicURL, "https://"),
+        SameSite: http.SameSiteLaxMode,
+    })
+}
+
+var (
+    errInvalidSession = errors.New("invalid or expired session")
+    errClientSession  = errors.New("token was issued to an OAuth client")
+)
+
+// authenticate resolves the bearer token on r to a live session and marks it
+// as seen.
+func authenticate(r *http.Request) (*Session, error) {
+    return authenticateToken(r.Header.Get("Authorization"))
+}
+
+// authenticateCookie is authenticate for the session cookie. It is only
+// accepted by endpoints that do not change state.
+func authenticateCookie(r *http.Request) (*Session, error) {
+    cookie, err := r.Cookie(sessionCookie)
+    if err != nil {
+        return nil, errInvalidSession
+    }
+    session, err := authenticateToken(cookie.Value)
+    if err == nil && session.ClientID != "" {
+        return nil, errClientSession
+    }
+    return session, err
+}
+
+func authenticateToken(raw string) (*Session, error) {
+    claims, err := verifier.Verify(raw)
+    if err != nil {
+        return nil, errInvalidSession
+    }
+    return liveSession(claims)
+}
+
+// liveSession returns the session of verified claims unless it has been
+// revoked or has expired.
+func liveSession(claims *authz.Claims) (*Session, error) {
+    dbMu.Lock()
+    defer dbMu.Unlock()
+    session, ok := sessions[claims.Id]
+    if !ok || time.Now().After(session.ExpiresAt) {
+        return nil, errInvalidSession
+    }
+    session.LastSeenAt = time.Now()
+    return session, nil
+}
+
+// requireSession rejects requests without a valid session token before
+// calling next. Access tokens of OAuth clients are refused as well; they
+// are only good for the endpoints wrapped in requireClientSession.
+func requireSession(next func(http.ResponseWriter, *http.Request, *Session)) http.HandlerFunc {
+    return sessionHandler(next, false)
+}
+
+// requireClientSession is requireSession for endpoints OAuth clients may
+// call with their access tokens.
+func requireClientSession(next func(http.ResponseWriter, *http.Request, *Session)) http.HandlerFunc {
+    return sessionHandler(next, true)
+}
+
+func sessionHandler(next func(http.ResponseWriter, *http.Request, *Session), allowClients bool) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        session, err := authenticate(r)
+        if err != nil {
+            writeJSON(w, http.StatusUnauthorized, AuthResponse{
+                Success: false,
+                Message: "Authentication required",
+            })
+            return
+        }
+        if session.ClientID != "" && !allowClients {
+            writeJSON(w, http.StatusForbidden, AuthResponse{
+                Success: false,
+                Message: "Tokens issued to OAuth clients cannot be used here",
+            })
+            return
+        }
+        next(w, r, session)
+    }
+}
+
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    now := time.Now()
+    response := SessionsResponse{Sessions: []Session{}}
+    dbMu.Lock()
+    for _, session := range sessions {
+        if session.UserID != current.UserID || now.After(session.ExpiresAt) {
+            continue
+        }
+        s := *session
+        s.Current = s.ID == current.ID
+        response.Sessions = append(response.Sessions, s)
+    }
+    dbMu.Unlock()
+    sort.Slice(response.Sessions, func(i, j int) bool {
+        return response.Sessions[i].CreatedAt.Before(response.Sessions[j].CreatedAt)
+    })
+
+    writeJSON(w, http.StatusOK, response)
+}
+
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request, current *Session) {
+    id := r.PathValue("id")
+
+    dbMu.Lock()
+    session, ok := sessions[id]
+    // Sessions of other users are reported as missing rather than forbidden
+    // so their IDs cannot be probed.
+    if ok && session.UserID == current.UserID {
+        delete(sessions, id)
+    }
+    dbMu.Unlock()
+
+    if !ok || session.UserID != current.UserID {
+        writeJSON(w, http.StatusNotFound, AuthResponse{
+            Success: false,
+            Message: "Session not found",
+        })
+        return
+    }
+
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "Session revoked",
+    })
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/jwks"
+)
+
+// newTestAuthServer starts authserver in process with a fresh database
+// holding an admin and a regular user, both with password "secret".
+func newTestAuthServer(t *testing.T) *httptest.Server {
+    t.Helper()
+    ks, err := jwks.NewKeySet(jwks.RS256, sessionTTL, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keySet = ks
+    verifier = &authz.Verifier{Keys: keySet.Keyfunc, AllowAudience: true}
+    mailer = NewLogMailer(io.Discard)
+
+    dbMu.Lock()
+    users = []User{
+        {ID: 1, FirstName: "Ada", LastName: "Admin", Email: "admin@example.com", Password: "secret", EmailVerified: true, Roles: []string{authz.RoleAdmin}},
+        {ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: "secret", EmailVerified: true, Roles: []string{authz.RoleCustomer}},
+    }
+    sessions = map[string]*Session{}
+    apiKeys = map[string]*APIKey{}
+    oauthClients = map[string]*OAuthClient{}
+    authCodes = map[string]*authCode{}
+    consentRequests = map[string]*consentRequest{}
+    dbMu.Unlock()
+
+    mux := http.NewServeMux()
+    registerHandlers(mux)
+    srv := httptest.NewServer(mux)
+    t.Cleanup(srv.Close)
+    publicURL = srv.URL
+    return srv
+}
+
+// login logs in through client, whose cookie jar keeps the session cookie,
+// and returns the bearer token.
+func login(t *testing.T, client *http.Client, base, email string) string {
+    t.Helper()
+    body := fmt.Sprintf(`{"email":%q,"password":"secret"}`, email)
+    resp, err := client.Post(base+"/login", "application/json", strings.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    var res AuthResponse
+    if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res.Token == "" {
+        t.Fatalf("login %s failed: %v %+v", email, err, res)
+    }
+    return res.Token
+}
+
+// doJSON sends body as JSON with the bearer token, if any, decodes the
+// response into out, if given, and returns the status code.
+func doJSON(t *testing.T, method, url, token string, body, out interface{}) int {
+    t.Helper()
+    var reader *bytes.Reader
+    if body != nil {
+        b, _ := json.Marshal(body)
+        reader = bytes.NewReader(b)
+    } else {
+        reader = bytes.NewReader(nil)
+    }
+    req, _ := http.NewRequest(method, url, reader)
+    req.Header.Set("Content-Type", "application/json")
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if out != nil {
+        json.NewDecoder(resp.Body).Decode(out)
+    }
+    return resp.StatusCode
+}
+
+func TestSessionListAndRevoke(t *testing.T) {
+    srv := newTestAuthServer(t)
+    laptop := login(t, http.DefaultClient, srv.URL, "jane@example.com")
+    phone := login(t, http.DefaultClient, srv.URL, "jane@example.com")
+    other := login(t, http.DefaultClient, srv.URL, "admin@example.com")
+
+    var list SessionsResponse
+    if status := doJSON(t, http.MethodGet, srv.URL+"/sessions", laptop, nil, &list); status != http.StatusOK {
+        t.Fatalf("list sessions: status %d", status)
+    }
+    if len(list.Sessions) != 2 {
+        t.Fatalf("jane has %d sessions, want 2: %+v", len(list.Sessions), list.Sessions)
+    }
+    var current, phoneSession Session
+    for _, s := range list.Sessions {
+        if s.Current {
+            current = s
+        } else {
+            phoneSession = s
+        }
+        if s.UserAgent == "" || s.IP == "" || s.CreatedAt.IsZero() || s.LastSeenAt.IsZero() {
+            t.Errorf("session is missing device details: %+v", s)
+        }
+    }
+    if current.ID == "" || phoneSession.ID == "" {
+        t.Fatalf("exactly one session should be current: %+v", list.Sessions)
+    }
+
+    // Another user's sessions look as if they did not exist.
+    var admin SessionsResponse
+    doJSON(t, http.MethodGet, srv.URL+"/sessions", other, nil, &admin)
+    if status := doJSON(t, http.MethodDelete, srv.URL+"/sessions/"+admin.Sessions[0].ID, laptop, nil, nil); status != http.StatusNotFound {
+        t.Errorf("revoking another user's session: status %d, want 404", status)
+    }
+    if status := doJSON(t, http.MethodGet, srv.URL+"/sessions", other, nil, nil); status != http.StatusOK {
+        t.Errorf("the other user's session stopped working: status %d", status)
+    }
+
+    // Revoking the phone from the laptop logs the phone out only.
+    if status := doJSON(t, http.MethodDelete, srv.URL+"/sessions/"+phoneSession.ID, laptop, nil, nil); status != http.StatusOK {
+        t.Fatalf("revoke: status %d", status)
+    }
+    if status := doJSON(t, http.MethodGet, srv.URL+"/sessions", phone, nil, nil); status != http.StatusUnauthorized {
+        t.Errorf("revoked token still accepted: status %d", status)
+    }
+    if status := doJSON(t, http.MethodGet, srv.URL+"/sessions", laptop, nil, &list); status != http.StatusOK || len(list.Sessions) != 1 {
+        t.Errorf("after revoking the phone: status %d, sessions %+v", status, list.Sessions)
+    }
+    if status := doJSON(t, http.MethodDelete, srv.URL+"/sessions/"+phoneSession.ID, laptop, nil, nil); status != http.StatusNotFound {
+        t.Errorf("revoking twice: status %d, want 404", status)
+    }
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha1"
+    "crypto/subtle"
+    "encoding/base32"
+    "encoding/binary"
+    "fmt"
+    "hash"
+    "net/url"
+    "time"
+)
+
+// TOTP implements time-based one-time passwords as specified in RFC 6238,
+// on top of the HOTP algorithm from RFC 4226.
+type TOTP struct {
+    Period time.Duration
+    Digits int
+    Hash   func() hash.Hash
+    // Skew is how many periods before and after the current one are still
+    // accepted, to tolerate clock drift.
+    Skew int64
+}
+
+// defaultTOTP matches what authenticator apps assume when the otpauth URI
+// carries no parameters.
+var defaultTOTP = TOTP{
+    Period: 30 * time.Second,
+    Digits: 6,
+    Hash:   sha1.New,
+    Skew:   1,
+}
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Counter returns the time step t falls into.
+func (o TOTP) Counter(t time.Time) int64 {
+    return t.Unix() / int64(o.Period/time.Second)
+}
+
+// CodeAt returns the code for secret at counter.
+func (o TOTP) CodeAt(secret []byte, counter int64) string {
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], uint64(counter))
+    mac := hmac.New(o.Hash, secret)
+    mac.Write(msg[:])
+    sum := mac.Sum(nil)
+
+    // Dynamic truncation, RFC 4226 section 5.3
+    offset := sum[len(sum)-1] & 0x0f
+    bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+    mod := uint32(1)
+    for i := 0; i < o.Digits; i++ {
+        mod *= 10
+    }
+    return fmt.Sprintf("%0*d", o.Digits, bin%mod)
+}
+
+// Code returns the code for secret at time t.
+func (o TOTP) Code(secret []byte, t time.Time) string {
+    return o.CodeAt(secret, o.Counter(t))
+}
+
+// Verify checks code against secret at time t. Codes from steps at or before
+// lastCounter are rejected so a code cannot be replayed. On success it
+// returns the step the code matched.
+func (o TOTP) Verify(secret []byte, code string, t time.Time, lastCounter int64) (int64, bool) {
+    now := o.Counter(t)
+    for c := now - o.Skew; c <= now+o.Skew; c++ {
+        if c <= lastCounter {
+            continue
+        }
+        if subtle.ConstantTimeCompare([]byte(o.CodeAt(secret, c)), []byte(code)) == 1 {
+            return c, true
+        }
+    }
+    return 0, false
+}
+
+// URI returns the otpauth:// URI authenticator apps scan to enroll secret.
+// Only the default parameters are supported by most apps, so none are
+// encoded beyond the secret.
+func (o TOTP) URI(issuer, account string, secret []byte) string {
+    v := url.Values{}
+    v.Set("secret", totpEncoding.EncodeToString(secret))
+    v.Set("issuer", issuer)
+    u := url.URL{
+        Scheme:   "otpauth",
+        Host:     "totp",
+        Path:     "/" + issuer + ":" + account,
+        RawQuery: v.Encode(),
+    }
+    return u.String()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/sha512"
+    "hash"
+    "strings"
+    "testing"
+    "time"
+)
+
+// Test vectors from RFC 6238 appendix B. The seeds are the ASCII string
+// "1234567890" repeated to the key length of each hash.
+func TestTOTPRFC6238Vectors(t *testing.T) {
+    seeds := map[string][]byte{
+        "SHA1":   []byte(strings.Repeat("1234567890", 2)),
+        "SHA256": []byte(strings.Repeat("1234567890", 4)[:32]),
+        "SHA512": []byte(strings.Repeat("1234567890", 7)[:64]),
+    }
+    hashes := map[string]func() hash.Hash{
+        "SHA1":   sha1.New,
+        "SHA256": sha256.New,
+        "SHA512": sha512.New,
+    }
+    tests := []struct {
+        unix int64
+        mode string
+        want string
+    }{
+        {59, "SHA1", "94287082"},
+        {59, "SHA256", "46119246"},
+        {59, "SHA512", "90693936"},
+        {1111111109, "SHA1", "07081804"},
+        {1111111109, "SHA256", "68084774"},
+        {1111111109, "SHA512", "25091201"},
+        {1111111111, "SHA1", "14050471"},
+        {1111111111, "SHA256", "67062674"},
+        {1111111111, "SHA512", "99943326"},
+        {1234567890, "SHA1", "89005924"},
+        {1234567890, "SHA256", "91819424"},
+        {1234567890, "SHA512", "93441116"},
+        {2000000000, "SHA1", "69279037"},
+        {2000000000, "SHA256", "90698825"},
+        {2000000000, "SHA512", "38618901"},
+        {20000000000, "SHA1", "65353130"},
+        {20000000000, "SHA256", "77737706"},
+        {20000000000, "SHA512", "47863826"},
+    }
+    for _, tt := range tests {
+        otp := TOTP{Period: 30 * time.Second, Digits: 8, Hash: hashes[tt.mode]}
+        got := otp.Code(seeds[tt.mode], time.Unix(tt.unix, 0))
+        if got != tt.want {
+            t.Errorf("%s at %d: got %s, want %s", tt.mode, tt.unix, got, tt.want)
+        }
+    }
+}
+
+func TestTOTPVerify(t *testing.T) {
+    secret := []byte("12345678901234567890")
+    now := time.Unix(1111111109, 0)
+    code := defaultTOTP.Code(secret, now)
+
+    counter, ok := defaultTOTP.Verify(secret, code, now.Add(25*time.Second), 0)
+    if !ok || counter != defaultTOTP.Counter(now) {
+        t.Fatalf("code from the previous period rejected")
+    }
+    if _, ok := defaultTOTP.Verify(secret, code, now, counter); ok {
+        t.Errorf("replayed code accepted")
+    }
+    if _, ok := defaultTOTP.Verify(secret, code, now.Add(time.Minute+time.Second), 0); ok {
+        t.Errorf("code outside the skew window accepted")
+    }
+    if _, ok := defaultTOTP.Verify(secret, "000000", now, 0); ok && code != "000000" {
+        t.Errorf("wrong code accepted")
+    }
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "time"
+)
+
+// verificationTTL is how long an email verification link stays usable.
+const verificationTTL = 24 * time.Hour
+
+// emailToken is a pending single-use token mailed to a user. Only its hash
+// is stored.
+type emailToken struct {
+    UserID    int
+    Email     string
+    ExpiresAt time.Time
+}
+
+// Pending verification tokens keyed by token hash, guarded by dbMu
+var verificationTokens = map[string]*emailToken{}
+
+var mailer Mailer
+
+// publicURL is the base URL users reach authserver on, used to build links
+// in outgoing mail.
+var publicURL = envOr("AUTH_PUBLIC_URL", "http://localhost:50053")
+
+func envOr(key, fallback string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return fallback
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// findUserByID returns the stored user with id. The caller must hold dbMu.
+func findUserByID(id int) *User {
+    for i := range users {
+        if users[i].ID == id {
+            return &users[i]
+        }
+    }
+    return nil
+}
+
+// findUserByEmail returns the stored user with email. The caller must hold
+// dbMu.
+func findUserByEmail(email string) *User {
+    for i := range users {
+        if users[i].Email == email {
+            return &users[i]
+        }
+    }
+    return nil
+}
+
+// sendVerification mails user a link proving they own user.Email. Earlier
+// links for the same user stop working.
+func sendVerification(ctx context.Context, user User) error {
+    token, err := randomToken()
+    if err != nil {
+        return err
+    }
+
+    dbMu.Lock()
+    for hash, t := range verificationTokens {
+        if t.UserID == user.ID {
+            delete(verificationTokens, hash)
+        }
+    }
+    verificationTokens[hashToken(token)] = &emailToken{
+        UserID:    user.ID,
+        Email:     user.Email,
+        ExpiresAt: time.Now().Add(verificationTTL),
+    }
+    dbMu.Unlock()
+
+    link := publicURL + "/verify?token=" + url.QueryEscape(token)
+    return mailer.Send(ctx, Message{
+        To:      user.Email,
+        Subject: "Verify your email address",
+        Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+            user.FirstName, link, verificationTTL),
+    })
+}
+
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+    hash := hashToken(r.URL.Query().Get("token"))
+
+    dbMu.Lock()
+    t, ok := verificationTokens[hash]
+    delete(verificationTokens, hash)
+    var user *User
+    if ok && time.Now().Before(t.ExpiresAt) {
+        user = findUserByID(t.UserID)
+    }
+    // The link either confirms the current address or a pending change; it
+    // is void if the address changed since it was sent.
+    switch {
+    case user == nil:
+    case user.Email == t.Email:
+        user.EmailVerified = true
+    case !confirmEmailChange(user, t.Email):
+        user = nil
+    }
+    dbMu.Unlock()
+
+    if user == nil {
+        writeJSON(w, http.StatusBadRequest, AuthResponse{
+            Success: false,
+            Message: "Invalid or expired verification link",
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "Email verified",
+    })
+}
+
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Email string `json:"email"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+        return
+    }
+    defer r.Body.Close()
+
+    dbMu.Lock()
+    var user *User
+    if u := findUserByEmail(req.Email); u != nil && !u.EmailVerified {
+        copied := *u
+        user = &copied
+    }
+    dbMu.Unlock()
+
+    // Mail is sent in the background, so that neither the answer nor how
+    // long it takes says whether the address is registered.
+    if user != nil {
+        go func(user User) {
+            ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+            defer cancel()
+            if err := sendVerification(ctx, user); err != nil {
+                log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+            }
+        }(*user)
+    }
+
+    writeJSON(w, http.StatusOK, AuthResponse{
+        Success: true,
+        Message: "If the address belongs to an unverified account, a new link has been sent",
+    })
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/url"
+    "regexp"
+    "sync"
+    "testing"
+    "time"
+)
+
+// recordingMailer keeps every message sent so tests can follow the links in
+// them.
+type recordingMailer struct {
+    mu   sync.Mutex
+    sent []Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg Message) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    m.sent = append(m.sent, msg)
+    return nil
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// lastLink returns the link in the latest message to to.
+func (m *recordingMailer) lastLink(t *testing.T, to string) string {
+    t.Helper()
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    for i := len(m.sent) - 1; i >= 0; i-- {
+        if m.sent[i].To == to {
+            link := linkPattern.FindString(m.sent[i].Body)
+            if link == "" {
+                t.Fatalf("no link in message to %s: %q", to, m.sent[i].Body)
+            }
+            return link
+        }
+    }
+    t.Fatalf("no message sent to %s", to)
+    return ""
+}
+
+// wait waits for n messages to have been sent to to, as some go out in the
+// background.
+func (m *recordingMailer) wait(t *testing.T, to string, n int) {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); m.count(to) < n; {
+        if time.Now().After(deadline) {
+            t.Fatalf("%d messages sent to %s, want %d", m.count(to), to, n)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func (m *recordingMailer) count(to string) int {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    n := 0
+    for _, msg := range m.sent {
+        if msg.To == to {
+            n++
+        }
+    }
+    return n
+}
+
+func TestEmailVerification(t *testing.T) {
+    srv := newTestAuthServer(t)
+    mail := &recordingMailer{}
+    mailer = mail
+
+    const email = "new@example.com"
+    signup := map[string]string{"firstName": "New", "email": email, "password": "secret"}
+    if status := doJSON(t, http.MethodPost, srv.URL+"/signup", "", signup, nil); status != http.StatusOK {
+        t.Fatalf("signup: status %d", status)
+    }
+    if mail.count(email) != 1 {
+        t.Fatalf("signup sent %d messages, want 1", mail.count(email))
+    }
+    first := mail.lastLink(t, email)
+
+    credentials := map[string]string{"email": email, "password": "secret"}
+    var res AuthResponse
+    if status := doJSON(t, http.MethodPost, srv.URL+"/login", "", credentials, &res); status != http.StatusForbidden || res.Token != "" {
+        t.Fatalf("login before verifying: status %d, %+v", status, res)
+    }
+
+    // Resending replaces the first link, and says the same for unknown
+    // addresses.
+    if status := doJSON(t, http.MethodPost, srv.URL+"/verify/resend", "", map[string]string{"email": email}, nil); status != http.StatusOK {
+        t.Fatalf("resend: status %d", status)
+    }
+    if status := doJSON(t, http.MethodPost, srv.URL+"/verify/resend", "", map[string]string{"email": "nobody@example.com"}, nil); status != http.StatusOK {
+        t.Errorf("resend to an unknown address: status %d", status)
+    }
+    mail.wait(t, email, 2)
+    if mail.count("nobody@example.com") != 0 {
+        t.Error("mail sent to an unknown address")
+    }
+    second := mail.lastLink(t, email)
+    if second == first {
+        t.Fatal("resend mailed the same link")
+    }
+    if status := doJSON(t, http.MethodGet, first, "", nil, nil); status != http.StatusBadRequest {
+        t.Errorf("replaced link: status %d, want 400", status)
+    }
+
+    if u, err := url.Parse(second); err != nil || u.Path != "/verify" {
+        t.Fatalf("unexpected link %q", second)
+    }
+    if status := doJSON(t, http.MethodGet, second, "", nil, nil); status != http.StatusOK {
+        t.Fatalf("verify: status %d", status)
+    }
+    if status := doJSON(t, http.MethodGet, second, "", nil, nil); status != http.StatusBadRequest {
+        t.Errorf("link used twice: status %d, want 400", status)
+    }
+    if status := doJSON(t, http.MethodPost, srv.URL+"/login", "", credentials, &res); status != http.StatusOK || res.Token == "" {
+        t.Fatalf("login after verifying: status %d, %+v", status, res)
+    }
+
+    // Verified accounts get no more links.
+    doJSON(t, http.MethodPost, srv.URL+"/verify/resend", "", map[string]string{"email": email}, nil)
+    time.Sleep(50 * time.Millisecond)
+    if mail.count(email) != 2 {
+        t.Errorf("%d messages sent in all, want 2", mail.count(email))
+    }
+}
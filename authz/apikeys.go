@@ -0,0 +1,177 @@
+package authz
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+)
+
+// APIKeyPrefix starts every API key, so keys can be told apart from tokens
+// and spotted by secret scanners. The next segment up to the following
+// underscore is the key ID.
+const APIKeyPrefix = "mk_"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// IsAPIKey reports whether raw looks like an API key rather than a token.
+func IsAPIKey(raw string) bool {
+    return strings.HasPrefix(raw, APIKeyPrefix)
+}
+
+// apiKeyID returns the ID part of key, or false if key is malformed.
+func apiKeyID(key string) (string, bool) {
+    id, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+    return id, IsAPIKey(key) && ok && id != "" && secret != ""
+}
+
+// APIKeyResolver turns a valid API key into the claims it grants.
+type APIKeyResolver interface {
+    Resolve(key string) (*Claims, error)
+}
+
+// APIKeyVerification is what authserver answers for a key sent to
+// /apikeys/verify.
+type APIKeyVerification struct {
+    Valid bool `json:"valid"`
+    // KeyID and UserID identify a valid key and its owner
+    KeyID  string `json:"keyId,omitempty"`
+    UserID string `json:"userId,omitempty"`
+    // Scopes are the roles the key may act with
+    Scopes    []string  `json:"scopes,omitempty"`
+    ExpiresAt time.Time `json:"expiresAt,omitempty"`
+    // UnknownID is set when no key has the key's ID, so that any other key
+    // with it can be refused without asking again.
+    UnknownID bool `json:"unknownId,omitempty"`
+}
+
+// apiKeyCacheSize bounds each of APIKeyClient's caches, so that a flood of
+// made up keys cannot grow them without limit.
+const apiKeyCacheSize = 10000
+
+// APIKeyClient resolves API keys by asking authserver. Answers are cached
+// for TTL, so a revoked key may keep working for up to that long. Key IDs
+// authserver does not know are remembered for as long, so guessed keys do
+// not each cost a request.
+type APIKeyClient struct {
+    URL    string
+    Client *http.Client
+    TTL    time.Duration
+
+    mu      sync.Mutex
+    cache   map[[sha256.Size]byte]cachedVerification
+    unknown map[string]time.Time
+    swept   time.Time
+}
+
+type cachedVerification struct {
+    claims  *Claims
+    fetched time.Time
+}
+
+// NewAPIKeyClient returns a client for the verification endpoint at url.
+func NewAPIKeyClient(url string) *APIKeyClient {
+    return &APIKeyClient{
+        URL:     url,
+        Client:  &http.Client{Timeout: 5 * time.Second},
+        TTL:     30 * time.Second,
+        cache:   map[[sha256.Size]byte]cachedVerification{},
+        unknown: map[string]time.Time{},
+    }
+}
+
+// APIKeyVerifyURL returns where authserver verifies API keys:
+// AUTH_APIKEY_VERIFY_URL, or the endpoint under AuthURL.
+func APIKeyVerifyURL() string {
+    if url := os.Getenv("AUTH_APIKEY_VERIFY_URL"); url != "" {
+        return url
+    }
+    return AuthURL() + "/apikeys/verify"
+}
+
+func (c *APIKeyClient) Resolve(key string) (*Claims, error) {
+    id, ok := apiKeyID(key)
+    if !ok {
+        return nil, ErrInvalidAPIKey
+    }
+    // Keys are cached by hash so the cache never holds usable secrets
+    hash := sha256.Sum256([]byte(key))
+    c.mu.Lock()
+    refused, unknown := c.unknown[id]
+    cached, ok := c.cache[hash]
+    c.mu.Unlock()
+    if unknown && time.Since(refused) < c.TTL {
+        return nil, ErrInvalidAPIKey
+    }
+    if ok && time.Since(cached.fetched) < c.TTL {
+        if cached.claims == nil {
+            return nil, ErrInvalidAPIKey
+        }
+        return cached.claims, nil
+    }
+
+    body, _ := json.Marshal(map[string]string{"key": key})
+    resp, err := c.Client.Post(c.URL, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    var res APIKeyVerification
+    if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+        return nil, err
+    }
+
+    var claims *Claims
+    if res.Valid && (res.ExpiresAt.IsZero() || time.Now().Before(res.ExpiresAt)) {
+        claims = &Claims{
+            StandardClaims: jwt.StandardClaims{
+                Id:        "apikey:" + res.KeyID,
+                Subject:   res.UserID,
+                ExpiresAt: res.ExpiresAt.Unix(),
+            },
+            Roles: res.Scopes,
+        }
+    }
+
+    c.mu.Lock()
+    now := time.Now()
+    c.sweep(now)
+    switch {
+    case res.UnknownID && len(c.unknown) < apiKeyCacheSize:
+        c.unknown[id] = now
+    case !res.UnknownID && len(c.cache) < apiKeyCacheSize:
+        c.cache[hash] = cachedVerification{claims: claims, fetched: now}
+    }
+    c.mu.Unlock()
+
+    if claims == nil {
+        return nil, ErrInvalidAPIKey
+    }
+    return claims, nil
+}
+
+// sweep drops expired answers. It only looks through the caches once per
+// TTL, so misses stay cheap. The caller must hold c.mu.
+func (c *APIKeyClient) sweep(now time.Time) {
+    if now.Sub(c.swept) < c.TTL {
+        return
+    }
+    c.swept = now
+    for h, v := range c.cache {
+        if now.Sub(v.fetched) >= c.TTL {
+            delete(c.cache, h)
+        }
+    }
+    for id, refused := range c.unknown {
+        if now.Sub(refused) >= c.TTL {
+            delete(c.unknown, id)
+        }
+    }
+}
@@ -0,0 +1,89 @@
+package authz
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// fakeKeyServer answers /apikeys/verify for a single valid key and counts
+// the requests it gets.
+func fakeKeyServer(t *testing.T, valid string) (*APIKeyClient, *int32) {
+    t.Helper()
+    var calls int32
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&calls, 1)
+        var req struct {
+            Key string `json:"key"`
+        }
+        json.NewDecoder(r.Body).Decode(&req)
+        id, _ := apiKeyID(req.Key)
+        validID, _ := apiKeyID(valid)
+        var res APIKeyVerification
+        switch {
+        case req.Key == valid:
+            res = APIKeyVerification{Valid: true, KeyID: id, UserID: "7", Scopes: []string{RoleCatalogEditor}, ExpiresAt: time.Now().Add(time.Hour)}
+        case id != validID:
+            res.UnknownID = true
+        }
+        json.NewEncoder(w).Encode(res)
+    }))
+    t.Cleanup(srv.Close)
+    return NewAPIKeyClient(srv.URL), &calls
+}
+
+func TestAPIKeyClientCaches(t *testing.T) {
+    const valid = "mk_abc_secret"
+    c, calls := fakeKeyServer(t, valid)
+
+    for i := 0; i < 3; i++ {
+        claims, err := c.Resolve(valid)
+        if err != nil || claims.Subject != "7" || !claims.HasRole(RoleCatalogEditor) {
+            t.Fatalf("Resolve(valid) = %+v, %v", claims, err)
+        }
+    }
+    if n := atomic.LoadInt32(calls); n != 1 {
+        t.Errorf("valid key looked up %d times, want 1", n)
+    }
+
+    // A wrong secret for a real ID is refused, and so is asking again.
+    for i := 0; i < 2; i++ {
+        if _, err := c.Resolve("mk_abc_guess"); err != ErrInvalidAPIKey {
+            t.Errorf("wrong secret: %v", err)
+        }
+    }
+    if n := atomic.LoadInt32(calls); n != 2 {
+        t.Errorf("%d lookups after a wrong secret, want 2", n)
+    }
+
+    // Any key with an unknown ID is refused after the first answer.
+    for _, key := range []string{"mk_nope_1", "mk_nope_2", "mk_nope_3"} {
+        if _, err := c.Resolve(key); err != ErrInvalidAPIKey {
+            t.Errorf("Resolve(%s) = %v", key, err)
+        }
+    }
+    if n := atomic.LoadInt32(calls); n != 3 {
+        t.Errorf("%d lookups after unknown IDs, want 3", n)
+    }
+
+    // Malformed keys are refused without asking.
+    for _, key := range []string{"mk_", "mk_abc", "mk__secret", "mk_abc_"} {
+        if _, err := c.Resolve(key); err != ErrInvalidAPIKey {
+            t.Errorf("Resolve(%q) = %v", key, err)
+        }
+    }
+    if n := atomic.LoadInt32(calls); n != 3 {
+        t.Errorf("malformed keys were looked up: %d lookups", n)
+    }
+
+    // Answers expire after TTL.
+    c.TTL = 0
+    c.Resolve("mk_nope_4")
+    c.Resolve(valid)
+    if n := atomic.LoadInt32(calls); n != 5 {
+        t.Errorf("%d lookups with a zero TTL, want 5", n)
+    }
+}
@@ -0,0 +1,131 @@
+// Package authz holds the pieces shared by the services to authenticate
+// tokens issued by authserver and authorize requests by role.
+package authz
+
+import (
+    "context"
+    "errors"
+    "os"
+    "strings"
+
+    "github.com/dgrijalva/jwt-go"
+)
+
+// Roles a user can hold.
+const (
+    RoleCustomer      = "customer"
+    RoleCatalogEditor = "catalog-editor"
+    RoleAdmin         = "admin"
+)
+
+// KnownRole reports whether role is one of the roles above.
+func KnownRole(role string) bool {
+    switch role {
+    case RoleCustomer, RoleCatalogEditor, RoleAdmin:
+        return true
+    }
+    return false
+}
+
+// Claims is the payload of tokens issued by authserver. The standard Id
+// claim is the session ID and Subject the user ID. Access tokens issued to
+// OAuth clients carry the client ID as Audience and the granted Scope
+// instead of Roles.
+type Claims struct {
+    jwt.StandardClaims
+    Roles []string `json:"roles,omitempty"`
+    Scope string   `json:"scope,omitempty"`
+}
+
+// HasRole reports whether the token grants role.
+func (c *Claims) HasRole(role string) bool {
+    for _, r := range c.Roles {
+        if r == role {
+            return true
+        }
+    }
+    return false
+}
+
+// AuthURL returns the base URL of authserver: AUTH_URL, or the local
+// development address.
+func AuthURL() string {
+    if url := os.Getenv("AUTH_URL"); url != "" {
+        return strings.TrimSuffix(url, "/")
+    }
+    return "http://localhost:50053"
+}
+
+// JWKSURL returns where authserver publishes its token signing keys:
+// AUTH_JWKS_URL, or the key set under AuthURL.
+func JWKSURL() string {
+    if url := os.Getenv("AUTH_JWKS_URL"); url != "" {
+        return url
+    }
+    return AuthURL() + "/jwks.json"
+}
+
+var (
+    ErrNoToken = errors.New("no token")
+    // ErrAudience means a token was issued for a particular audience, such
+    // as an ID token or an OAuth client's access token, not as a session.
+    ErrAudience = errors.New("token is meant for another audience")
+)
+
+// Verifier checks the signature and expiry of tokens.
+type Verifier struct {
+    // Keys resolves the public key a token was signed with, typically
+    // (*jwks.Verifier).Keyfunc. It must reject keys that do not match the
+    // token's alg.
+    Keys jwt.Keyfunc
+    // APIKeys validates API keys presented in place of a token. API keys
+    // are rejected when it is nil.
+    APIKeys APIKeyResolver
+    // Sessions confirms that a token's session is still live, so that
+    // logging out or revoking a session takes effect. Only the signature
+    // and expiry of tokens are checked when it is nil.
+    Sessions SessionChecker
+    // AllowAudience accepts tokens with an audience. Only authserver sets
+    // it, to serve OAuth clients at /oauth2/userinfo; other services must
+    // not take ID tokens or client access tokens as bearer tokens.
+    AllowAudience bool
+}
+
+// Verify parses a token or API key, with or without its "Bearer " prefix.
+func (v *Verifier) Verify(raw string) (*Claims, error) {
+    raw = strings.TrimSpace(strings.TrimPrefix(raw, "Bearer "))
+    if raw == "" {
+        return nil, ErrNoToken
+    }
+    if IsAPIKey(raw) {
+        if v.APIKeys == nil {
+            return nil, ErrInvalidAPIKey
+        }
+        return v.APIKeys.Resolve(raw)
+    }
+    var claims Claims
+    _, err := jwt.ParseWithClaims(raw, &claims, v.Keys)
+    if err != nil {
+        return nil, err
+    }
+    if claims.Audience != "" && !v.AllowAudience {
+        return nil, ErrAudience
+    }
+    if v.Sessions != nil {
+        return v.Sessions.Check(raw, &claims)
+    }
+    return &claims, nil
+}
+
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying the caller's claims.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+    return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims of the authenticated caller, if any.
+func FromContext(ctx context.Context) (*Claims, bool) {
+    claims, ok := ctx.Value(claimsKey{}).(*Claims)
+    return claims, ok
+}
@@ -0,0 +1,73 @@
+package authz
+
+import (
+    "context"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+// authorize checks the token in the incoming metadata of ctx against the
+// rule for method and returns ctx with the caller's claims attached.
+func authorize(ctx context.Context, policy Policy, verifier *Verifier, method string) (context.Context, error) {
+    rule, ok := policy.Lookup(method)
+    if !ok {
+        return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed by policy", method)
+    }
+
+    var claims *Claims
+    if md, ok := metadata.FromIncomingContext(ctx); ok {
+        if values := md.Get("authorization"); len(values) > 0 {
+            var err error
+            claims, err = verifier.Verify(values[0])
+            if err != nil {
+                return nil, status.Error(codes.Unauthenticated, "invalid token")
+            }
+            ctx = NewContext(ctx, claims)
+        }
+    }
+
+    if !rule.Allows(claims) {
+        if claims == nil {
+            return nil, status.Error(codes.Unauthenticated, "authentication required")
+        }
+        return nil, status.Errorf(codes.PermissionDenied, "roles %v may not call %s", claims.Roles, method)
+    }
+    return ctx, nil
+}
+
+// UnaryServerInterceptor enforces policy on every unary RPC.
+func UnaryServerInterceptor(policy Policy, verifier *Verifier) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        ctx, err := authorize(ctx, policy, verifier, info.FullMethod)
+        if err != nil {
+            return nil, err
+        }
+        return handler(ctx, req)
+    }
+}
+
+// authorizedStream carries the context returned by authorize into a
+// stream handler.
+type authorizedStream struct {
+    grpc.ServerStream
+    ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+    return s.ctx
+}
+
+// StreamServerInterceptor enforces policy on every streaming RPC when the
+// stream is opened.
+func StreamServerInterceptor(policy Policy, verifier *Verifier) grpc.StreamServerInterceptor {
+    return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+        ctx, err := authorize(ss.Context(), policy, verifier, info.FullMethod)
+        if err != nil {
+            return err
+        }
+        return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+    }
+}
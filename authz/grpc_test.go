@@ -0,0 +1,67 @@
+package authz
+
+import (
+    "context"
+    "testing"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+)
+
+// testStream is a server stream with only a context.
+type testStream struct {
+    grpc.ServerStream
+    ctx context.Context
+}
+
+func (s *testStream) Context() context.Context {
+    return s.ctx
+}
+
+func TestServerInterceptors(t *testing.T) {
+    unary := UnaryServerInterceptor(testPolicy, testVerifier())
+    stream := StreamServerInterceptor(testPolicy, testVerifier())
+
+    tests := []struct {
+        name, method, auth string
+        want               codes.Code
+    }{
+        {"public without a token", "/catalog.Catalog/Public", "", codes.OK},
+        {"account without a token", "/catalog.Catalog/Account", "", codes.Unauthenticated},
+        {"account with a bad token", "/catalog.Catalog/Account", "Bearer nonsense", codes.Unauthenticated},
+        {"account with any role", "/catalog.Catalog/Account", signToken(t, RoleCustomer), codes.OK},
+        {"edit without a token", "/catalog.Catalog/Edit", "", codes.Unauthenticated},
+        {"edit as customer", "/catalog.Catalog/Edit", signToken(t, RoleCustomer), codes.PermissionDenied},
+        {"edit as editor", "/catalog.Catalog/Edit", signToken(t, RoleCatalogEditor), codes.OK},
+        {"method missing from the policy", "/catalog.Catalog/Other", signToken(t, RoleAdmin), codes.PermissionDenied},
+    }
+    for _, tt := range tests {
+        ctx := context.Background()
+        if tt.auth != "" {
+            ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.auth))
+        }
+        authenticated := func(ctx context.Context) {
+            if _, ok := FromContext(ctx); ok != (tt.auth != "") {
+                t.Errorf("%s: handler got claims %v", tt.name, ok)
+            }
+        }
+
+        _, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+            authenticated(ctx)
+            return nil, nil
+        })
+        if status.Code(err) != tt.want {
+            t.Errorf("unary %s: %v, want %v", tt.name, err, tt.want)
+        }
+
+        err = stream(nil, &testStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: tt.method}, func(srv interface{}, ss grpc.ServerStream) error {
+            authenticated(ss.Context())
+            return nil
+        })
+        if status.Code(err) != tt.want {
+            t.Errorf("stream %s: %v, want %v", tt.name, err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package authz
+
+import (
+    "net/http"
+)
+
+// Middleware enforces policy on requests routed by mux. Rules are keyed by
+// the pattern the request matches, exactly as it was registered.
+func Middleware(policy Policy, verifier *Verifier, mux *http.ServeMux) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, pattern := mux.Handler(r)
+        rule, ok := policy.Lookup(pattern)
+        if !ok {
+            http.Error(w, "Forbidden", http.StatusForbidden)
+            return
+        }
+
+        var claims *Claims
+        if auth := r.Header.Get("Authorization"); auth != "" {
+            var err error
+            claims, err = verifier.Verify(auth)
+            // Requests for public routes are passed on even with a bad
+            // token; the service behind may have its own notion of it.
+            if err != nil && !rule.Public {
+                http.Error(w, "Invalid token", http.StatusUnauthorized)
+                return
+            }
+            if err == nil {
+                r = r.WithContext(NewContext(r.Context(), claims))
+            }
+        }
+
+        if !rule.Allows(claims) {
+            if claims == nil {
+                http.Error(w, "Authentication required", http.StatusUnauthorized)
+                return
+            }
+            http.Error(w, "Forbidden", http.StatusForbidden)
+            return
+        }
+        mux.ServeHTTP(w, r)
+    })
+}
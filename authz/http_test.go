@@ -0,0 +1,104 @@
+package authz
+
+import (
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+)
+
+var testKey = []byte("test signing key")
+
+// testVerifier accepts tokens signed by signToken.
+func testVerifier() *Verifier {
+    return &Verifier{Keys: func(token *jwt.Token) (interface{}, error) {
+        if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+            return nil, fmt.Errorf("unexpected alg %v", token.Header["alg"])
+        }
+        return testKey, nil
+    }}
+}
+
+func signToken(t *testing.T, roles ...string) string {
+    t.Helper()
+    claims := Claims{
+        StandardClaims: jwt.StandardClaims{Id: "s1", Subject: "7", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+        Roles:          roles,
+    }
+    token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return "Bearer " + token
+}
+
+var testPolicy = Policy{
+    "GET /public":              {Public: true},
+    "GET /account":             {Roles: []string{}},
+    "GET /edit":                {Roles: []string{RoleCatalogEditor, RoleAdmin}},
+    "/catalog.Catalog/Public":  {Public: true},
+    "/catalog.Catalog/Account": {Roles: []string{}},
+    "/catalog.Catalog/Edit":    {Roles: []string{RoleCatalogEditor, RoleAdmin}},
+}
+
+func TestMiddleware(t *testing.T) {
+    mux := http.NewServeMux()
+    for _, pattern := range []string{"GET /public", "GET /account", "GET /edit", "GET /unlisted"} {
+        mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+            if claims, ok := FromContext(r.Context()); ok {
+                fmt.Fprint(w, claims.Subject)
+            }
+        })
+    }
+    handler := Middleware(testPolicy, testVerifier(), mux)
+
+    tests := []struct {
+        name, path, auth string
+        want             int
+        subject          string
+    }{
+        {"public without a token", "/public", "", http.StatusOK, ""},
+        {"public with a bad token", "/public", "Bearer nonsense", http.StatusOK, ""},
+        {"public with a token", "/public", signToken(t), http.StatusOK, "7"},
+        {"account without a token", "/account", "", http.StatusUnauthorized, ""},
+        {"account with a bad token", "/account", "Bearer nonsense", http.StatusUnauthorized, ""},
+        {"account with any role", "/account", signToken(t, RoleCustomer), http.StatusOK, "7"},
+        {"edit without a token", "/edit", "", http.StatusUnauthorized, ""},
+        {"edit as customer", "/edit", signToken(t, RoleCustomer), http.StatusForbidden, ""},
+        {"edit as editor", "/edit", signToken(t, RoleCatalogEditor), http.StatusOK, "7"},
+        {"edit as admin", "/edit", signToken(t, RoleCustomer, RoleAdmin), http.StatusOK, "7"},
+        {"route missing from the policy", "/unlisted", signToken(t, RoleAdmin), http.StatusForbidden, ""},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest("GET", tt.path, nil)
+        if tt.auth != "" {
+            r.Header.Set("Authorization", tt.auth)
+        }
+        w := httptest.NewRecorder()
+        handler.ServeHTTP(w, r)
+        if w.Code != tt.want {
+            t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+        }
+        if tt.want == http.StatusOK && w.Body.String() != tt.subject {
+            t.Errorf("%s: handler saw subject %q, want %q", tt.name, w.Body, tt.subject)
+        }
+    }
+}
+
+func TestVerifierChecksSessions(t *testing.T) {
+    v := testVerifier()
+    token := signToken(t, RoleAdmin)
+    v.Sessions = NewSessionClient(&fakeAuthService{})
+    if _, err := v.Verify(token); err != ErrSessionEnded {
+        t.Errorf("token of an ended session: %v, want ErrSessionEnded", err)
+    }
+
+    claims := Claims{StandardClaims: jwt.StandardClaims{Audience: "client", ExpiresAt: time.Now().Add(time.Hour).Unix()}}
+    raw, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testKey)
+    if _, err := testVerifier().Verify(raw); err != ErrAudience {
+        t.Errorf("token with an audience: %v, want ErrAudience", err)
+    }
+}
@@ -0,0 +1,80 @@
+package authz
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+)
+
+// Rule says who may call a method.
+type Rule struct {
+    // Public methods need no token at all.
+    Public bool `json:"public"`
+    // Roles lists the roles allowed to call the method. An empty list
+    // admits any authenticated caller.
+    Roles []string `json:"roles"`
+}
+
+// Policy maps gRPC full method names (/pkg.Service/Method) and HTTP route
+// patterns to their rules. Anything not listed is denied.
+type Policy map[string]Rule
+
+// PolicyPath returns where services read their policy table from:
+// AUTHZ_POLICY, or config/policy.json relative to the working directory.
+func PolicyPath() string {
+    if path := os.Getenv("AUTHZ_POLICY"); path != "" {
+        return path
+    }
+    return "config/policy.json"
+}
+
+// LoadPolicy reads the table for service from the JSON file at path, which
+// holds one policy per service name.
+func LoadPolicy(path, service string) (Policy, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var policies map[string]Policy
+    if err := json.Unmarshal(data, &policies); err != nil {
+        return nil, fmt.Errorf("parse %s: %w", path, err)
+    }
+    policy, ok := policies[service]
+    if !ok {
+        return nil, fmt.Errorf("%s has no policy for %s", path, service)
+    }
+    for method, rule := range policy {
+        for _, role := range rule.Roles {
+            if !KnownRole(role) {
+                return nil, fmt.Errorf("%s: unknown role %q for %s", path, role, method)
+            }
+        }
+    }
+    return policy, nil
+}
+
+// Lookup returns the rule for method.
+func (p Policy) Lookup(method string) (Rule, bool) {
+    rule, ok := p[method]
+    return rule, ok
+}
+
+// Allows reports whether a caller with claims may use a method governed by
+// rule. claims is nil for unauthenticated callers.
+func (r Rule) Allows(claims *Claims) bool {
+    if r.Public {
+        return true
+    }
+    if claims == nil {
+        return false
+    }
+    if len(r.Roles) == 0 {
+        return true
+    }
+    for _, role := range r.Roles {
+        if claims.HasRole(role) {
+            return true
+        }
+    }
+    return false
+}
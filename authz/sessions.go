@@ -0,0 +1,116 @@
+package authz
+
+import (
+    "context"
+    "crypto/sha256"
+    "errors"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/sys-apps-go/microservices/auth"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// ErrSessionEnded means a token's signature is fine but its session was
+// logged out, revoked or ended by a password change.
+var ErrSessionEnded = errors.New("session has ended")
+
+// SessionChecker confirms that the session of a token, whose signature has
+// already been checked, is still live.
+type SessionChecker interface {
+    Check(raw string, claims *Claims) (*Claims, error)
+}
+
+// AuthGRPCAddr returns where authserver serves AuthService: AUTH_GRPC_ADDR,
+// or the local development address.
+func AuthGRPCAddr() string {
+    if addr := os.Getenv("AUTH_GRPC_ADDR"); addr != "" {
+        return addr
+    }
+    return "localhost:50054"
+}
+
+// SessionClient checks sessions with authserver's VerifyToken. Answers are
+// cached for TTL, so a revoked session may keep working for up to that
+// long.
+type SessionClient struct {
+    Client  auth.AuthServiceClient
+    TTL     time.Duration
+    Timeout time.Duration
+
+    mu    sync.Mutex
+    cache map[[sha256.Size]byte]cachedVerification
+    swept time.Time
+}
+
+// NewSessionClient returns a checker asking authserver through client.
+func NewSessionClient(client auth.AuthServiceClient) *SessionClient {
+    return &SessionClient{
+        Client:  client,
+        TTL:     5 * time.Second,
+        Timeout: 5 * time.Second,
+        cache:   map[[sha256.Size]byte]cachedVerification{},
+    }
+}
+
+// Check returns claims with the roles authserver holds for the session.
+// Failures to reach authserver are reported rather than cached, so a token
+// is never taken on trust.
+func (c *SessionClient) Check(raw string, claims *Claims) (*Claims, error) {
+    hash := sha256.Sum256([]byte(raw))
+    c.mu.Lock()
+    cached, ok := c.cache[hash]
+    c.mu.Unlock()
+    if ok && time.Since(cached.fetched) < c.TTL {
+        if cached.claims == nil {
+            return nil, ErrSessionEnded
+        }
+        return cached.claims, nil
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+    defer cancel()
+    res, err := c.Client.VerifyToken(ctx, &auth.VerifyTokenRequest{Token: raw})
+    if err != nil && status.Code(err) != codes.Unauthenticated {
+        return nil, err
+    }
+
+    var live *Claims
+    if err == nil && res.SessionId == claims.Id && res.UserId == claims.Subject {
+        copied := *claims
+        copied.Roles = res.Roles
+        live = &copied
+    }
+
+    c.mu.Lock()
+    now := time.Now()
+    c.sweep(now)
+    if len(c.cache) < sessionCacheSize {
+        c.cache[hash] = cachedVerification{claims: live, fetched: now}
+    }
+    c.mu.Unlock()
+
+    if live == nil {
+        return nil, ErrSessionEnded
+    }
+    return live, nil
+}
+
+// sessionCacheSize bounds SessionClient's cache.
+const sessionCacheSize = 10000
+
+// sweep drops expired answers, looking through the cache once per TTL. The
+// caller must hold c.mu.
+func (c *SessionClient) sweep(now time.Time) {
+    if now.Sub(c.swept) < c.TTL {
+        return
+    }
+    c.swept = now
+    for h, v := range c.cache {
+        if now.Sub(v.fetched) >= c.TTL {
+            delete(c.cache, h)
+        }
+    }
+}
@@ -0,0 +1,76 @@
+package authz
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+    "github.com/sys-apps-go/microservices/auth"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// fakeAuthService answers VerifyToken from a set of live sessions.
+type fakeAuthService struct {
+    auth.AuthServiceClient
+    live  map[string]*auth.VerifyTokenResponse
+    err   error
+    calls int
+}
+
+func (f *fakeAuthService) VerifyToken(ctx context.Context, req *auth.VerifyTokenRequest, opts ...grpc.CallOption) (*auth.VerifyTokenResponse, error) {
+    f.calls++
+    if f.err != nil {
+        return nil, f.err
+    }
+    if res, ok := f.live[req.Token]; ok {
+        return res, nil
+    }
+    return nil, status.Error(codes.Unauthenticated, "invalid or expired session")
+}
+
+func TestSessionClient(t *testing.T) {
+    fake := &fakeAuthService{live: map[string]*auth.VerifyTokenResponse{
+        "token": {UserId: "7", SessionId: "s1", Roles: []string{RoleCatalogEditor}},
+    }}
+    c := NewSessionClient(fake)
+    claims := &Claims{StandardClaims: jwt.StandardClaims{Id: "s1", Subject: "7"}, Roles: []string{RoleAdmin}}
+
+    got, err := c.Check("token", claims)
+    if err != nil || !got.HasRole(RoleCatalogEditor) || got.HasRole(RoleAdmin) {
+        t.Fatalf("Check = %+v, %v; want the session's roles", got, err)
+    }
+    c.Check("token", claims)
+    if fake.calls != 1 {
+        t.Errorf("%d lookups, want 1", fake.calls)
+    }
+
+    // A token must belong to the session authserver looked up.
+    other := &Claims{StandardClaims: jwt.StandardClaims{Id: "s2", Subject: "7"}}
+    if _, err := NewSessionClient(fake).Check("token", other); !errors.Is(err, ErrSessionEnded) {
+        t.Errorf("mismatched session: %v", err)
+    }
+
+    // Revocation shows once the cached answer expires.
+    delete(fake.live, "token")
+    c.TTL = 0
+    if _, err := c.Check("token", claims); !errors.Is(err, ErrSessionEnded) {
+        t.Errorf("revoked session: %v, want ErrSessionEnded", err)
+    }
+
+    // Outages are reported, not cached.
+    c.TTL = time.Minute
+    fake.err = status.Error(codes.Unavailable, "down")
+    fake.calls = 0
+    for i := 0; i < 2; i++ {
+        if _, err := c.Check("other", claims); status.Code(err) != codes.Unavailable {
+            t.Errorf("authserver down: %v", err)
+        }
+    }
+    if fake.calls != 2 {
+        t.Errorf("failed lookups were cached: %d calls", fake.calls)
+    }
+}
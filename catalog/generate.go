@@ -0,0 +1,5 @@
+package catalog
+
+// Regenerate with go generate ./catalog. protogen refuses changes that
+// would break existing clients, see tools/protogen.
+//go:generate go run ../tools/protogen -root .. -I . -I third_party/googleapis -snapshot catalog/catalog.binpb -plugin go=paths=source_relative -plugin go-grpc=paths=source_relative -plugin grpc-gateway=paths=source_relative -plugin openapiv2 catalog/catalog.proto
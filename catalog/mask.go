@@ -0,0 +1,41 @@
+package catalog
+
+import (
+    "fmt"
+
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/reflect/protoreflect"
+    "google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// ApplyReadMask returns a copy of p with only the top-level fields named
+// in mask set, or p itself if mask is empty. Fields may be named as in the
+// proto or in JSON.
+func ApplyReadMask(p *Product, mask *fieldmaskpb.FieldMask) (*Product, error) {
+    if len(mask.GetPaths()) == 0 {
+        return p, nil
+    }
+    fields := p.ProtoReflect().Descriptor().Fields()
+    keep := map[protoreflect.FieldNumber]bool{}
+    for _, path := range mask.Paths {
+        field := fields.ByName(protoreflect.Name(path))
+        if field == nil {
+            // REST clients may use the JSON name.
+            field = fields.ByJSONName(path)
+        }
+        if field == nil {
+            return nil, fmt.Errorf("unknown product field %q in read mask", path)
+        }
+        keep[field.Number()] = true
+    }
+
+    masked := proto.Clone(p).(*Product)
+    m := masked.ProtoReflect()
+    m.Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+        if !keep[field.Number()] {
+            m.Clear(field)
+        }
+        return true
+    })
+    return masked, nil
+}
@@ -0,0 +1,183 @@
+package catalog
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// DefaultCurrency is the currency prices are in when a client gives only
+// the deprecated float price.
+const DefaultCurrency = "USD"
+
+const nanosPerUnit = 1_000_000_000
+
+var (
+    ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+    ErrInvalidMoney     = errors.New("invalid amount of money")
+
+    currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+    amountPattern   = regexp.MustCompile(`^(-?)(\d+)(?:\.(\d{1,9}))?$`)
+)
+
+// minorDigits lists the currencies whose minor unit is not a hundredth.
+var minorDigits = map[string]int{
+    "BHD": 3, "JOD": 3, "KWD": 3, "OMR": 3, "TND": 3,
+    "CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "VND": 0,
+}
+
+// MinorDigits returns the number of decimal places of currency's minor
+// unit, such as 2 for cents.
+func MinorDigits(currency string) int {
+    if d, ok := minorDigits[currency]; ok {
+        return d
+    }
+    return 2
+}
+
+func pow10(n int) int64 {
+    p := int64(1)
+    for ; n > 0; n-- {
+        p *= 10
+    }
+    return p
+}
+
+// NewMoney returns minor units of currency, such as cents for USD.
+func NewMoney(currency string, minor int64) *Money {
+    per := pow10(MinorDigits(currency))
+    return &Money{
+        CurrencyCode: currency,
+        Units:        minor / per,
+        Nanos:        int32(minor % per * (nanosPerUnit / per)),
+    }
+}
+
+// MoneyFromFloat converts an approximate amount, such as the deprecated
+// float prices, rounding it to the nearest minor unit of currency.
+func MoneyFromFloat(currency string, amount float64) *Money {
+    per := float64(pow10(MinorDigits(currency)))
+    return NewMoney(currency, int64(math.Round(amount*per)))
+}
+
+// ParseMoney parses a decimal amount such as "9.99" in currency.
+func ParseMoney(currency, amount string) (*Money, error) {
+    m := amountPattern.FindStringSubmatch(strings.TrimSpace(amount))
+    if m == nil || !currencyPattern.MatchString(currency) {
+        return nil, fmt.Errorf("%w: %q %s", ErrInvalidMoney, amount, currency)
+    }
+    units, err := strconv.ParseInt(m[2], 10, 64)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %q %s", ErrInvalidMoney, amount, currency)
+    }
+    var nanos int64
+    if m[3] != "" {
+        nanos, _ = strconv.ParseInt(m[3]+strings.Repeat("0", 9-len(m[3])), 10, 32)
+    }
+    if m[1] == "-" {
+        units, nanos = -units, -nanos
+    }
+    return &Money{CurrencyCode: currency, Units: units, Nanos: int32(nanos)}, nil
+}
+
+// Validate checks that m has a currency code and that units and nanos are
+// in range and agree in sign.
+func (m *Money) Validate() error {
+    switch {
+    case m == nil || !currencyPattern.MatchString(m.CurrencyCode):
+        return fmt.Errorf("%w: currency must be a three letter ISO 4217 code", ErrInvalidMoney)
+    case m.Nanos <= -nanosPerUnit || m.Nanos >= nanosPerUnit:
+        return fmt.Errorf("%w: nanos out of range", ErrInvalidMoney)
+    case m.Units > 0 && m.Nanos < 0, m.Units < 0 && m.Nanos > 0:
+        return fmt.Errorf("%w: units and nanos have different signs", ErrInvalidMoney)
+    }
+    return nil
+}
+
+// Negative reports whether m is less than zero.
+func (m *Money) Negative() bool {
+    return m.GetUnits() < 0 || m.GetNanos() < 0
+}
+
+// MinorUnits returns m as a whole number of minor units, failing if it has
+// a fraction of one.
+func (m *Money) MinorUnits() (int64, error) {
+    per := pow10(MinorDigits(m.CurrencyCode))
+    step := int64(nanosPerUnit) / per
+    if int64(m.Nanos)%step != 0 {
+        return 0, fmt.Errorf("%w: %s has a fraction of a minor unit", ErrInvalidMoney, m.Format())
+    }
+    return m.Units*per + int64(m.Nanos)/step, nil
+}
+
+// Float returns m as an approximate number, for the deprecated float
+// price fields.
+func (m *Money) Float() float64 {
+    return float64(m.GetUnits()) + float64(m.GetNanos())/nanosPerUnit
+}
+
+func (m *Money) totalNanos() int64 {
+    return m.Units*nanosPerUnit + int64(m.Nanos)
+}
+
+func fromNanos(currency string, total int64) *Money {
+    return &Money{CurrencyCode: currency, Units: total / nanosPerUnit, Nanos: int32(total % nanosPerUnit)}
+}
+
+// Add returns m + o.
+func (m *Money) Add(o *Money) (*Money, error) {
+    if m.CurrencyCode != o.CurrencyCode {
+        return nil, ErrCurrencyMismatch
+    }
+    return fromNanos(m.CurrencyCode, m.totalNanos()+o.totalNanos()), nil
+}
+
+// Sub returns m - o.
+func (m *Money) Sub(o *Money) (*Money, error) {
+    if m.CurrencyCode != o.CurrencyCode {
+        return nil, ErrCurrencyMismatch
+    }
+    return fromNanos(m.CurrencyCode, m.totalNanos()-o.totalNanos()), nil
+}
+
+// Mul returns m times a quantity, such as a line total.
+func (m *Money) Mul(quantity int64) *Money {
+    return fromNanos(m.CurrencyCode, m.totalNanos()*quantity)
+}
+
+// Cmp compares m and o, returning -1, 0 or 1.
+func (m *Money) Cmp(o *Money) (int, error) {
+    if m.CurrencyCode != o.CurrencyCode {
+        return 0, ErrCurrencyMismatch
+    }
+    a, b := m.totalNanos(), o.totalNanos()
+    switch {
+    case a < b:
+        return -1, nil
+    case a > b:
+        return 1, nil
+    }
+    return 0, nil
+}
+
+// Format returns m as a decimal with at least the currency's minor digits,
+// followed by the currency code, such as "9.99 USD".
+func (m *Money) Format() string {
+    sign := ""
+    units, nanos := m.GetUnits(), int64(m.GetNanos())
+    if units < 0 || nanos < 0 {
+        sign, units, nanos = "-", -units, -nanos
+    }
+    digits := MinorDigits(m.GetCurrencyCode())
+    fraction := strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+    for len(fraction) < digits {
+        fraction += "0"
+    }
+    if fraction == "" {
+        return fmt.Sprintf("%s%d %s", sign, units, m.GetCurrencyCode())
+    }
+    return fmt.Sprintf("%s%d.%s %s", sign, units, fraction, m.GetCurrencyCode())
+}
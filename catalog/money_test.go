@@ -0,0 +1,58 @@
+package catalog
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestMoney(t *testing.T) {
+    price := NewMoney("USD", 1999)
+    if price.Units != 19 || price.Nanos != 990_000_000 {
+        t.Fatalf("NewMoney(USD, 1999) = %v", price)
+    }
+    if got := price.Mul(3).Format(); got != "59.97 USD" {
+        t.Errorf("3 × 19.99 = %s", got)
+    }
+    discount, _ := ParseMoney("USD", "20.5")
+    if got, _ := price.Sub(discount); got.Format() != "-0.51 USD" {
+        t.Errorf("19.99 - 20.50 = %s", got.Format())
+    }
+    if _, err := price.Add(NewMoney("EUR", 100)); !errors.Is(err, ErrCurrencyMismatch) {
+        t.Errorf("adding EUR to USD: %v", err)
+    }
+
+    for _, tc := range []struct {
+        money *Money
+        minor int64
+        text  string
+    }{
+        {NewMoney("JPY", 1500), 1500, "1500 JPY"},
+        {NewMoney("KWD", 1250), 1250, "1.250 KWD"},
+        {MoneyFromFloat("USD", 0.1+0.2), 30, "0.30 USD"},
+        {NewMoney("USD", -5), -5, "-0.05 USD"},
+    } {
+        if minor, err := tc.money.MinorUnits(); err != nil || minor != tc.minor {
+            t.Errorf("%v in minor units = %d, %v; want %d", tc.money, minor, err, tc.minor)
+        }
+        if text := tc.money.Format(); text != tc.text {
+            t.Errorf("%v formatted = %q, want %q", tc.money, text, tc.text)
+        }
+    }
+
+    for _, invalid := range []*Money{
+        nil,
+        {CurrencyCode: "usd", Units: 1},
+        {CurrencyCode: "USD", Units: 1, Nanos: -1},
+        {CurrencyCode: "USD", Nanos: 1_000_000_000},
+    } {
+        if invalid.Validate() == nil {
+            t.Errorf("%v is valid", invalid)
+        }
+    }
+    if _, err := (&Money{CurrencyCode: "USD", Nanos: 1_000}).MinorUnits(); err == nil {
+        t.Error("a fraction of a cent converted to minor units")
+    }
+    if _, err := ParseMoney("USD", "1.2.3"); err == nil {
+        t.Error("parsed 1.2.3")
+    }
+}
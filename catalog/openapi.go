@@ -0,0 +1,9 @@
+package catalog
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI (Swagger 2.0) document for the REST routes of
+// CatalogService, generated from catalog.proto by protoc-gen-openapiv2.
+//
+//go:embed catalog.swagger.json
+var OpenAPI []byte
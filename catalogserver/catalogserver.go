@@ -2,15 +2,23 @@ package main
 
 import (
     "context"
+    "errors"
     "log"
     "net"
     "net/http"
     "time"
 
-    "github.com/goperfapps/microservices/catalog"
     "github.com/prometheus/client_golang/prometheus"
     "github.com/prometheus/client_golang/prometheus/promhttp"
+    "github.com/sys-apps-go/microservices/auth"
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/catalog"
+    "github.com/sys-apps-go/microservices/certs"
+    "github.com/sys-apps-go/microservices/jwks"
     "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/emptypb"
 )
 
 var (
@@ -27,19 +35,30 @@ func init() {
 
 type server struct {
     catalog.UnimplementedCatalogServiceServer
+    store *productStore
+    rates *exchangeRates
+    clock func() time.Time
 }
 
 func (s *server) GetProductById(ctx context.Context, req *catalog.GetProductByIdRequest) (*catalog.Product, error) {
     start := time.Now()
     log.Printf("Received request for Product ID: %d", req.Id)
 
-    // Simulate fetching product from a database or external service
+    // Simulate a slow database or external service
     time.Sleep(2 * time.Second)
 
-    product := &catalog.Product{
-        Id:    req.Id,
-        Name:  "Sample Product",
-        Price: 9.99,
+    product, ok := s.store.get(req.Id)
+    // Drafts do not exist as far as shoppers can tell
+    if !ok || product.Status == catalog.ProductStatus_DRAFT && !seesDrafts(ctx) {
+        return nil, status.Errorf(codes.NotFound, "product %d not found", req.Id)
+    }
+    s.store.applyPromotions([]*catalog.Product{product}, s.now())
+    if err := s.localizeProducts(req.Currency, req.Region, product); err != nil {
+        return nil, err
+    }
+    product, err := catalog.ApplyReadMask(product, req.ReadMask)
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
     }
 
     duration := time.Since(start)
@@ -48,26 +67,200 @@ func (s *server) GetProductById(ctx context.Context, req *catalog.GetProductById
     return product, nil
 }
 
-func (s *server) ListProducts(ctx context.Context, req *catalog.ListProductsRequest) (*catalog.ListProductsResponse, error) {
-    // Simulate fetching a list of products (could be from a database or external service)
-    products := []*catalog.Product{
-        {Id: 1, Name: "Product 1", Price: 19.99},
-        {Id: 2, Name: "Product 2", Price: 29.99},
-        {Id: 3, Name: "Product 3", Price: 39.99},
+// maxBatchSize limits how many products one BatchGetProducts call may ask
+// for.
+const maxBatchSize = 100
+
+func (s *server) BatchGetProducts(ctx context.Context, req *catalog.BatchGetProductsRequest) (*catalog.BatchGetProductsResponse, error) {
+    start := time.Now()
+    if len(req.Ids) > maxBatchSize {
+        return nil, status.Errorf(codes.InvalidArgument, "at most %d IDs may be requested at once", maxBatchSize)
+    }
+
+    // Ask for each ID once, however often it was listed.
+    seen := make(map[int32]bool, len(req.Ids))
+    ids := make([]int32, 0, len(req.Ids))
+    for _, id := range req.Ids {
+        if !seen[id] {
+            seen[id] = true
+            ids = append(ids, id)
+        }
+    }
+
+    // One round trip to the slow database, however many IDs there are.
+    time.Sleep(2 * time.Second)
+
+    products, missing := s.store.getMany(ids, seesDrafts(ctx))
+    s.store.applyPromotions(products, s.now())
+    if err := s.localizeProducts(req.Currency, req.Region, products...); err != nil {
+        return nil, err
     }
+    grpcDuration.WithLabelValues("BatchGetProducts").Observe(time.Since(start).Seconds())
+    return &catalog.BatchGetProductsResponse{Products: products, MissingIds: missing}, nil
+}
+
+// seesDrafts reports whether the caller may read draft products, which
+// only catalog editors and admins can.
+func seesDrafts(ctx context.Context) bool {
+    claims, ok := authz.FromContext(ctx)
+    return ok && (claims.HasRole(authz.RoleCatalogEditor) || claims.HasRole(authz.RoleAdmin))
+}
 
+func (s *server) ListProducts(ctx context.Context, req *catalog.ListProductsRequest) (*catalog.ListProductsResponse, error) {
+    if req.IncludeDrafts && !seesDrafts(ctx) {
+        return nil, status.Error(codes.PermissionDenied, "only catalog editors may list drafts")
+    }
+    products, err := s.store.list(req.CategoryId, req.IncludeDrafts)
+    if err != nil {
+        return nil, categoryError(err, req.CategoryId)
+    }
+    s.store.applyPromotions(products, s.now())
+    if err := s.localizeProducts(req.Currency, req.Region, products...); err != nil {
+        return nil, err
+    }
     return &catalog.ListProductsResponse{
         Products: products,
     }, nil
 }
 
+// storeError converts an error from a versioned store change to a status.
+func storeError(err error, id int32, version int64) error {
+    switch {
+    case errors.Is(err, errProductNotFound):
+        return status.Errorf(codes.NotFound, "product %d not found", id)
+    case errors.Is(err, errVersionMismatch):
+        return status.Errorf(codes.Aborted, "product %d is no longer at version %d", id, version)
+    case errors.Is(err, errCategoryNotFound):
+        return status.Error(codes.InvalidArgument, "product refers to a category that does not exist")
+    case errors.Is(err, errDuplicateSKU):
+        return status.Error(codes.AlreadyExists, err.Error())
+    case errors.Is(err, errVariantsMismatch):
+        return status.Error(codes.FailedPrecondition, err.Error())
+    }
+    return status.Error(codes.Internal, err.Error())
+}
+
+func (s *server) CreateProduct(ctx context.Context, req *catalog.CreateProductRequest) (*catalog.Product, error) {
+    if err := validateProduct(req.Product); err != nil {
+        return nil, err
+    }
+    product, err := s.store.create(req.Product)
+    if err != nil {
+        return nil, storeError(err, 0, 0)
+    }
+    log.Printf("Created product %v", product)
+    return product, nil
+}
+
+func (s *server) UpdateProduct(ctx context.Context, req *catalog.UpdateProductRequest) (*catalog.Product, error) {
+    if err := validateProduct(req.Product); err != nil {
+        return nil, err
+    }
+    if req.Product.Version == 0 {
+        return nil, status.Error(codes.InvalidArgument, "version is required")
+    }
+    product, err := s.store.update(req.Product)
+    if err != nil {
+        return nil, storeError(err, req.Product.Id, req.Product.Version)
+    }
+    log.Printf("Updated product %v", product)
+    return product, nil
+}
+
+func (s *server) DeleteProduct(ctx context.Context, req *catalog.DeleteProductRequest) (*emptypb.Empty, error) {
+    if req.Version == 0 {
+        return nil, status.Error(codes.InvalidArgument, "version is required")
+    }
+    if err := s.store.delete(req.Id, req.Version); err != nil {
+        return nil, storeError(err, req.Id, req.Version)
+    }
+    log.Printf("Deleted product %d", req.Id)
+    return &emptypb.Empty{}, nil
+}
+
+func (s *server) WatchProducts(req *catalog.WatchProductsRequest, stream catalog.CatalogService_WatchProductsServer) error {
+    sub, err := s.store.feed.subscribe(req.ResumeToken)
+    switch {
+    case errors.Is(err, errResumeTokenExpired):
+        return status.Error(codes.OutOfRange, err.Error())
+    case err != nil:
+        return status.Error(codes.InvalidArgument, err.Error())
+    }
+    defer s.store.feed.unsubscribe(sub)
+
+    drafts := seesDrafts(stream.Context())
+    send := func(e feedEvent) error {
+        event := e.event
+        if !drafts {
+            event = e.public
+        }
+        if event == nil {
+            return nil
+        }
+        return stream.Send(event)
+    }
+    for _, event := range sub.backlog {
+        if err := send(event); err != nil {
+            return err
+        }
+    }
+    for {
+        select {
+        case event, ok := <-sub.Events:
+            if !ok {
+                return status.Error(codes.ResourceExhausted, "watcher fell behind, resume from the last token")
+            }
+            if err := send(event); err != nil {
+                return err
+            }
+        case <-stream.Context().Done():
+            return nil
+        }
+    }
+}
+
 func main() {
     lis, err := net.Listen("tcp", ":50052")
     if err != nil {
         log.Fatalf("Failed to listen: %v", err)
     }
-    s := grpc.NewServer()
-    catalog.RegisterCatalogServiceServer(s, &server{})
+    policy, err := authz.LoadPolicy(authz.PolicyPath(), "catalogserver")
+    if err != nil {
+        log.Fatalf("Failed to load authorization policy: %v", err)
+    }
+    tlsConfig, err := certs.FromEnv("catalogserver")
+    if err != nil {
+        log.Fatalf("Failed to load TLS certificates: %v", err)
+    }
+    authClient := certs.HTTPClient(tlsConfig)
+    keys := jwks.NewVerifier(authz.JWKSURL())
+    keys.Client = authClient
+    apiKeys := authz.NewAPIKeyClient(authz.APIKeyVerifyURL())
+    apiKeys.Client = authClient
+    authConn, err := grpc.Dial(authz.AuthGRPCAddr(), certs.DialCredentials(tlsConfig))
+    if err != nil {
+        log.Fatalf("Failed to connect to auth server: %v", err)
+    }
+    defer authConn.Close()
+    // Tokens are checked with authserver too, so that logging out or
+    // revoking a session locks the token out here within seconds.
+    sessions := authz.NewSessionClient(auth.NewAuthServiceClient(authConn))
+    verifier := &authz.Verifier{Keys: keys.Keyfunc, APIKeys: apiKeys, Sessions: sessions}
+
+    opts := append(certs.ServerCredentials(tlsConfig),
+        grpc.UnaryInterceptor(authz.UnaryServerInterceptor(policy, verifier)),
+        grpc.StreamInterceptor(authz.StreamServerInterceptor(policy, verifier)))
+    s := grpc.NewServer(opts...)
+    store := newProductStore(
+        &catalog.Product{Id: 1, Name: "Product 1", BasePrice: catalog.NewMoney("USD", 1999)},
+        &catalog.Product{Id: 2, Name: "Product 2", BasePrice: catalog.NewMoney("USD", 2999)},
+        &catalog.Product{Id: 3, Name: "Product 3", BasePrice: catalog.NewMoney("USD", 3999)},
+    )
+    rates, err := loadExchangeRates(exchangeRatesPath())
+    if err != nil {
+        log.Fatalf("Failed to load exchange rates: %v", err)
+    }
+    catalog.RegisterCatalogServiceServer(s, &server{store: store, rates: rates})
     log.Println("Starting gRPC server on port 50052...")
     go func() {
         if err := s.Serve(lis); err != nil {
@@ -75,11 +268,17 @@ func main() {
         }
     }()
 
-    // HTTP server for Prometheus metrics
+    // HTTP server for Prometheus metrics. Scrapers are not services with
+    // certificates of their own, so TLS_CLIENT_AUTH does not apply here;
+    // METRICS_TLS_CLIENT_AUTH sets what they must present.
+    metricsAuth, err := certs.ClientAuthFromEnv("METRICS_TLS_CLIENT_AUTH")
+    if err != nil {
+        log.Fatalf("Failed to configure metrics TLS: %v", err)
+    }
     http.Handle("/metrics", promhttp.Handler())
     log.Println("Starting metrics HTTP server on port 9091...")
     go func() {
-        if err := http.ListenAndServe(":9091", nil); err != nil {
+        if err := certs.ListenAndServe(":9091", http.DefaultServeMux, tlsConfig.WithClientAuth(metricsAuth)); err != nil {
             log.Fatalf("Failed to serve metrics: %v", err)
         }
     }()
@@ -90,4 +289,3 @@ func main() {
     // Keep the main goroutine running until interrupted
     select {}
 }
-
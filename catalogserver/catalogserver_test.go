@@ -0,0 +1,69 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+func TestBatchGetProducts(t *testing.T) {
+    store := newProductStore(
+        &catalog.Product{Id: 1, Name: "Product 1", Price: 19.99},
+        &catalog.Product{Id: 2, Name: "Product 2", Price: 29.99},
+        &catalog.Product{Id: 3, Name: "Upcoming", Price: 9.99, Status: catalog.ProductStatus_DRAFT},
+    )
+    s := &server{store: store}
+    editor := authz.NewContext(context.Background(), &authz.Claims{Roles: []string{authz.RoleCatalogEditor}})
+
+    tests := []struct {
+        name          string
+        ctx           context.Context
+        ids           []int32
+        found, missed []int32
+    }{
+        // Products come back in the order asked for, each once, and IDs
+        // that do not exist are listed rather than failing the call.
+        {"anonymous", context.Background(), []int32{2, 9, 1, 2, 3}, []int32{2, 1}, []int32{9, 3}},
+        {"editor", editor, []int32{3, 1, 3}, []int32{3, 1}, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            // Each call takes the simulated database's two seconds.
+            t.Parallel()
+            resp, err := s.BatchGetProducts(tt.ctx, &catalog.BatchGetProductsRequest{Ids: tt.ids})
+            if err != nil {
+                t.Fatal(err)
+            }
+            var found []int32
+            for _, p := range resp.Products {
+                found = append(found, p.Id)
+            }
+            if !equalIDs(found, tt.found) || !equalIDs(resp.MissingIds, tt.missed) {
+                t.Errorf("found %v and missed %v, want %v and %v", found, resp.MissingIds, tt.found, tt.missed)
+            }
+        })
+    }
+
+    t.Run("too many", func(t *testing.T) {
+        _, err := s.BatchGetProducts(context.Background(), &catalog.BatchGetProductsRequest{Ids: make([]int32, maxBatchSize+1)})
+        if status.Code(err) != codes.InvalidArgument {
+            t.Errorf("%d IDs: %v, want InvalidArgument", maxBatchSize+1, err)
+        }
+    })
+}
+
+func equalIDs(a, b []int32) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
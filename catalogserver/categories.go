@@ -0,0 +1,227 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "sort"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/emptypb"
+)
+
+var (
+    errCategoryNotFound = errors.New("category not found")
+    errParentNotFound   = errors.New("parent category not found")
+    errCategoryCycle    = errors.New("a category cannot be moved below itself")
+    errCategoryInUse    = errors.New("category still has subcategories, products or promotions")
+)
+
+// Categories live in productStore, under the same lock as the products,
+// so that assignments can be checked against the tree atomically.
+
+func (s *productStore) getCategory(id int32) (*catalog.Category, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    c, ok := s.categories[id]
+    if !ok {
+        return nil, false
+    }
+    return proto.Clone(c).(*catalog.Category), true
+}
+
+// children returns the IDs of the direct children of parent, in order.
+// The caller must hold s.mu.
+func (s *productStore) children(parent int32) []int32 {
+    var ids []int32
+    for id, c := range s.categories {
+        if c.ParentId == parent {
+            ids = append(ids, id)
+        }
+    }
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    return ids
+}
+
+// subtree returns parent's descendants, parents before their children.
+// The caller must hold s.mu.
+func (s *productStore) subtree(parent int32) []int32 {
+    var ids []int32
+    for _, id := range s.children(parent) {
+        ids = append(ids, id)
+        ids = append(ids, s.subtree(id)...)
+    }
+    return ids
+}
+
+func (s *productStore) listCategories(parent int32, recursive bool) ([]*catalog.Category, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.categories[parent]; parent != 0 && !ok {
+        return nil, errCategoryNotFound
+    }
+    ids := s.children(parent)
+    if recursive {
+        ids = s.subtree(parent)
+    }
+    categories := make([]*catalog.Category, 0, len(ids))
+    for _, id := range ids {
+        categories = append(categories, proto.Clone(s.categories[id]).(*catalog.Category))
+    }
+    return categories, nil
+}
+
+// checkParent makes sure c may be placed under c.ParentId. The caller must
+// hold s.mu.
+func (s *productStore) checkParent(c *catalog.Category) error {
+    for parent := c.ParentId; parent != 0; parent = s.categories[parent].ParentId {
+        if _, ok := s.categories[parent]; !ok {
+            return errParentNotFound
+        }
+        if parent == c.Id {
+            return errCategoryCycle
+        }
+    }
+    return nil
+}
+
+func (s *productStore) createCategory(c *catalog.Category) (*catalog.Category, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    c = proto.Clone(c).(*catalog.Category)
+    c.Id = 0
+    if err := s.checkParent(c); err != nil {
+        return nil, err
+    }
+    c.Id = s.nextCategoryID
+    s.nextCategoryID++
+    s.categories[c.Id] = c
+    return proto.Clone(c).(*catalog.Category), nil
+}
+
+func (s *productStore) updateCategory(c *catalog.Category, now time.Time) (*catalog.Category, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    current, ok := s.categories[c.Id]
+    if !ok {
+        return nil, errCategoryNotFound
+    }
+    c = proto.Clone(c).(*catalog.Category)
+    if err := s.checkParent(c); err != nil {
+        return nil, err
+    }
+    s.categories[c.Id] = c
+    // Moving a category changes which promotions its products get.
+    if c.ParentId != current.ParentId {
+        s.publishSaleChanges(nil, []int32{c.Id}, now)
+    }
+    return proto.Clone(c).(*catalog.Category), nil
+}
+
+func (s *productStore) deleteCategory(id int32) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.categories[id]; !ok {
+        return errCategoryNotFound
+    }
+    if len(s.children(id)) > 0 {
+        return errCategoryInUse
+    }
+    for _, p := range s.products {
+        for _, c := range p.CategoryIds {
+            if c == id {
+                return errCategoryInUse
+            }
+        }
+    }
+    for _, promo := range s.promotions {
+        if promo.GetCategoryId() == id {
+            return errCategoryInUse
+        }
+    }
+    delete(s.categories, id)
+    return nil
+}
+
+// normalizeCategories sorts and deduplicates p's category IDs and checks
+// that they exist. The caller must hold s.mu.
+func (s *productStore) normalizeCategories(p *catalog.Product) error {
+    ids := p.CategoryIds
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+    unique := ids[:0]
+    for i, id := range ids {
+        if _, ok := s.categories[id]; !ok {
+            return errCategoryNotFound
+        }
+        if i == 0 || id != ids[i-1] {
+            unique = append(unique, id)
+        }
+    }
+    p.CategoryIds = unique
+    return nil
+}
+
+// categoryError converts an error from the category store to a status.
+func categoryError(err error, id int32) error {
+    switch {
+    case errors.Is(err, errCategoryNotFound):
+        return status.Errorf(codes.NotFound, "category %d not found", id)
+    case errors.Is(err, errParentNotFound), errors.Is(err, errCategoryCycle):
+        return status.Error(codes.InvalidArgument, err.Error())
+    case errors.Is(err, errCategoryInUse):
+        return status.Errorf(codes.FailedPrecondition, "category %d still has subcategories, products or promotions", id)
+    }
+    return status.Error(codes.Internal, err.Error())
+}
+
+func (s *server) GetCategory(ctx context.Context, req *catalog.GetCategoryRequest) (*catalog.Category, error) {
+    category, ok := s.store.getCategory(req.Id)
+    if !ok {
+        return nil, status.Errorf(codes.NotFound, "category %d not found", req.Id)
+    }
+    return category, nil
+}
+
+func (s *server) ListCategories(ctx context.Context, req *catalog.ListCategoriesRequest) (*catalog.ListCategoriesResponse, error) {
+    categories, err := s.store.listCategories(req.ParentId, req.Recursive)
+    if err != nil {
+        return nil, categoryError(err, req.ParentId)
+    }
+    return &catalog.ListCategoriesResponse{Categories: categories}, nil
+}
+
+func (s *server) CreateCategory(ctx context.Context, req *catalog.CreateCategoryRequest) (*catalog.Category, error) {
+    if req.Category == nil || req.Category.Name == "" {
+        return nil, status.Error(codes.InvalidArgument, "category name is required")
+    }
+    category, err := s.store.createCategory(req.Category)
+    if err != nil {
+        return nil, categoryError(err, req.Category.Id)
+    }
+    log.Printf("Created category %v", category)
+    return category, nil
+}
+
+func (s *server) UpdateCategory(ctx context.Context, req *catalog.UpdateCategoryRequest) (*catalog.Category, error) {
+    if req.Category == nil || req.Category.Name == "" {
+        return nil, status.Error(codes.InvalidArgument, "category name is required")
+    }
+    category, err := s.store.updateCategory(req.Category, s.now())
+    if err != nil {
+        return nil, categoryError(err, req.Category.Id)
+    }
+    log.Printf("Updated category %v", category)
+    return category, nil
+}
+
+func (s *server) DeleteCategory(ctx context.Context, req *catalog.DeleteCategoryRequest) (*emptypb.Empty, error) {
+    if err := s.store.deleteCategory(req.Id); err != nil {
+        return nil, categoryError(err, req.Id)
+    }
+    log.Printf("Deleted category %d", req.Id)
+    return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+)
+
+func TestCategoryTree(t *testing.T) {
+    store := newProductStore()
+    mustCreate := func(name string, parent int32) int32 {
+        c, err := store.createCategory(&catalog.Category{Name: name, ParentId: parent})
+        if err != nil {
+            t.Fatal(err)
+        }
+        return c.Id
+    }
+    clothing := mustCreate("Clothing", 0)
+    shirts := mustCreate("Shirts", clothing)
+    tshirts := mustCreate("T-shirts", shirts)
+    books := mustCreate("Books", 0)
+
+    shirt, _ := store.create(&catalog.Product{Name: "Tee", CategoryIds: []int32{tshirts, tshirts}})
+    store.create(&catalog.Product{Name: "Novel", CategoryIds: []int32{books}})
+    if len(shirt.CategoryIds) != 1 {
+        t.Errorf("duplicate category IDs kept: %v", shirt.CategoryIds)
+    }
+    if _, err := store.create(&catalog.Product{Name: "Ghost", CategoryIds: []int32{99}}); !errors.Is(err, errCategoryNotFound) {
+        t.Errorf("unknown category accepted: %v", err)
+    }
+
+    products, err := store.list(clothing, false)
+    if err != nil || len(products) != 1 || products[0].Id != shirt.Id {
+        t.Errorf("products under Clothing: %v, %v", products, err)
+    }
+
+    all, _ := store.listCategories(clothing, true)
+    if len(all) != 2 || all[0].Id != shirts || all[1].Id != tshirts {
+        t.Errorf("subtree of Clothing: %v", all)
+    }
+
+    if _, err := store.updateCategory(&catalog.Category{Id: clothing, Name: "Clothing", ParentId: tshirts}, time.Now()); !errors.Is(err, errCategoryCycle) {
+        t.Errorf("moving a category below itself: %v", err)
+    }
+    if err := store.deleteCategory(shirts); !errors.Is(err, errCategoryInUse) {
+        t.Errorf("deleting a category with children: %v", err)
+    }
+    if err := store.deleteCategory(tshirts); !errors.Is(err, errCategoryInUse) {
+        t.Errorf("deleting a category with products: %v", err)
+    }
+}
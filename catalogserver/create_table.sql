@@ -0,0 +1,99 @@
+CREATE TABLE products (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    -- The base price in minor units of currency, such as cents.
+    price_minor BIGINT NOT NULL CHECK (price_minor >= 0),
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    version BIGINT NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sku VARCHAR(64) UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    brand VARCHAR(200) NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'ACTIVE' CHECK (status IN ('ACTIVE', 'DRAFT'))
+);
+
+CREATE TABLE product_images (
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    PRIMARY KEY (product_id, position)
+);
+
+-- Exactly one of the value columns is set, matching AttributeValue.
+CREATE TABLE product_attributes (
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    string_value TEXT,
+    number_value DOUBLE PRECISION,
+    bool_value BOOLEAN,
+    PRIMARY KEY (product_id, name),
+    CHECK (num_nonnulls(string_value, number_value, bool_value) = 1)
+);
+
+-- A price set by hand for a currency, in one region or, with a NULL
+-- region, wherever the currency is used.
+CREATE TABLE product_prices (
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    currency CHAR(3) NOT NULL,
+    region CHAR(2),
+    price_minor BIGINT NOT NULL CHECK (price_minor >= 0),
+    UNIQUE NULLS NOT DISTINCT (product_id, currency, region)
+);
+
+CREATE TABLE categories (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    parent_id INTEGER REFERENCES categories(id)
+);
+
+CREATE INDEX categories_parent_id ON categories (parent_id);
+
+CREATE TABLE product_categories (
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    category_id INTEGER NOT NULL REFERENCES categories(id),
+    PRIMARY KEY (product_id, category_id)
+);
+
+CREATE INDEX product_categories_category_id ON product_categories (category_id);
+
+CREATE TABLE product_options (
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    option_values TEXT[] NOT NULL,
+    PRIMARY KEY (product_id, position),
+    UNIQUE (product_id, name)
+);
+
+-- options maps each of the product's option names to this variant's value.
+CREATE TABLE product_variants (
+    id SERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    sku VARCHAR(64) NOT NULL UNIQUE,
+    options JSONB NOT NULL,
+    -- In the product's currency.
+    price_minor BIGINT NOT NULL CHECK (price_minor >= 0),
+    stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0),
+    UNIQUE (product_id, options)
+);
+
+-- Exactly one of product_id and category_id is set, and exactly one kind
+-- of discount. Amounts are in minor units of currency.
+CREATE TABLE promotions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    product_id INTEGER REFERENCES products(id) ON DELETE CASCADE,
+    category_id INTEGER REFERENCES categories(id),
+    percent_off INTEGER CHECK (percent_off BETWEEN 1 AND 100),
+    amount_off_minor BIGINT CHECK (amount_off_minor >= 0),
+    fixed_price_minor BIGINT CHECK (fixed_price_minor >= 0),
+    currency CHAR(3),
+    start_time TIMESTAMP,
+    end_time TIMESTAMP CHECK (end_time > start_time),
+    CHECK (num_nonnulls(product_id, category_id) = 1),
+    CHECK (num_nonnulls(percent_off, amount_off_minor, fixed_price_minor) = 1),
+    CHECK ((currency IS NULL) = (percent_off IS NOT NULL))
+);
+
+CREATE INDEX promotions_product_id ON promotions (product_id);
+CREATE INDEX promotions_category_id ON promotions (category_id);
@@ -0,0 +1,252 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/big"
+    "os"
+    "regexp"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+)
+
+var (
+    errNoExchangeRate = errors.New("no exchange rate")
+
+    currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+    regionPattern   = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// exchangeRatesPath returns the exchange rate table catalogserver loads,
+// from EXCHANGE_RATES_FILE or the default under config/.
+func exchangeRatesPath() string {
+    if path := os.Getenv("EXCHANGE_RATES_FILE"); path != "" {
+        return path
+    }
+    return "config/exchange_rates.json"
+}
+
+// roundingRule says how converted prices in a currency are rounded: to a
+// multiple of increment minor units, to the nearest one or always up or
+// down. The zero rule rounds to the nearest minor unit.
+type roundingRule struct {
+    Increment int64  `json:"increment"`
+    Mode      string `json:"mode"`
+}
+
+func (r roundingRule) validate() error {
+    switch {
+    case r.Increment < 0:
+        return errors.New("rounding increment must not be negative")
+    case r.Mode != "" && r.Mode != "nearest" && r.Mode != "up" && r.Mode != "down":
+        return fmt.Errorf("unknown rounding mode %q", r.Mode)
+    }
+    return nil
+}
+
+// round rounds an exact, non-negative amount of minor units by the rule.
+func (r roundingRule) round(minor *big.Rat) int64 {
+    increment := r.Increment
+    if increment == 0 {
+        increment = 1
+    }
+    steps := new(big.Rat).Quo(minor, new(big.Rat).SetInt64(increment))
+    whole, rest := new(big.Int).QuoRem(steps.Num(), steps.Denom(), new(big.Int))
+    if rest.Sign() != 0 {
+        switch r.Mode {
+        case "up":
+            whole.Add(whole, big.NewInt(1))
+        case "down":
+        default:
+            // Half way rounds up, as prices usually do.
+            if new(big.Int).Lsh(rest, 1).Cmp(steps.Denom()) >= 0 {
+                whole.Add(whole, big.NewInt(1))
+            }
+        }
+    }
+    return whole.Int64() * increment
+}
+
+// exchangeRates is the table prices without a price list entry are
+// converted with. Each rate is how much of a currency one unit of base
+// buys; conversions between two other currencies go through base.
+type exchangeRates struct {
+    base     string
+    rates    map[string]*big.Rat
+    rounding map[string]roundingRule
+}
+
+// exchangeRatesFile is the JSON form of exchangeRates. Rates are decimal
+// numbers, read exactly.
+type exchangeRatesFile struct {
+    Base     string                  `json:"base"`
+    Rates    map[string]json.Number  `json:"rates"`
+    Rounding map[string]roundingRule `json:"rounding"`
+}
+
+func loadExchangeRates(path string) (*exchangeRates, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    return parseExchangeRates(data)
+}
+
+func parseExchangeRates(data []byte) (*exchangeRates, error) {
+    var file exchangeRatesFile
+    if err := json.Unmarshal(data, &file); err != nil {
+        return nil, err
+    }
+    if !currencyPattern.MatchString(file.Base) {
+        return nil, fmt.Errorf("base currency %q is not an ISO 4217 code", file.Base)
+    }
+    r := &exchangeRates{
+        base:     file.Base,
+        rates:    map[string]*big.Rat{file.Base: big.NewRat(1, 1)},
+        rounding: map[string]roundingRule{},
+    }
+    for currency, number := range file.Rates {
+        rate, ok := new(big.Rat).SetString(number.String())
+        if !currencyPattern.MatchString(currency) || !ok || rate.Sign() <= 0 {
+            return nil, fmt.Errorf("invalid exchange rate %s for %q", number, currency)
+        }
+        r.rates[currency] = rate
+    }
+    for currency, rule := range file.Rounding {
+        if err := rule.validate(); err != nil {
+            return nil, fmt.Errorf("rounding for %s: %w", currency, err)
+        }
+        r.rounding[currency] = rule
+    }
+    return r, nil
+}
+
+// rate returns how much of to one unit of from buys.
+func (r *exchangeRates) rate(from, to string) (*big.Rat, error) {
+    if from == to {
+        return big.NewRat(1, 1), nil
+    }
+    if r == nil {
+        return nil, fmt.Errorf("%w from %s to %s", errNoExchangeRate, from, to)
+    }
+    fromRate, ok := r.rates[from]
+    toRate, ok2 := r.rates[to]
+    if !ok || !ok2 {
+        return nil, fmt.Errorf("%w from %s to %s", errNoExchangeRate, from, to)
+    }
+    return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+// amount returns m as an exact number of units of its currency.
+func amount(m *catalog.Money) *big.Rat {
+    return new(big.Rat).Add(new(big.Rat).SetInt64(m.Units), big.NewRat(int64(m.Nanos), 1_000_000_000))
+}
+
+// priceConverter turns a product's and its variants' base prices into
+// localized ones: every amount is multiplied by factor, expressed in
+// currency and rounded by the currency's rule.
+type priceConverter struct {
+    currency string
+    factor   *big.Rat
+    rule     roundingRule
+}
+
+func (c *priceConverter) convert(m *catalog.Money) *catalog.Money {
+    if m == nil {
+        return nil
+    }
+    minor := new(big.Rat).Mul(amount(m), c.factor)
+    for i := 0; i < catalog.MinorDigits(c.currency); i++ {
+        minor.Mul(minor, big.NewRat(10, 1))
+    }
+    return catalog.NewMoney(c.currency, c.rule.round(minor))
+}
+
+// regionalPrice returns the price list entry of p for currency in region,
+// preferring one for exactly that region over one for any region.
+func regionalPrice(p *catalog.Product, currency, region string) *catalog.Money {
+    var anywhere *catalog.Money
+    for _, entry := range p.PriceList {
+        if entry.Price.GetCurrencyCode() != currency {
+            continue
+        }
+        if region != "" && entry.Region == region {
+            return entry.Price
+        }
+        if entry.Region == "" {
+            anywhere = entry.Price
+        }
+    }
+    return anywhere
+}
+
+// localize sets the localized list and sale prices of p and its variants
+// for currency and region. The product's price comes from its price list if
+// it has an entry, and otherwise from its base price at the exchange rate.
+// Other prices are scaled by the same factor, so that a price set by hand
+// carries over to them.
+func (r *exchangeRates) localize(p *catalog.Product, currency, region string) error {
+    if p.BasePrice == nil {
+        return nil
+    }
+    converter := &priceConverter{currency: currency, rule: r.roundingRule(currency)}
+    listed := regionalPrice(p, currency, region)
+    if base := amount(p.BasePrice); listed != nil && base.Sign() != 0 {
+        converter.factor = new(big.Rat).Quo(amount(listed), base)
+    } else {
+        rate, err := r.rate(p.BasePrice.CurrencyCode, currency)
+        if err != nil {
+            return err
+        }
+        converter.factor = rate
+    }
+    if listed == nil && p.BasePrice.CurrencyCode == currency {
+        // Nothing to convert, so nothing to round either.
+        converter.rule = roundingRule{}
+    }
+    if listed != nil {
+        p.LocalizedPrice = proto.Clone(listed).(*catalog.Money)
+    } else {
+        p.LocalizedPrice = converter.convert(p.BasePrice)
+    }
+    p.LocalizedSalePrice = converter.convert(p.SalePrice)
+    for _, v := range p.Variants {
+        v.LocalizedPrice = converter.convert(v.BasePrice)
+        v.LocalizedSalePrice = converter.convert(v.SalePrice)
+    }
+    return nil
+}
+
+func (r *exchangeRates) roundingRule(currency string) roundingRule {
+    if r == nil {
+        return roundingRule{}
+    }
+    return r.rounding[currency]
+}
+
+// localizeProducts sets the localized prices of products for a read that
+// asked for currency and region, if it did.
+func (s *server) localizeProducts(currency, region string, products ...*catalog.Product) error {
+    if currency == "" {
+        if region != "" {
+            return status.Error(codes.InvalidArgument, "region requires currency")
+        }
+        return nil
+    }
+    if !currencyPattern.MatchString(currency) {
+        return status.Errorf(codes.InvalidArgument, "currency %q is not an ISO 4217 code", currency)
+    }
+    if region != "" && !regionPattern.MatchString(region) {
+        return status.Errorf(codes.InvalidArgument, "region %q is not an ISO 3166-1 alpha-2 code", region)
+    }
+    for _, p := range products {
+        if err := s.rates.localize(p, currency, region); err != nil {
+            return status.Error(codes.InvalidArgument, err.Error())
+        }
+    }
+    return nil
+}
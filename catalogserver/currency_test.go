@@ -0,0 +1,94 @@
+package main
+
+import (
+    "errors"
+    "math/big"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/catalog"
+)
+
+func TestExchangeRatesFile(t *testing.T) {
+    if _, err := loadExchangeRates("../config/exchange_rates.json"); err != nil {
+        t.Fatal(err)
+    }
+    for _, bad := range []string{
+        `{"base": "usd"}`,
+        `{"base": "USD", "rates": {"EUR": 0}}`,
+        `{"base": "USD", "rates": {"EUR": -0.9}}`,
+        `{"base": "USD", "rounding": {"JPY": {"mode": "sideways"}}}`,
+    } {
+        if _, err := parseExchangeRates([]byte(bad)); err == nil {
+            t.Errorf("parsed %s", bad)
+        }
+    }
+}
+
+func TestRoundingRule(t *testing.T) {
+    for _, tc := range []struct {
+        rule  roundingRule
+        minor *big.Rat
+        want  int64
+    }{
+        {roundingRule{}, big.NewRat(2999, 2), 1500},
+        {roundingRule{}, big.NewRat(2997, 2), 1499},
+        {roundingRule{Mode: "down"}, big.NewRat(2999, 2), 1499},
+        {roundingRule{Increment: 10}, big.NewRat(2987, 1), 2990},
+        {roundingRule{Increment: 100, Mode: "up"}, big.NewRat(120001, 1), 120100},
+        {roundingRule{Increment: 5, Mode: "up"}, big.NewRat(1755, 1), 1755},
+    } {
+        if got := tc.rule.round(tc.minor); got != tc.want {
+            t.Errorf("%+v rounds %s to %d, want %d", tc.rule, tc.minor.FloatString(2), got, tc.want)
+        }
+    }
+}
+
+func TestLocalize(t *testing.T) {
+    rates, err := parseExchangeRates([]byte(`{
+        "base": "USD",
+        "rates": {"EUR": 0.9, "JPY": 150, "GBP": 0.8},
+        "rounding": {"JPY": {"increment": 10}}
+    }`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    product := func() *catalog.Product {
+        return &catalog.Product{
+            BasePrice: catalog.NewMoney("EUR", 2000),
+            PriceList: []*catalog.RegionalPrice{
+                {Price: catalog.NewMoney("GBP", 1500)},
+                {Price: catalog.NewMoney("GBP", 1600), Region: "GG"},
+            },
+            Variants: []*catalog.Variant{{BasePrice: catalog.NewMoney("EUR", 3000)}},
+        }
+    }
+
+    for _, tc := range []struct {
+        currency, region string
+        product, variant string
+    }{
+        // EUR to JPY goes through USD: 20 / 0.9 × 150 = 3333.33, to 10 yen.
+        {"JPY", "", "3330 JPY", "5000 JPY"},
+        {"EUR", "", "20.00 EUR", "30.00 EUR"},
+        // A price list entry wins and variants are scaled like it.
+        {"GBP", "", "15.00 GBP", "22.50 GBP"},
+        {"GBP", "GB", "15.00 GBP", "22.50 GBP"},
+        {"GBP", "GG", "16.00 GBP", "24.00 GBP"},
+    } {
+        p := product()
+        if err := rates.localize(p, tc.currency, tc.region); err != nil {
+            t.Errorf("%s in %q: %v", tc.currency, tc.region, err)
+            continue
+        }
+        if got := p.LocalizedPrice.Format(); got != tc.product {
+            t.Errorf("%s in %q: product costs %s, want %s", tc.currency, tc.region, got, tc.product)
+        }
+        if got := p.Variants[0].LocalizedPrice.Format(); got != tc.variant {
+            t.Errorf("%s in %q: variant costs %s, want %s", tc.currency, tc.region, got, tc.variant)
+        }
+    }
+
+    if err := rates.localize(product(), "CHF", ""); !errors.Is(err, errNoExchangeRate) {
+        t.Errorf("currency without a rate: %v", err)
+    }
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "errors"
+    "strconv"
+    "sync"
+
+    "github.com/sys-apps-go/microservices/catalog"
+)
+
+const (
+    // feedHistory is how many past events are kept for resuming watchers.
+    feedHistory = 1024
+    // subscriberBuffer is how many events may be queued for one watcher
+    // before it counts as too slow and is dropped.
+    subscriberBuffer = 64
+)
+
+var (
+    errResumeTokenInvalid = errors.New("invalid resume token")
+    errResumeTokenExpired = errors.New("resume token is too old")
+)
+
+// changeFeed fans product changes out to watchers. Every event gets the
+// next sequence number, which is also its resume token, and the most
+// recent ones are kept so a watcher can reconnect without missing any.
+type changeFeed struct {
+    mu          sync.Mutex
+    seq         uint64
+    history     []feedEvent
+    subscribers map[*subscription]struct{}
+    // drafts holds the products last published as drafts.
+    drafts map[int32]bool
+}
+
+// feedEvent is a change as catalog editors see it and as everyone else
+// does. public is nil for changes they should not hear of at all.
+type feedEvent struct {
+    event  *catalog.ProductEvent
+    public *catalog.ProductEvent
+}
+
+// subscription is one watcher's queue. Events is closed when the feed drops
+// the watcher for falling behind.
+type subscription struct {
+    Events  chan feedEvent
+    backlog []feedEvent
+}
+
+func newChangeFeed() *changeFeed {
+    return &changeFeed{subscribers: map[*subscription]struct{}{}, drafts: map[int32]bool{}}
+}
+
+// publish records a change to p. It never blocks: a watcher whose buffer
+// is full is dropped and has to resume from its last token.
+func (f *changeFeed) publish(typ catalog.ProductEvent_Type, p *catalog.Product) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.seq++
+    event := &catalog.ProductEvent{
+        Type:        typ,
+        Product:     p,
+        ResumeToken: strconv.FormatUint(f.seq, 10),
+    }
+    e := feedEvent{event: event, public: f.publicEvent(event)}
+    f.history = append(f.history, e)
+    if len(f.history) > feedHistory {
+        f.history = f.history[len(f.history)-feedHistory:]
+    }
+    for sub := range f.subscribers {
+        select {
+        case sub.Events <- e:
+        default:
+            delete(f.subscribers, sub)
+            close(sub.Events)
+        }
+    }
+}
+
+// publicEvent returns event as watchers who cannot see drafts get it, and
+// notes whether its product is now a draft. Drafts do not exist for those
+// watchers: a product that became one looks deleted to them, with nothing
+// but its ID, and one that stopped being one looks created.
+func (f *changeFeed) publicEvent(event *catalog.ProductEvent) *catalog.ProductEvent {
+    id := event.Product.Id
+    wasDraft := f.drafts[id]
+    isDraft := event.Type != catalog.ProductEvent_DELETED && event.Product.Status == catalog.ProductStatus_DRAFT
+    if isDraft {
+        f.drafts[id] = true
+    } else {
+        delete(f.drafts, id)
+    }
+
+    switch {
+    case isDraft && !wasDraft && event.Type == catalog.ProductEvent_UPDATED:
+        return &catalog.ProductEvent{
+            Type:        catalog.ProductEvent_DELETED,
+            Product:     &catalog.Product{Id: id},
+            ResumeToken: event.ResumeToken,
+        }
+    case isDraft, wasDraft && event.Type == catalog.ProductEvent_DELETED:
+        return nil
+    case wasDraft:
+        return &catalog.ProductEvent{
+            Type:        catalog.ProductEvent_CREATED,
+            Product:     event.Product,
+            ResumeToken: event.ResumeToken,
+        }
+    }
+    return event
+}
+
+// subscribe starts a subscription. With a resume token, the events after it
+// are returned by the subscription's backlog before anything new arrives.
+func (f *changeFeed) subscribe(resumeToken string) (*subscription, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    sub := &subscription{Events: make(chan feedEvent, subscriberBuffer)}
+    if resumeToken != "" {
+        seq, err := strconv.ParseUint(resumeToken, 10, 64)
+        if err != nil || seq > f.seq {
+            return nil, errResumeTokenInvalid
+        }
+        missed := int(f.seq - seq)
+        if missed > len(f.history) {
+            return nil, errResumeTokenExpired
+        }
+        sub.backlog = append(sub.backlog, f.history[len(f.history)-missed:]...)
+    }
+    f.subscribers[sub] = struct{}{}
+    return sub, nil
+}
+
+func (f *changeFeed) unsubscribe(sub *subscription) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if _, ok := f.subscribers[sub]; ok {
+        delete(f.subscribers, sub)
+        close(sub.Events)
+    }
+}
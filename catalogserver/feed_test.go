@@ -0,0 +1,138 @@
+package main
+
+import (
+    "context"
+    "net"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/status"
+    "google.golang.org/grpc/test/bufconn"
+)
+
+func newTestCatalogClient(t *testing.T, store *productStore) catalog.CatalogServiceClient {
+    t.Helper()
+    lis := bufconn.Listen(1 << 20)
+    s := grpc.NewServer()
+    catalog.RegisterCatalogServiceServer(s, &server{store: store})
+    go s.Serve(lis)
+    t.Cleanup(s.Stop)
+
+    conn, err := grpc.Dial("bufnet",
+        grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+            return lis.DialContext(ctx)
+        }),
+        grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    return catalog.NewCatalogServiceClient(conn)
+}
+
+func TestWatchProductsResume(t *testing.T) {
+    store := newProductStore(&catalog.Product{Id: 1, Name: "Product 1", Price: 19.99})
+    client := newTestCatalogClient(t, store)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    // Changes made before and while the stream is being opened are all
+    // delivered, as they come after the resume token.
+    created, _ := store.create(&catalog.Product{Name: "Product 2", Price: 29.99})
+    stream, err := client.WatchProducts(ctx, &catalog.WatchProductsRequest{ResumeToken: "1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    store.update(&catalog.Product{Id: created.Id, Name: "Product 2", Price: 24.99, Version: created.Version})
+    store.delete(1, 1)
+
+    want := []struct {
+        typ catalog.ProductEvent_Type
+        id  int32
+    }{{catalog.ProductEvent_UPDATED, created.Id}, {catalog.ProductEvent_DELETED, 1}}
+    var last string
+    for _, w := range want {
+        event, err := stream.Recv()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if event.Type != w.typ || event.Product.Id != w.id {
+            t.Errorf("got %v for product %d, want %v for product %d", event.Type, event.Product.Id, w.typ, w.id)
+        }
+        last = event.ResumeToken
+    }
+    if last != "3" {
+        t.Errorf("last resume token %q, want 3", last)
+    }
+
+    if _, err := store.feed.subscribe("99"); err != errResumeTokenInvalid {
+        t.Errorf("token from the future: %v", err)
+    }
+}
+
+func TestWatchProductsHidesDrafts(t *testing.T) {
+    store := newProductStore(&catalog.Product{Id: 1, Name: "Product 1", Price: 19.99})
+    client := newTestCatalogClient(t, store)
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    stream, err := client.WatchProducts(ctx, &catalog.WatchProductsRequest{ResumeToken: "0"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // To a watcher who cannot see drafts, changes to the draft go unseen,
+    // the product taken back to draft looks deleted, with nothing but its
+    // ID, and the draft looks created once it is published.
+    draft, _ := store.create(&catalog.Product{Name: "Upcoming", Status: catalog.ProductStatus_DRAFT})
+    draft, _ = store.update(&catalog.Product{Id: draft.Id, Name: "Upcoming", Price: 9.99, Version: draft.Version})
+    store.update(&catalog.Product{Id: 1, Name: "Product 1", Price: 19.99, Status: catalog.ProductStatus_DRAFT, Version: 1})
+    store.update(&catalog.Product{Id: draft.Id, Name: "Upcoming", Price: 9.99, Status: catalog.ProductStatus_ACTIVE, Version: draft.Version})
+
+    want := []struct {
+        typ   catalog.ProductEvent_Type
+        id    int32
+        name  string
+        token string
+    }{
+        {catalog.ProductEvent_DELETED, 1, "", "3"},
+        {catalog.ProductEvent_CREATED, draft.Id, "Upcoming", "4"},
+    }
+    for _, w := range want {
+        event, err := stream.Recv()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if event.Type != w.typ || event.Product.Id != w.id || event.Product.Name != w.name || event.ResumeToken != w.token {
+            t.Errorf("got %v, want %v for product %d named %q at token %s", event, w.typ, w.id, w.name, w.token)
+        }
+    }
+}
+
+func TestWatchProductsDropsSlowWatcher(t *testing.T) {
+    store := newProductStore()
+    sub, err := store.feed.subscribe("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i <= subscriberBuffer; i++ {
+        store.create(&catalog.Product{Name: "Product"})
+    }
+    for range sub.Events {
+    }
+    // Reaching here means Events was closed after the buffer filled up.
+
+    for i := 0; i < feedHistory; i++ {
+        store.create(&catalog.Product{Name: "Product"})
+    }
+    client := newTestCatalogClient(t, store)
+    stream, err := client.WatchProducts(context.Background(), &catalog.WatchProductsRequest{ResumeToken: "1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := stream.Recv(); status.Code(err) != codes.OutOfRange {
+        t.Errorf("resuming from an expired token: %v, want OutOfRange", err)
+    }
+}
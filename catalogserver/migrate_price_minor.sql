@@ -0,0 +1,21 @@
+-- Moves prices from REAL to integer minor units for databases created
+-- before base_price. Every existing price is in US dollars.
+BEGIN;
+
+ALTER TABLE products
+    ADD COLUMN price_minor BIGINT,
+    ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+UPDATE products SET price_minor = ROUND(price::NUMERIC * 100);
+ALTER TABLE products
+    ALTER COLUMN price_minor SET NOT NULL,
+    ADD CHECK (price_minor >= 0),
+    DROP COLUMN price;
+
+ALTER TABLE product_variants ADD COLUMN price_minor BIGINT;
+UPDATE product_variants SET price_minor = ROUND(price::NUMERIC * 100);
+ALTER TABLE product_variants
+    ALTER COLUMN price_minor SET NOT NULL,
+    ADD CHECK (price_minor >= 0),
+    DROP COLUMN price;
+
+COMMIT;
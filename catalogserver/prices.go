@@ -0,0 +1,87 @@
+package main
+
+import (
+    "errors"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+)
+
+// errVariantCurrency means a variant is priced in another currency than
+// its product.
+var errVariantCurrency = errors.New("variants must be priced in the product's currency")
+
+// legacyPrice returns the base price a client meant when it may only know
+// the deprecated float price. Clients that predate base_price either leave
+// it unset or, over gRPC, send back the one they read unchanged next to the
+// price they edited; in both cases the float price wins. A zero float
+// price counts as unset, since JSON clients that know base_price leave it
+// out. oldBase and oldPrice are the stored prices, or nil and 0 for
+// something new, and currency is used when there is no base price to take
+// it from.
+func legacyPrice(base *catalog.Money, price float32, oldBase *catalog.Money, oldPrice float32, currency string) *catalog.Money {
+    if base == nil {
+        return catalog.MoneyFromFloat(currency, float64(price))
+    }
+    if oldBase != nil && proto.Equal(base, oldBase) && price != 0 && price != oldPrice {
+        return catalog.MoneyFromFloat(base.CurrencyCode, float64(price))
+    }
+    return base
+}
+
+// normalizePrices fills in the base prices of p and its variants from the
+// deprecated float prices where needed, and sets the float prices from the
+// base prices for clients that still read them. Localized and sale prices
+// are only ever computed for reads, so any a client sent are dropped.
+// current is the stored product p replaces, if any; p keeps its currency
+// when a client only gives a float price.
+func normalizePrices(p, current *catalog.Product) error {
+    currency := catalog.DefaultCurrency
+    if current.GetBasePrice() != nil {
+        currency = current.BasePrice.CurrencyCode
+    }
+    p.BasePrice = legacyPrice(p.BasePrice, p.Price, current.GetBasePrice(), current.GetPrice(), currency)
+    p.Price = float32(p.BasePrice.Float())
+    p.LocalizedPrice = nil
+    p.SalePrice, p.LocalizedSalePrice, p.SaleEndTime = nil, nil, nil
+    p.NextSaleStartTime, p.SaleUpdatedAt = nil, nil
+
+    stored := map[int32]*catalog.Variant{}
+    for _, v := range current.GetVariants() {
+        stored[v.Id] = v
+    }
+    for _, v := range p.Variants {
+        old := stored[v.Id]
+        v.BasePrice = legacyPrice(v.BasePrice, v.Price, old.GetBasePrice(), old.GetPrice(), p.BasePrice.CurrencyCode)
+        if v.BasePrice.CurrencyCode != p.BasePrice.CurrencyCode {
+            return errVariantCurrency
+        }
+        v.Price = float32(v.BasePrice.Float())
+        v.LocalizedPrice = nil
+        v.SalePrice, v.LocalizedSalePrice = nil, nil
+    }
+    return nil
+}
+
+// validatePrice checks a price given by a client. Either price may be
+// unset; normalizePrices fills it in from the other.
+func validatePrice(base *catalog.Money, price float32) error {
+    if price < 0 {
+        return status.Error(codes.InvalidArgument, "price must not be negative")
+    }
+    if base == nil {
+        return nil
+    }
+    if err := base.Validate(); err != nil {
+        return status.Error(codes.InvalidArgument, err.Error())
+    }
+    if base.Negative() {
+        return status.Error(codes.InvalidArgument, "price must not be negative")
+    }
+    if _, err := base.MinorUnits(); err != nil {
+        return status.Error(codes.InvalidArgument, err.Error())
+    }
+    return nil
+}
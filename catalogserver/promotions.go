@@ -0,0 +1,393 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "log"
+    "math/big"
+    "sort"
+    "time"
+    "unicode/utf8"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/emptypb"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var errPromotionNotFound = errors.New("promotion not found")
+
+// now returns the time reads resolve promotions at. Tests replace clock to
+// move through a promotion's schedule.
+func (s *server) now() time.Time {
+    if s.clock != nil {
+        return s.clock()
+    }
+    return time.Now()
+}
+
+// running reports whether promo is in effect at now.
+func running(promo *catalog.Promotion, now time.Time) bool {
+    if promo.StartTime != nil && now.Before(promo.StartTime.AsTime()) {
+        return false
+    }
+    return promo.EndTime == nil || now.Before(promo.EndTime.AsTime())
+}
+
+// discount returns how promo changes prices given base, the price of the
+// product it is applied to, or false if it cannot apply to that product.
+func discount(promo *catalog.Promotion, base *catalog.Money) (func(*catalog.Money) *catalog.Money, bool) {
+    switch d := promo.Discount.(type) {
+    case *catalog.Promotion_PercentOff:
+        c := &priceConverter{currency: base.CurrencyCode, factor: big.NewRat(int64(100-d.PercentOff), 100)}
+        return c.convert, true
+    case *catalog.Promotion_AmountOff:
+        if d.AmountOff.CurrencyCode != base.CurrencyCode {
+            return nil, false
+        }
+        return func(m *catalog.Money) *catalog.Money {
+            sale, err := m.Sub(d.AmountOff)
+            if err != nil || sale.Negative() {
+                return catalog.NewMoney(m.CurrencyCode, 0)
+            }
+            return sale
+        }, true
+    case *catalog.Promotion_FixedPrice:
+        if d.FixedPrice.CurrencyCode != base.CurrencyCode || amount(base).Sign() == 0 {
+            return nil, false
+        }
+        c := &priceConverter{currency: base.CurrencyCode, factor: new(big.Rat).Quo(amount(d.FixedPrice), amount(base))}
+        return c.convert, true
+    }
+    return nil, false
+}
+
+// ancestors returns the categories p is in and every category above them.
+// The caller must hold s.mu.
+func (s *productStore) ancestors(p *catalog.Product) map[int32]bool {
+    ids := map[int32]bool{}
+    for _, id := range p.CategoryIds {
+        for ; id != 0 && !ids[id]; id = s.categories[id].GetParentId() {
+            ids[id] = true
+        }
+    }
+    return ids
+}
+
+// applyPromotions sets the sale prices of products to the lowest any
+// promotion running at now gives them, and notes when promotions last
+// changed them and when the next one that could starts. A promotion that
+// would not lower a product's price is ignored.
+func (s *productStore) applyPromotions(products []*catalog.Product, now time.Time) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    ids := make([]int32, 0, len(s.promotions))
+    for id := range s.promotions {
+        ids = append(ids, id)
+    }
+    sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+    for _, p := range products {
+        if p.BasePrice == nil {
+            continue
+        }
+        categories := s.ancestors(p)
+        changed := s.saleUpdated[p.Id]
+        var best *catalog.Promotion
+        var bestPrice func(*catalog.Money) *catalog.Money
+        var bestSale *catalog.Money
+        for _, id := range ids {
+            promo := s.promotions[id]
+            if promo.GetProductId() != p.Id && !categories[promo.GetCategoryId()] {
+                continue
+            }
+            price, ok := discount(promo, p.BasePrice)
+            if !ok {
+                continue
+            }
+            if end := promo.EndTime; end != nil && !now.Before(end.AsTime()) {
+                if end.AsTime().After(changed) {
+                    changed = end.AsTime()
+                }
+                continue
+            }
+            if !running(promo, now) {
+                if next := p.NextSaleStartTime; next == nil || promo.StartTime.AsTime().Before(next.AsTime()) {
+                    p.NextSaleStartTime = proto.Clone(promo.StartTime).(*timestamppb.Timestamp)
+                }
+                continue
+            }
+            if start := promo.StartTime; start != nil && start.AsTime().After(changed) {
+                changed = start.AsTime()
+            }
+            sale := price(p.BasePrice)
+            if cmp, _ := sale.Cmp(p.BasePrice); cmp >= 0 {
+                continue
+            }
+            if bestSale != nil {
+                if cmp, _ := sale.Cmp(bestSale); cmp >= 0 {
+                    continue
+                }
+            }
+            best, bestPrice, bestSale = promo, price, sale
+        }
+        if !changed.IsZero() {
+            p.SaleUpdatedAt = timestamppb.New(changed)
+        }
+        if best == nil {
+            continue
+        }
+        p.SalePrice = bestSale
+        if best.EndTime != nil {
+            p.SaleEndTime = proto.Clone(best.EndTime).(*timestamppb.Timestamp)
+        }
+        for _, v := range p.Variants {
+            if v.BasePrice != nil {
+                v.SalePrice = bestPrice(v.BasePrice)
+            }
+        }
+    }
+}
+
+// publishSaleChanges tells watchers that the sale prices of the products
+// in productIDs, and of those in the categories in categoryIDs or any
+// category below them, may have changed at now. The caller must hold s.mu.
+func (s *productStore) publishSaleChanges(productIDs, categoryIDs []int32, now time.Time) {
+    categories := map[int32]bool{}
+    for _, id := range categoryIDs {
+        categories[id] = true
+        for _, sub := range s.subtree(id) {
+            categories[sub] = true
+        }
+    }
+    ids := map[int32]bool{}
+    for _, id := range productIDs {
+        ids[id] = true
+    }
+    for _, p := range s.products {
+        for _, c := range p.CategoryIds {
+            if categories[c] {
+                ids[p.Id] = true
+            }
+        }
+    }
+
+    sorted := make([]int32, 0, len(ids))
+    for id := range ids {
+        sorted = append(sorted, id)
+    }
+    sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+    for _, id := range sorted {
+        if p, ok := s.products[id]; ok {
+            s.saleUpdated[id] = now
+            s.feed.publish(catalog.ProductEvent_UPDATED, proto.Clone(p).(*catalog.Product))
+        }
+    }
+}
+
+// publishPromotionChange calls publishSaleChanges for the products and
+// categories promos are for. The caller must hold s.mu.
+func (s *productStore) publishPromotionChange(now time.Time, promos ...*catalog.Promotion) {
+    var productIDs, categoryIDs []int32
+    for _, promo := range promos {
+        if id := promo.GetProductId(); id != 0 {
+            productIDs = append(productIDs, id)
+        }
+        if id := promo.GetCategoryId(); id != 0 {
+            categoryIDs = append(categoryIDs, id)
+        }
+    }
+    s.publishSaleChanges(productIDs, categoryIDs, now)
+}
+
+// checkTarget makes sure the product or category promo is for exists. The
+// caller must hold s.mu.
+func (s *productStore) checkTarget(promo *catalog.Promotion) error {
+    if id := promo.GetProductId(); id != 0 {
+        if _, ok := s.products[id]; !ok {
+            return errProductNotFound
+        }
+    }
+    if id := promo.GetCategoryId(); id != 0 {
+        if _, ok := s.categories[id]; !ok {
+            return errCategoryNotFound
+        }
+    }
+    return nil
+}
+
+func (s *productStore) getPromotion(id int32) (*catalog.Promotion, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    promo, ok := s.promotions[id]
+    if !ok {
+        return nil, false
+    }
+    return proto.Clone(promo).(*catalog.Promotion), true
+}
+
+// listPromotions returns the promotions for productID or categoryID, or
+// all of them if both are 0, ordered by ID. If current is set, those that
+// ended before now are left out.
+func (s *productStore) listPromotions(productID, categoryID int32, current bool, now time.Time) []*catalog.Promotion {
+    s.mu.Lock()
+    var promotions []*catalog.Promotion
+    for _, promo := range s.promotions {
+        if productID != 0 && promo.GetProductId() != productID || categoryID != 0 && promo.GetCategoryId() != categoryID {
+            continue
+        }
+        if current && promo.EndTime != nil && !now.Before(promo.EndTime.AsTime()) {
+            continue
+        }
+        promotions = append(promotions, proto.Clone(promo).(*catalog.Promotion))
+    }
+    s.mu.Unlock()
+    sort.Slice(promotions, func(i, j int) bool { return promotions[i].Id < promotions[j].Id })
+    return promotions
+}
+
+func (s *productStore) createPromotion(promo *catalog.Promotion, now time.Time) (*catalog.Promotion, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if err := s.checkTarget(promo); err != nil {
+        return nil, err
+    }
+    promo = proto.Clone(promo).(*catalog.Promotion)
+    promo.Id = s.nextPromotionID
+    s.nextPromotionID++
+    s.promotions[promo.Id] = promo
+    s.publishPromotionChange(now, promo)
+    return proto.Clone(promo).(*catalog.Promotion), nil
+}
+
+func (s *productStore) updatePromotion(promo *catalog.Promotion, now time.Time) (*catalog.Promotion, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    current, ok := s.promotions[promo.Id]
+    if !ok {
+        return nil, errPromotionNotFound
+    }
+    if err := s.checkTarget(promo); err != nil {
+        return nil, err
+    }
+    promo = proto.Clone(promo).(*catalog.Promotion)
+    s.promotions[promo.Id] = promo
+    // Products the promotion no longer applies to lose its sale price.
+    s.publishPromotionChange(now, current, promo)
+    return proto.Clone(promo).(*catalog.Promotion), nil
+}
+
+func (s *productStore) deletePromotion(id int32, now time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    promo, ok := s.promotions[id]
+    if !ok {
+        return errPromotionNotFound
+    }
+    delete(s.promotions, id)
+    s.publishPromotionChange(now, promo)
+    return nil
+}
+
+// promotionError converts an error from the promotion store to a status.
+func promotionError(err error, id int32) error {
+    switch {
+    case errors.Is(err, errPromotionNotFound):
+        return status.Errorf(codes.NotFound, "promotion %d not found", id)
+    case errors.Is(err, errProductNotFound), errors.Is(err, errCategoryNotFound):
+        return status.Error(codes.InvalidArgument, "promotion is for a product or category that does not exist")
+    }
+    return status.Error(codes.Internal, err.Error())
+}
+
+func validatePromotion(promo *catalog.Promotion) error {
+    if promo == nil || promo.Name == "" {
+        return status.Error(codes.InvalidArgument, "promotion name is required")
+    }
+    if utf8.RuneCountInString(promo.Name) > maxNameLength {
+        return status.Errorf(codes.InvalidArgument, "name must be at most %d characters", maxNameLength)
+    }
+    if promo.GetProductId() == 0 && promo.GetCategoryId() == 0 {
+        return status.Error(codes.InvalidArgument, "promotion needs a product_id or category_id")
+    }
+
+    switch d := promo.Discount.(type) {
+    case nil:
+        return status.Error(codes.InvalidArgument, "promotion needs a percent_off, amount_off or fixed_price")
+    case *catalog.Promotion_PercentOff:
+        if d.PercentOff < 1 || d.PercentOff > 100 {
+            return status.Error(codes.InvalidArgument, "percent_off must be from 1 to 100")
+        }
+    case *catalog.Promotion_AmountOff:
+        if d.AmountOff == nil {
+            return status.Error(codes.InvalidArgument, "amount_off is required")
+        }
+        if err := validatePrice(d.AmountOff, 0); err != nil {
+            return err
+        }
+    case *catalog.Promotion_FixedPrice:
+        if d.FixedPrice == nil {
+            return status.Error(codes.InvalidArgument, "fixed_price is required")
+        }
+        if err := validatePrice(d.FixedPrice, 0); err != nil {
+            return err
+        }
+    }
+
+    for _, t := range []*timestamppb.Timestamp{promo.StartTime, promo.EndTime} {
+        if t != nil && t.CheckValid() != nil {
+            return status.Error(codes.InvalidArgument, "start_time and end_time must be valid times")
+        }
+    }
+    if promo.StartTime != nil && promo.EndTime != nil && !promo.EndTime.AsTime().After(promo.StartTime.AsTime()) {
+        return status.Error(codes.InvalidArgument, "end_time must be after start_time")
+    }
+    return nil
+}
+
+func (s *server) GetPromotion(ctx context.Context, req *catalog.GetPromotionRequest) (*catalog.Promotion, error) {
+    promo, ok := s.store.getPromotion(req.Id)
+    if !ok {
+        return nil, status.Errorf(codes.NotFound, "promotion %d not found", req.Id)
+    }
+    return promo, nil
+}
+
+func (s *server) ListPromotions(ctx context.Context, req *catalog.ListPromotionsRequest) (*catalog.ListPromotionsResponse, error) {
+    promotions := s.store.listPromotions(req.ProductId, req.CategoryId, req.Current, s.now())
+    return &catalog.ListPromotionsResponse{Promotions: promotions}, nil
+}
+
+func (s *server) CreatePromotion(ctx context.Context, req *catalog.CreatePromotionRequest) (*catalog.Promotion, error) {
+    if err := validatePromotion(req.Promotion); err != nil {
+        return nil, err
+    }
+    promo, err := s.store.createPromotion(req.Promotion, s.now())
+    if err != nil {
+        return nil, promotionError(err, 0)
+    }
+    log.Printf("Created promotion %v", promo)
+    return promo, nil
+}
+
+func (s *server) UpdatePromotion(ctx context.Context, req *catalog.UpdatePromotionRequest) (*catalog.Promotion, error) {
+    if err := validatePromotion(req.Promotion); err != nil {
+        return nil, err
+    }
+    promo, err := s.store.updatePromotion(req.Promotion, s.now())
+    if err != nil {
+        return nil, promotionError(err, req.Promotion.Id)
+    }
+    log.Printf("Updated promotion %v", promo)
+    return promo, nil
+}
+
+func (s *server) DeletePromotion(ctx context.Context, req *catalog.DeletePromotionRequest) (*emptypb.Empty, error) {
+    if err := s.store.deletePromotion(req.Id, s.now()); err != nil {
+        return nil, promotionError(err, req.Id)
+    }
+    log.Printf("Deleted promotion %d", req.Id)
+    return &emptypb.Empty{}, nil
+}
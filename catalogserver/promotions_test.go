@@ -0,0 +1,161 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestPromotionSchedule(t *testing.T) {
+    ctx := context.Background()
+    store := newProductStore()
+    sale, _ := store.createCategory(&catalog.Category{Name: "Sale"})
+    shirts, _ := store.createCategory(&catalog.Category{Name: "Shirts", ParentId: sale.Id})
+    p, _ := store.create(&catalog.Product{Name: "Shirt", BasePrice: catalog.NewMoney("USD", 10000), CategoryIds: []int32{shirts.Id}})
+    p.Options = []*catalog.VariantOption{{Name: "size", Values: []string{"XL"}}}
+    p, _ = store.update(p)
+    store.createVariant(p.Id, p.Version, &catalog.Variant{Sku: "SHIRT-XL", Options: map[string]string{"size": "XL"}, BasePrice: catalog.NewMoney("USD", 12000)})
+
+    rates, _ := parseExchangeRates([]byte(`{"base": "USD", "rates": {"EUR": 0.5}}`))
+    start := time.Date(2026, 11, 27, 0, 0, 0, 0, time.UTC)
+    now := start
+    s := &server{store: store, rates: rates, clock: func() time.Time { return now }}
+    at := func(hours int) *timestamppb.Timestamp {
+        return timestamppb.New(start.Add(time.Duration(hours) * time.Hour))
+    }
+
+    for _, promo := range []*catalog.Promotion{
+        {Name: "Category sale", Target: &catalog.Promotion_CategoryId{CategoryId: sale.Id},
+            Discount: &catalog.Promotion_PercentOff{PercentOff: 10}, StartTime: at(1), EndTime: at(3)},
+        {Name: "Doorbuster", Target: &catalog.Promotion_ProductId{ProductId: p.Id},
+            Discount: &catalog.Promotion_FixedPrice{FixedPrice: catalog.NewMoney("USD", 8000)}, StartTime: at(2), EndTime: at(4)},
+        {Name: "Euro coupon", Target: &catalog.Promotion_ProductId{ProductId: p.Id},
+            Discount: &catalog.Promotion_AmountOff{AmountOff: catalog.NewMoney("EUR", 5000)}},
+    } {
+        if _, err := s.CreatePromotion(ctx, &catalog.CreatePromotionRequest{Promotion: promo}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for _, tc := range []struct {
+        hours                   int
+        product, variant, local string
+        ends, next, changed     *timestamppb.Timestamp
+    }{
+        {0, "", "", "", nil, at(1), at(0)},
+        {1, "90.00 USD", "108.00 USD", "45.00 EUR", at(3), at(2), at(1)},
+        // The lower price wins while both run.
+        {2, "80.00 USD", "96.00 USD", "40.00 EUR", at(4), nil, at(2)},
+        {3, "80.00 USD", "96.00 USD", "40.00 EUR", at(4), nil, at(3)},
+        {4, "", "", "", nil, nil, at(4)},
+    } {
+        now = start.Add(time.Duration(tc.hours) * time.Hour)
+        resp, err := s.ListProducts(ctx, &catalog.ListProductsRequest{Currency: "EUR"})
+        if err != nil {
+            t.Fatal(err)
+        }
+        got := resp.Products[0]
+        if tc.next == nil && got.NextSaleStartTime != nil || tc.next != nil && !got.NextSaleStartTime.AsTime().Equal(tc.next.AsTime()) {
+            t.Errorf("at +%dh the next sale starts at %v, want %v", tc.hours, got.NextSaleStartTime, tc.next)
+        }
+        if !got.SaleUpdatedAt.AsTime().Equal(tc.changed.AsTime()) {
+            t.Errorf("at +%dh the sale last changed at %v, want %v", tc.hours, got.SaleUpdatedAt.AsTime(), tc.changed.AsTime())
+        }
+        if got.BasePrice.Format() != "100.00 USD" || got.LocalizedPrice.Format() != "50.00 EUR" {
+            t.Errorf("at +%dh the list price is %s, %s", tc.hours, got.BasePrice.Format(), got.LocalizedPrice.Format())
+        }
+        if tc.product == "" {
+            if got.SalePrice != nil || got.Variants[0].SalePrice != nil || got.SaleEndTime != nil {
+                t.Errorf("at +%dh the product is on sale for %s", tc.hours, got.SalePrice.Format())
+            }
+            continue
+        }
+        if got.SalePrice.Format() != tc.product || got.Variants[0].SalePrice.Format() != tc.variant || got.LocalizedSalePrice.Format() != tc.local {
+            t.Errorf("at +%dh the sale prices are %s, %s and %s, want %s, %s and %s", tc.hours,
+                got.SalePrice.Format(), got.Variants[0].SalePrice.Format(), got.LocalizedSalePrice.Format(), tc.product, tc.variant, tc.local)
+        }
+        if !got.SaleEndTime.AsTime().Equal(tc.ends.AsTime()) {
+            t.Errorf("at +%dh the sale ends at %v, want %v", tc.hours, got.SaleEndTime.AsTime(), tc.ends.AsTime())
+        }
+    }
+
+    current, _ := s.ListPromotions(ctx, &catalog.ListPromotionsRequest{Current: true})
+    if len(current.Promotions) != 1 || current.Promotions[0].Name != "Euro coupon" {
+        t.Errorf("current promotions after the sales: %v", current.Promotions)
+    }
+    if err := store.deleteCategory(sale.Id); !errors.Is(err, errCategoryInUse) {
+        t.Errorf("deleting a category with a promotion: %v", err)
+    }
+}
+
+func TestPromotionEvents(t *testing.T) {
+    store := newProductStore()
+    sale, _ := store.createCategory(&catalog.Category{Name: "Sale"})
+    shirts, _ := store.createCategory(&catalog.Category{Name: "Shirts", ParentId: sale.Id})
+    shirt, _ := store.create(&catalog.Product{Name: "Shirt", CategoryIds: []int32{shirts.Id}})
+    mug, _ := store.create(&catalog.Product{Name: "Mug"})
+    sub, err := store.feed.subscribe("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    expect := func(what string, ids ...int32) {
+        t.Helper()
+        for _, id := range ids {
+            select {
+            case e := <-sub.Events:
+                if e.event.Type != catalog.ProductEvent_UPDATED || e.event.Product.Id != id {
+                    t.Errorf("%s: got %v, want product %d updated", what, e.event, id)
+                }
+            default:
+                t.Errorf("%s: no event for product %d", what, id)
+            }
+        }
+        select {
+        case e := <-sub.Events:
+            t.Errorf("%s: unexpected %v", what, e.event)
+        default:
+        }
+    }
+
+    // A category promotion reaches the products in the categories below.
+    promo, _ := store.createPromotion(&catalog.Promotion{Name: "Sale", Target: &catalog.Promotion_CategoryId{CategoryId: sale.Id},
+        Discount: &catalog.Promotion_PercentOff{PercentOff: 10}}, time.Now())
+    expect("create", shirt.Id)
+    promo.Target = &catalog.Promotion_ProductId{ProductId: mug.Id}
+    store.updatePromotion(promo, time.Now())
+    expect("retarget", shirt.Id, mug.Id)
+    store.deletePromotion(promo.Id, time.Now())
+    expect("delete", mug.Id)
+    store.updateCategory(&catalog.Category{Id: shirts.Id, Name: "Shirts"}, time.Now())
+    expect("move category", shirt.Id)
+}
+
+func TestValidatePromotion(t *testing.T) {
+    product := &catalog.Promotion_ProductId{ProductId: 1}
+    for _, promo := range []*catalog.Promotion{
+        {Target: product, Discount: &catalog.Promotion_PercentOff{PercentOff: 10}},
+        {Name: "No target", Discount: &catalog.Promotion_PercentOff{PercentOff: 10}},
+        {Name: "No discount", Target: product},
+        {Name: "Too much", Target: product, Discount: &catalog.Promotion_PercentOff{PercentOff: 101}},
+        {Name: "Negative", Target: product, Discount: &catalog.Promotion_AmountOff{AmountOff: catalog.NewMoney("USD", -1)}},
+        {Name: "Backwards", Target: product, Discount: &catalog.Promotion_PercentOff{PercentOff: 10},
+            StartTime: timestamppb.New(time.Unix(2000, 0)), EndTime: timestamppb.New(time.Unix(1000, 0))},
+    } {
+        if status.Code(validatePromotion(promo)) != codes.InvalidArgument {
+            t.Errorf("%q was accepted", promo.Name)
+        }
+    }
+
+    s := &server{store: newProductStore()}
+    _, err := s.CreatePromotion(context.Background(), &catalog.CreatePromotionRequest{Promotion: &catalog.Promotion{
+        Name: "Missing", Target: product, Discount: &catalog.Promotion_PercentOff{PercentOff: 10}}})
+    if status.Code(err) != codes.InvalidArgument {
+        t.Errorf("promotion for a missing product: %v", err)
+    }
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+    errProductNotFound = errors.New("product not found")
+    errVersionMismatch = errors.New("product has been changed since the given version")
+    errDuplicateSKU    = errors.New("another product has the same SKU")
+)
+
+// productStore is the simulated product database. Products are stored and
+// returned as copies so callers cannot change them behind the lock. Every
+// change is published to feed while the lock is held, so watchers see
+// changes in the order they were made.
+type productStore struct {
+    mu       sync.Mutex
+    products map[int32]*catalog.Product
+    nextID   int32
+    feed     *changeFeed
+
+    categories     map[int32]*catalog.Category
+    nextCategoryID int32
+
+    nextVariantID int32
+
+    promotions      map[int32]*catalog.Promotion
+    nextPromotionID int32
+    // saleUpdated holds when promotion changes last touched each product.
+    saleUpdated map[int32]time.Time
+}
+
+func newProductStore(seed ...*catalog.Product) *productStore {
+    s := &productStore{products: map[int32]*catalog.Product{}, nextID: 1, feed: newChangeFeed()}
+    s.categories = map[int32]*catalog.Category{}
+    s.nextCategoryID = 1
+    s.nextVariantID = 1
+    s.promotions = map[int32]*catalog.Promotion{}
+    s.saleUpdated = map[int32]time.Time{}
+    s.nextPromotionID = 1
+    for _, p := range seed {
+        p = proto.Clone(p).(*catalog.Product)
+        if p.Version == 0 {
+            p.Version = 1
+        }
+        if p.UpdatedAt == nil {
+            p.UpdatedAt = timestamppb.Now()
+        }
+        if p.Status == catalog.ProductStatus_PRODUCT_STATUS_UNSPECIFIED {
+            p.Status = catalog.ProductStatus_ACTIVE
+        }
+        normalizePrices(p, nil)
+        s.products[p.Id] = p
+        if p.Status == catalog.ProductStatus_DRAFT {
+            s.feed.drafts[p.Id] = true
+        }
+        if p.Id >= s.nextID {
+            s.nextID = p.Id + 1
+        }
+    }
+    return s
+}
+
+func (s *productStore) get(id int32) (*catalog.Product, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    p, ok := s.products[id]
+    if !ok {
+        return nil, false
+    }
+    return proto.Clone(p).(*catalog.Product), true
+}
+
+// getMany looks up all of ids under one lock, returning the products found
+// in the order of ids and the IDs that were not. Drafts count as not found
+// unless drafts is set.
+func (s *productStore) getMany(ids []int32, drafts bool) (found []*catalog.Product, missing []int32) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, id := range ids {
+        p, ok := s.products[id]
+        if !ok || p.Status == catalog.ProductStatus_DRAFT && !drafts {
+            missing = append(missing, id)
+            continue
+        }
+        found = append(found, proto.Clone(p).(*catalog.Product))
+    }
+    return found, missing
+}
+
+// list returns the active products ordered by ID, and the drafts too if
+// drafts is set. If category is not 0 only those in category or any category
+// below it are returned.
+func (s *productStore) list(category int32, drafts bool) ([]*catalog.Product, error) {
+    s.mu.Lock()
+    var wanted map[int32]bool
+    if category != 0 {
+        if _, ok := s.categories[category]; !ok {
+            s.mu.Unlock()
+            return nil, errCategoryNotFound
+        }
+        wanted = map[int32]bool{category: true}
+        for _, id := range s.subtree(category) {
+            wanted[id] = true
+        }
+    }
+    products := make([]*catalog.Product, 0, len(s.products))
+    for _, p := range s.products {
+        if p.Status == catalog.ProductStatus_DRAFT && !drafts {
+            continue
+        }
+        if wanted == nil || inCategories(p, wanted) {
+            products = append(products, proto.Clone(p).(*catalog.Product))
+        }
+    }
+    s.mu.Unlock()
+    sort.Slice(products, func(i, j int) bool { return products[i].Id < products[j].Id })
+    return products, nil
+}
+
+// checkSKU makes sure the SKUs of p and its variants are not used anywhere
+// else in the catalog. The caller must hold s.mu.
+func (s *productStore) checkSKU(p *catalog.Product) error {
+    used := map[string]bool{}
+    for _, other := range s.products {
+        if other.Id == p.Id {
+            continue
+        }
+        used[other.Sku] = true
+        for _, v := range other.Variants {
+            used[v.Sku] = true
+        }
+    }
+    skus := []string{p.Sku}
+    for _, v := range p.Variants {
+        skus = append(skus, v.Sku)
+    }
+    for _, sku := range skus {
+        if sku == "" {
+            continue
+        }
+        if used[sku] {
+            return errDuplicateSKU
+        }
+        used[sku] = true
+    }
+    return nil
+}
+
+func inCategories(p *catalog.Product, categories map[int32]bool) bool {
+    for _, id := range p.CategoryIds {
+        if categories[id] {
+            return true
+        }
+    }
+    return false
+}
+
+// create stores p under a new ID and returns the stored product.
+func (s *productStore) create(p *catalog.Product) (*catalog.Product, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    p = proto.Clone(p).(*catalog.Product)
+    if err := s.normalizeCategories(p); err != nil {
+        return nil, err
+    }
+    p.Id = 0
+    p.Variants = nil
+    normalizePrices(p, nil)
+    if err := s.checkSKU(p); err != nil {
+        return nil, err
+    }
+    if p.Status == catalog.ProductStatus_PRODUCT_STATUS_UNSPECIFIED {
+        p.Status = catalog.ProductStatus_ACTIVE
+    }
+    p.Id = s.nextID
+    p.Version = 1
+    p.UpdatedAt = timestamppb.Now()
+    s.nextID++
+    s.products[p.Id] = p
+    s.feed.publish(catalog.ProductEvent_CREATED, proto.Clone(p).(*catalog.Product))
+    return proto.Clone(p).(*catalog.Product), nil
+}
+
+// update replaces the product with p's ID and moves it to the next version,
+// provided the stored product is still at p.Version.
+func (s *productStore) update(p *catalog.Product) (*catalog.Product, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    current, ok := s.products[p.Id]
+    if !ok {
+        return nil, errProductNotFound
+    }
+    if p.Version != current.Version {
+        return nil, errVersionMismatch
+    }
+    p = proto.Clone(p).(*catalog.Product)
+    if err := s.normalizeCategories(p); err != nil {
+        return nil, err
+    }
+    p.Variants = current.Variants
+    if err := checkVariants(p); err != nil {
+        return nil, fmt.Errorf("%w: %v", errVariantsMismatch, err)
+    }
+    if err := normalizePrices(p, current); err != nil {
+        return nil, fmt.Errorf("%w: %v", errVariantsMismatch, err)
+    }
+    if err := s.checkSKU(p); err != nil {
+        return nil, err
+    }
+    if p.Status == catalog.ProductStatus_PRODUCT_STATUS_UNSPECIFIED {
+        p.Status = current.Status
+    }
+    p.Version = current.Version + 1
+    p.UpdatedAt = timestamppb.Now()
+    s.products[p.Id] = p
+    s.feed.publish(catalog.ProductEvent_UPDATED, proto.Clone(p).(*catalog.Product))
+    return proto.Clone(p).(*catalog.Product), nil
+}
+
+// delete removes the product with id, provided it is still at version,
+// along with its promotions.
+func (s *productStore) delete(id int32, version int64) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    p, ok := s.products[id]
+    if !ok {
+        return errProductNotFound
+    }
+    if p.Version != version {
+        return errVersionMismatch
+    }
+    delete(s.products, id)
+    delete(s.saleUpdated, id)
+    for promoID, promo := range s.promotions {
+        if promo.GetProductId() == id {
+            delete(s.promotions, promoID)
+        }
+    }
+    s.feed.publish(catalog.ProductEvent_DELETED, p)
+    return nil
+}
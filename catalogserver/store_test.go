@@ -0,0 +1,181 @@
+package main
+
+import (
+    "errors"
+    "sync"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestStoreOptimisticConcurrency(t *testing.T) {
+    store := newProductStore(&catalog.Product{Id: 1, Name: "Product 1", Price: 19.99})
+
+    // Editors that all read version 1 race to update; only one may win.
+    var wg sync.WaitGroup
+    var mu sync.Mutex
+    won := 0
+    for i := 0; i < 20; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _, err := store.update(&catalog.Product{Id: 1, Name: "Edited", Version: 1})
+            if err == nil {
+                mu.Lock()
+                won++
+                mu.Unlock()
+            } else if !errors.Is(err, errVersionMismatch) {
+                t.Error(err)
+            }
+        }()
+    }
+    wg.Wait()
+    if won != 1 {
+        t.Fatalf("%d updates based on version 1 succeeded, want 1", won)
+    }
+
+    if err := store.delete(1, 1); !errors.Is(err, errVersionMismatch) {
+        t.Errorf("delete at a stale version: %v", err)
+    }
+    if err := store.delete(1, 2); err != nil {
+        t.Errorf("delete at the current version: %v", err)
+    }
+    if err := store.delete(1, 2); !errors.Is(err, errProductNotFound) {
+        t.Errorf("delete of a deleted product: %v", err)
+    }
+}
+
+func TestStoreProductStatusAndSKU(t *testing.T) {
+    store := newProductStore(&catalog.Product{Id: 1, Name: "Product 1", Sku: "P-1"})
+
+    draft, err := store.create(&catalog.Product{Name: "Upcoming", Sku: "P-2", Status: catalog.ProductStatus_DRAFT})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := store.create(&catalog.Product{Name: "Copy", Sku: "P-1"}); !errors.Is(err, errDuplicateSKU) {
+        t.Errorf("duplicate SKU: %v", err)
+    }
+
+    // A client that does not know about statuses keeps the draft a draft.
+    updated, err := store.update(&catalog.Product{Id: draft.Id, Name: "Upcoming", Sku: "P-2", Version: draft.Version})
+    if err != nil || updated.Status != catalog.ProductStatus_DRAFT {
+        t.Errorf("update without status: %v, %v", updated, err)
+    }
+
+    active, _ := store.list(0, false)
+    all, _ := store.list(0, true)
+    if len(active) != 1 || active[0].Status != catalog.ProductStatus_ACTIVE || len(all) != 2 {
+        t.Errorf("listed %d active and %d in total, want 1 and 2", len(active), len(all))
+    }
+
+    found, missing := store.getMany([]int32{draft.Id, 1}, false)
+    if len(found) != 1 || found[0].Id != 1 || len(missing) != 1 || missing[0] != draft.Id {
+        t.Errorf("batch without drafts found %v, missing %v", found, missing)
+    }
+    if found, missing := store.getMany([]int32{draft.Id, 1}, true); len(found) != 2 || len(missing) != 0 {
+        t.Errorf("batch with drafts found %v, missing %v", found, missing)
+    }
+}
+
+func TestValidateProduct(t *testing.T) {
+    valid := func() *catalog.Product {
+        return &catalog.Product{
+            Name:      "Tee",
+            Sku:       "TEE-001",
+            ImageUrls: []string{"https://cdn.example.com/tee.jpg"},
+            Attributes: map[string]*catalog.AttributeValue{
+                "color":     {Value: &catalog.AttributeValue_StringValue{StringValue: "red"}},
+                "weight_kg": {Value: &catalog.AttributeValue_NumberValue{NumberValue: 0.2}},
+            },
+        }
+    }
+    if err := validateProduct(valid()); err != nil {
+        t.Fatal(err)
+    }
+    for name, change := range map[string]func(p *catalog.Product){
+        "bad SKU":         func(p *catalog.Product) { p.Sku = "has space" },
+        "relative image":  func(p *catalog.Product) { p.ImageUrls = []string{"/tee.jpg"} },
+        "bad attribute":   func(p *catalog.Product) { p.Attributes["Colour!"] = p.Attributes["color"] },
+        "empty attribute": func(p *catalog.Product) { p.Attributes["size"] = &catalog.AttributeValue{} },
+        "unknown status":  func(p *catalog.Product) { p.Status = 7 },
+    } {
+        p := valid()
+        change(p)
+        if err := validateProduct(p); err == nil {
+            t.Errorf("%s accepted", name)
+        }
+    }
+}
+
+func TestStoreLegacyPrice(t *testing.T) {
+    store := newProductStore(&catalog.Product{Id: 1, Name: "Product 1", Price: 19.99})
+    p, _ := store.get(1)
+    if p.BasePrice.Format() != "19.99 USD" {
+        t.Fatalf("float price stored as %s", p.BasePrice.Format())
+    }
+
+    // Clients that know base_price move the float price along with it.
+    p.BasePrice = catalog.NewMoney("EUR", 1750)
+    p, err := store.update(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.Price != 17.5 {
+        t.Errorf("float price is %v after setting 17.50 EUR", p.Price)
+    }
+
+    // An old client sends back the base price it did not understand with
+    // the float price it changed, which keeps the currency.
+    p.Price = 15
+    p, err = store.update(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.BasePrice.Format() != "15.00 EUR" {
+        t.Errorf("old client's price stored as %s", p.BasePrice.Format())
+    }
+
+    // JSON clients that know base_price may leave the float price out.
+    p.Price = 0
+    p, err = store.update(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if p.BasePrice.Format() != "15.00 EUR" || p.Price != 15 {
+        t.Errorf("update without a float price stored %s and %v", p.BasePrice.Format(), p.Price)
+    }
+
+    // Variants are priced in the product's currency.
+    p.Options = []*catalog.VariantOption{{Name: "size", Values: []string{"S"}}}
+    p, _ = store.update(p)
+    v := &catalog.Variant{Sku: "P-1-S", Options: map[string]string{"size": "S"}, BasePrice: catalog.NewMoney("USD", 100)}
+    if _, err := store.createVariant(1, p.Version, v); !errors.Is(err, errVariantCurrency) {
+        t.Errorf("variant in another currency: %v", err)
+    }
+    v.BasePrice, v.Price = nil, 12
+    p, err = store.createVariant(1, p.Version, v)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := p.Variants[0].BasePrice.Format(); got != "12.00 EUR" {
+        t.Errorf("variant float price stored as %s", got)
+    }
+}
+
+func TestStoreDropsOutputPrices(t *testing.T) {
+    store := newProductStore()
+    forged := timestamppb.Now()
+    p, err := store.create(&catalog.Product{Name: "Product", Price: 10, SalePrice: catalog.NewMoney("USD", 100),
+        SaleEndTime: forged, NextSaleStartTime: forged, SaleUpdatedAt: forged})
+    if err != nil {
+        t.Fatal(err)
+    }
+    p.NextSaleStartTime, p.SaleUpdatedAt = forged, forged
+    if p, err = store.update(p); err != nil {
+        t.Fatal(err)
+    }
+    if p.SalePrice != nil || p.SaleEndTime != nil || p.NextSaleStartTime != nil || p.SaleUpdatedAt != nil {
+        t.Errorf("stored sale %v ending %v, changed %v, next starting %v", p.SalePrice, p.SaleEndTime, p.SaleUpdatedAt, p.NextSaleStartTime)
+    }
+}
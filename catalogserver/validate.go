@@ -0,0 +1,113 @@
+package main
+
+import (
+    "net/url"
+    "regexp"
+    "unicode/utf8"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+// Limits on the product fields clients can set.
+const (
+    maxNameLength        = 200
+    maxDescriptionLength = 10000
+    maxImages            = 20
+    maxAttributes        = 50
+    maxAttributeLength   = 1000
+    maxPriceListEntries  = 100
+)
+
+var (
+    skuPattern       = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+    attributePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+)
+
+// validateProduct checks the fields of a product a client wants to store.
+// Fields the server manages, such as the version, are not looked at.
+func validateProduct(p *catalog.Product) error {
+    if p == nil || p.Name == "" {
+        return status.Error(codes.InvalidArgument, "product name is required")
+    }
+    if utf8.RuneCountInString(p.Name) > maxNameLength || utf8.RuneCountInString(p.Brand) > maxNameLength {
+        return status.Errorf(codes.InvalidArgument, "name and brand must be at most %d characters", maxNameLength)
+    }
+    if err := validatePrice(p.BasePrice, p.Price); err != nil {
+        return err
+    }
+    if len(p.PriceList) > maxPriceListEntries {
+        return status.Errorf(codes.InvalidArgument, "at most %d price list entries are allowed", maxPriceListEntries)
+    }
+    listed := map[string]bool{}
+    for _, entry := range p.PriceList {
+        if entry.Price == nil {
+            return status.Error(codes.InvalidArgument, "price list entries must have a price")
+        }
+        if err := validatePrice(entry.Price, 0); err != nil {
+            return err
+        }
+        if entry.Region != "" && !regionPattern.MatchString(entry.Region) {
+            return status.Errorf(codes.InvalidArgument, "region %q is not an ISO 3166-1 alpha-2 code", entry.Region)
+        }
+        key := entry.Price.CurrencyCode + "/" + entry.Region
+        if listed[key] {
+            return status.Errorf(codes.InvalidArgument, "more than one price list entry for %s in region %q", entry.Price.CurrencyCode, entry.Region)
+        }
+        listed[key] = true
+    }
+    if p.Sku != "" && !skuPattern.MatchString(p.Sku) {
+        return status.Error(codes.InvalidArgument, "SKU must be up to 64 letters, digits, dots, dashes or underscores")
+    }
+    if utf8.RuneCountInString(p.Description) > maxDescriptionLength {
+        return status.Errorf(codes.InvalidArgument, "description must be at most %d characters", maxDescriptionLength)
+    }
+
+    if len(p.ImageUrls) > maxImages {
+        return status.Errorf(codes.InvalidArgument, "at most %d images are allowed", maxImages)
+    }
+    for _, raw := range p.ImageUrls {
+        u, err := url.Parse(raw)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return status.Errorf(codes.InvalidArgument, "image URL %q must be an absolute http or https URL", raw)
+        }
+    }
+
+    if len(p.Attributes) > maxAttributes {
+        return status.Errorf(codes.InvalidArgument, "at most %d attributes are allowed", maxAttributes)
+    }
+    for name, value := range p.Attributes {
+        if !attributePattern.MatchString(name) {
+            return status.Errorf(codes.InvalidArgument, "attribute name %q must be lower case letters, digits and underscores", name)
+        }
+        switch v := value.GetValue().(type) {
+        case nil:
+            return status.Errorf(codes.InvalidArgument, "attribute %q has no value", name)
+        case *catalog.AttributeValue_StringValue:
+            if utf8.RuneCountInString(v.StringValue) > maxAttributeLength {
+                return status.Errorf(codes.InvalidArgument, "attribute %q must be at most %d characters", name, maxAttributeLength)
+            }
+        }
+    }
+
+    names := map[string]bool{}
+    for _, option := range p.Options {
+        if option.Name == "" || names[option.Name] {
+            return status.Error(codes.InvalidArgument, "options must have distinct, non-empty names")
+        }
+        names[option.Name] = true
+        values := map[string]bool{}
+        for _, value := range option.Values {
+            if value == "" || values[value] {
+                return status.Errorf(codes.InvalidArgument, "option %q must have distinct, non-empty values", option.Name)
+            }
+            values[value] = true
+        }
+    }
+
+    if _, ok := catalog.ProductStatus_name[int32(p.Status)]; !ok {
+        return status.Errorf(codes.InvalidArgument, "unknown product status %d", p.Status)
+    }
+    return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+    errVariantNotFound = errors.New("variant not found")
+    // errVariantsMismatch means an UpdateProduct changed the options in a
+    // way the existing variants no longer fit.
+    errVariantsMismatch = errors.New("the product's variants do not match its options")
+)
+
+// variantOptionsError explains why a variant does not fit its product.
+type variantOptionsError struct {
+    msg string
+}
+
+func (e *variantOptionsError) Error() string {
+    return e.msg
+}
+
+// checkVariants makes sure every variant of p has exactly one valid value
+// for each of p's options, and that no two variants are the same
+// combination.
+func checkVariants(p *catalog.Product) error {
+    axes := map[string]map[string]bool{}
+    for _, option := range p.Options {
+        axes[option.Name] = map[string]bool{}
+        for _, value := range option.Values {
+            axes[option.Name][value] = true
+        }
+    }
+    seen := map[string]bool{}
+    for _, v := range p.Variants {
+        if len(v.Options) != len(axes) {
+            return &variantOptionsError{fmt.Sprintf("variant %s must have a value for each of the product's %d options", v.Sku, len(axes))}
+        }
+        var key []string
+        for name, value := range v.Options {
+            values, ok := axes[name]
+            if !ok {
+                return &variantOptionsError{fmt.Sprintf("variant %s has unknown option %q", v.Sku, name)}
+            }
+            if !values[value] {
+                return &variantOptionsError{fmt.Sprintf("variant %s has %q for %s, which is not one of its values", v.Sku, value, name)}
+            }
+            key = append(key, name+"="+value)
+        }
+        sort.Strings(key)
+        combination := strings.Join(key, ",")
+        if seen[combination] {
+            return &variantOptionsError{fmt.Sprintf("more than one variant has options %s", combination)}
+        }
+        seen[combination] = true
+    }
+    return nil
+}
+
+// changeVariants applies change to the variants of the product with id,
+// provided it is still at version, and moves the product to its next
+// version.
+func (s *productStore) changeVariants(id int32, version int64, change func(p *catalog.Product) error) (*catalog.Product, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    current, ok := s.products[id]
+    if !ok {
+        return nil, errProductNotFound
+    }
+    if current.Version != version {
+        return nil, errVersionMismatch
+    }
+    p := proto.Clone(current).(*catalog.Product)
+    if err := change(p); err != nil {
+        return nil, err
+    }
+    if err := checkVariants(p); err != nil {
+        return nil, err
+    }
+    if err := normalizePrices(p, current); err != nil {
+        return nil, err
+    }
+    if err := s.checkSKU(p); err != nil {
+        return nil, err
+    }
+    p.Version++
+    p.UpdatedAt = timestamppb.Now()
+    s.products[id] = p
+    s.feed.publish(catalog.ProductEvent_UPDATED, proto.Clone(p).(*catalog.Product))
+    return proto.Clone(p).(*catalog.Product), nil
+}
+
+func (s *productStore) createVariant(productID int32, version int64, v *catalog.Variant) (*catalog.Product, error) {
+    return s.changeVariants(productID, version, func(p *catalog.Product) error {
+        v = proto.Clone(v).(*catalog.Variant)
+        v.Id = s.nextVariantID
+        s.nextVariantID++
+        p.Variants = append(p.Variants, v)
+        return nil
+    })
+}
+
+func (s *productStore) updateVariant(productID int32, version int64, v *catalog.Variant) (*catalog.Product, error) {
+    return s.changeVariants(productID, version, func(p *catalog.Product) error {
+        for i, existing := range p.Variants {
+            if existing.Id == v.Id {
+                p.Variants[i] = proto.Clone(v).(*catalog.Variant)
+                return nil
+            }
+        }
+        return errVariantNotFound
+    })
+}
+
+func (s *productStore) deleteVariant(productID int32, version int64, variantID int32) (*catalog.Product, error) {
+    return s.changeVariants(productID, version, func(p *catalog.Product) error {
+        for i, existing := range p.Variants {
+            if existing.Id == variantID {
+                p.Variants = append(p.Variants[:i], p.Variants[i+1:]...)
+                return nil
+            }
+        }
+        return errVariantNotFound
+    })
+}
+
+// variantError converts an error from a variant change to a status.
+func variantError(err error, productID int32, version int64) error {
+    var options *variantOptionsError
+    switch {
+    case errors.Is(err, errVariantNotFound):
+        return status.Errorf(codes.NotFound, "product %d has no such variant", productID)
+    case errors.As(err, &options), errors.Is(err, errVariantCurrency):
+        return status.Error(codes.InvalidArgument, err.Error())
+    }
+    return storeError(err, productID, version)
+}
+
+func validateVariant(v *catalog.Variant) error {
+    if v == nil || !skuPattern.MatchString(v.Sku) {
+        return status.Error(codes.InvalidArgument, "variant SKU must be up to 64 letters, digits, dots, dashes or underscores")
+    }
+    if v.Stock < 0 {
+        return status.Error(codes.InvalidArgument, "variant stock must not be negative")
+    }
+    return validatePrice(v.BasePrice, v.Price)
+}
+
+func (s *server) CreateVariant(ctx context.Context, req *catalog.CreateVariantRequest) (*catalog.Product, error) {
+    if req.ProductVersion == 0 {
+        return nil, status.Error(codes.InvalidArgument, "product_version is required")
+    }
+    if err := validateVariant(req.Variant); err != nil {
+        return nil, err
+    }
+    product, err := s.store.createVariant(req.ProductId, req.ProductVersion, req.Variant)
+    if err != nil {
+        return nil, variantError(err, req.ProductId, req.ProductVersion)
+    }
+    log.Printf("Added variant %s to product %d", req.Variant.Sku, req.ProductId)
+    return product, nil
+}
+
+func (s *server) UpdateVariant(ctx context.Context, req *catalog.UpdateVariantRequest) (*catalog.Product, error) {
+    if req.ProductVersion == 0 {
+        return nil, status.Error(codes.InvalidArgument, "product_version is required")
+    }
+    if err := validateVariant(req.Variant); err != nil {
+        return nil, err
+    }
+    product, err := s.store.updateVariant(req.ProductId, req.ProductVersion, req.Variant)
+    if err != nil {
+        return nil, variantError(err, req.ProductId, req.ProductVersion)
+    }
+    log.Printf("Updated variant %d of product %d", req.Variant.Id, req.ProductId)
+    return product, nil
+}
+
+func (s *server) DeleteVariant(ctx context.Context, req *catalog.DeleteVariantRequest) (*catalog.Product, error) {
+    if req.ProductVersion == 0 {
+        return nil, status.Error(codes.InvalidArgument, "product_version is required")
+    }
+    product, err := s.store.deleteVariant(req.ProductId, req.ProductVersion, req.VariantId)
+    if err != nil {
+        return nil, variantError(err, req.ProductId, req.ProductVersion)
+    }
+    log.Printf("Deleted variant %d of product %d", req.VariantId, req.ProductId)
+    return product, nil
+}
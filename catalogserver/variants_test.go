@@ -0,0 +1,63 @@
+package main
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/sys-apps-go/microservices/catalog"
+    "google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestVariants(t *testing.T) {
+    store := newProductStore()
+    tee, _ := store.create(&catalog.Product{
+        Name: "Tee",
+        Sku:  "TEE",
+        Options: []*catalog.VariantOption{
+            {Name: "size", Values: []string{"S", "M"}},
+            {Name: "color", Values: []string{"red", "blue"}},
+        },
+    })
+
+    small := &catalog.Variant{Sku: "TEE-S-RED", Options: map[string]string{"size": "S", "color": "red"}, Price: 10, Stock: 5}
+    tee, err := store.createVariant(tee.Id, tee.Version, small)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(tee.Variants) != 1 || tee.Variants[0].Id == 0 || tee.Version != 2 {
+        t.Fatalf("variant not added: %v", tee)
+    }
+
+    for name, v := range map[string]*catalog.Variant{
+        "same combination": {Sku: "TEE-S-RED-2", Options: map[string]string{"size": "S", "color": "red"}},
+        "unknown value":    {Sku: "TEE-XL", Options: map[string]string{"size": "XL", "color": "red"}},
+        "missing option":   {Sku: "TEE-M", Options: map[string]string{"size": "M"}},
+    } {
+        var options *variantOptionsError
+        if _, err := store.createVariant(tee.Id, tee.Version, v); !errors.As(err, &options) {
+            t.Errorf("%s: %v", name, err)
+        }
+    }
+    if _, err := store.createVariant(tee.Id, tee.Version, &catalog.Variant{Sku: "TEE", Options: map[string]string{"size": "M", "color": "red"}}); !errors.Is(err, errDuplicateSKU) {
+        t.Errorf("variant reusing the product SKU: %v", err)
+    }
+
+    // Dropping a value a variant uses would orphan it.
+    tee.Options = []*catalog.VariantOption{{Name: "size", Values: []string{"M"}}, {Name: "color", Values: []string{"red"}}}
+    if _, err := store.update(tee); !errors.Is(err, errVariantsMismatch) {
+        t.Errorf("options change that orphans a variant: %v", err)
+    }
+
+    masked, err := catalog.ApplyReadMask(tee, &fieldmaskpb.FieldMask{Paths: []string{"id", "name", "imageUrls"}})
+    if err != nil || masked.Name != "Tee" || masked.Variants != nil || masked.Sku != "" {
+        t.Errorf("read mask: %v, %v", masked, err)
+    }
+    if _, err := catalog.ApplyReadMask(tee, &fieldmaskpb.FieldMask{Paths: []string{"nope"}}); err == nil {
+        t.Error("unknown field in read mask accepted")
+    }
+
+    tee, err = store.deleteVariant(tee.Id, tee.Version, tee.Variants[0].Id)
+    if err != nil || len(tee.Variants) != 0 {
+        t.Errorf("delete variant: %v, %v", tee, err)
+    }
+}
@@ -0,0 +1,213 @@
+// Package certs loads the TLS certificates the services present to each
+// other and to clients. Certificates are read from a directory holding a CA
+// bundle (ca.crt) and a key pair per service (<service>.crt, <service>.key),
+// as written by the devcerts command.
+package certs
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/grpc/credentials/insecure"
+)
+
+// Config is the TLS setup of one service.
+type Config struct {
+    // Roots verifies the certificates of peers, both servers dialed and
+    // clients presenting one.
+    Roots *x509.CertPool
+    // ClientAuth is how much the service's listeners demand of client
+    // certificates. RequireAndVerifyClientCert turns on mutual TLS.
+    ClientAuth tls.ClientAuthType
+
+    pair *keyPair
+}
+
+// FromEnv loads the certificates of service from TLS_CERT_DIR. It returns
+// nil when TLS_CERT_DIR is unset, in which case the service runs plaintext.
+// TLS_CLIENT_AUTH is "none" (the default), "optional" or "require".
+func FromEnv(service string) (*Config, error) {
+    dir := os.Getenv("TLS_CERT_DIR")
+    if dir == "" {
+        log.Printf("TLS_CERT_DIR is not set, %s runs without TLS", service)
+        return nil, nil
+    }
+    clientAuth, err := ClientAuthFromEnv("TLS_CLIENT_AUTH")
+    if err != nil {
+        return nil, err
+    }
+    return Load(dir, service, clientAuth)
+}
+
+// ClientAuthFromEnv reads a client certificate mode from the environment
+// variable key: "none" (the default), "optional" or "require".
+func ClientAuthFromEnv(key string) (tls.ClientAuthType, error) {
+    switch mode := os.Getenv(key); mode {
+    case "", "none":
+        return tls.NoClientCert, nil
+    case "optional":
+        return tls.VerifyClientCertIfGiven, nil
+    case "require":
+        return tls.RequireAndVerifyClientCert, nil
+    default:
+        return 0, fmt.Errorf("unknown %s %q", key, mode)
+    }
+}
+
+// Load reads the CA bundle and the key pair of service from dir. The key
+// pair is watched and picked up again when it is replaced on disk; a new CA
+// bundle needs a restart.
+func Load(dir, service string, clientAuth tls.ClientAuthType) (*Config, error) {
+    pem, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+    if err != nil {
+        return nil, err
+    }
+    roots := x509.NewCertPool()
+    if !roots.AppendCertsFromPEM(pem) {
+        return nil, errors.New("no certificates in " + filepath.Join(dir, "ca.crt"))
+    }
+    pair := &keyPair{
+        certFile: filepath.Join(dir, service+".crt"),
+        keyFile:  filepath.Join(dir, service+".key"),
+    }
+    if _, err := pair.get(); err != nil {
+        return nil, err
+    }
+    return &Config{Roots: roots, ClientAuth: clientAuth, pair: pair}, nil
+}
+
+// WithClientAuth returns a copy of c with its listeners demanding auth of
+// clients instead, for a listener such as a metrics endpoint whose
+// clients are not other services. It returns nil when c is nil.
+func (c *Config) WithClientAuth(auth tls.ClientAuthType) *Config {
+    if c == nil {
+        return nil
+    }
+    copied := *c
+    copied.ClientAuth = auth
+    return &copied
+}
+
+// Server returns the configuration for the service's listeners.
+func (c *Config) Server() *tls.Config {
+    return &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+            return c.pair.get()
+        },
+        ClientCAs:  c.Roots,
+        ClientAuth: c.ClientAuth,
+    }
+}
+
+// Client returns the configuration for connections the service opens. The
+// service's own certificate is offered to servers that ask for one.
+func (c *Config) Client() *tls.Config {
+    return &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        RootCAs:    c.Roots,
+        GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+            return c.pair.get()
+        },
+    }
+}
+
+// ServerCredentials returns the gRPC server option for c, or none when c is
+// nil.
+func ServerCredentials(c *Config) []grpc.ServerOption {
+    if c == nil {
+        return nil
+    }
+    return []grpc.ServerOption{grpc.Creds(credentials.NewTLS(c.Server()))}
+}
+
+// DialCredentials returns the gRPC transport credentials for c, plaintext
+// when c is nil.
+func DialCredentials(c *Config) grpc.DialOption {
+    if c == nil {
+        return grpc.WithTransportCredentials(insecure.NewCredentials())
+    }
+    return grpc.WithTransportCredentials(credentials.NewTLS(c.Client()))
+}
+
+// HTTPClient returns a client for calling the other services, trusting
+// the CA of c.
+func HTTPClient(c *Config) *http.Client {
+    client := &http.Client{Timeout: 10 * time.Second}
+    if c != nil {
+        transport := http.DefaultTransport.(*http.Transport).Clone()
+        transport.TLSClientConfig = c.Client()
+        client.Transport = transport
+    }
+    return client
+}
+
+// ListenAndServe serves handler on addr, over TLS unless c is nil.
+func ListenAndServe(addr string, handler http.Handler, c *Config) error {
+    if c == nil {
+        return http.ListenAndServe(addr, handler)
+    }
+    lis, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+    srv := &http.Server{Handler: handler, TLSConfig: c.Server()}
+    return srv.ServeTLS(lis, "", "")
+}
+
+// keyPair is a certificate and key on disk, loaded again whenever either
+// file changes. Files are only stat'ed per handshake, which is cheap next
+// to the handshake itself.
+type keyPair struct {
+    certFile, keyFile string
+
+    mu       sync.Mutex
+    cert     *tls.Certificate
+    certStat os.FileInfo
+    keyStat  os.FileInfo
+}
+
+func (p *keyPair) get() (*tls.Certificate, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+
+    certStat, err1 := os.Stat(p.certFile)
+    keyStat, err2 := os.Stat(p.keyFile)
+    if err := errors.Join(err1, err2); err != nil {
+        if p.cert != nil {
+            return p.cert, nil
+        }
+        return nil, err
+    }
+    if p.cert != nil && sameFile(certStat, p.certStat) && sameFile(keyStat, p.keyStat) {
+        return p.cert, nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+    if err != nil {
+        // Most likely caught halfway through a rotation, with only one of
+        // the files replaced yet. Keep serving the old pair meanwhile.
+        if p.cert != nil {
+            log.Printf("Failed to reload %s: %v", p.certFile, err)
+            return p.cert, nil
+        }
+        return nil, err
+    }
+    p.cert, p.certStat, p.keyStat = &cert, certStat, keyStat
+    return p.cert, nil
+}
+
+func sameFile(a, b os.FileInfo) bool {
+    return b != nil && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size() && os.SameFile(a, b)
+}
@@ -0,0 +1,133 @@
+package certs
+
+import (
+    "crypto/tls"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// newTestServer serves over TLS with the catalogserver certificate from dir,
+// demanding client certificates, and returns its URL with a config for the
+// apiserver side.
+func newTestServer(t *testing.T, dir string) (string, *Config) {
+    t.Helper()
+    if err := GenerateDev(dir, "catalogserver", "apiserver"); err != nil {
+        t.Fatal(err)
+    }
+    server, err := Load(dir, "catalogserver", tls.RequireAndVerifyClientCert)
+    if err != nil {
+        t.Fatal(err)
+    }
+    client, err := Load(dir, "apiserver", tls.NoClientCert)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+    }))
+    // Not StartTLS, which would put its own certificate in front of ours
+    srv.Listener = tls.NewListener(srv.Listener, server.Server())
+    srv.Start()
+    t.Cleanup(srv.Close)
+    return "https://" + srv.Listener.Addr().String(), client
+}
+
+func TestMutualTLS(t *testing.T) {
+    url, client := newTestServer(t, t.TempDir())
+
+    resp, err := HTTPClient(client).Get(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "apiserver" {
+        t.Errorf("server saw client %q, want apiserver", body)
+    }
+
+    // Trusting the CA is not enough without a certificate of our own
+    noCert := HTTPClient(client)
+    noCert.Transport.(*http.Transport).TLSClientConfig.GetClientCertificate = nil
+    if _, err := noCert.Get(url); err == nil {
+        t.Error("connection without a client certificate accepted")
+    }
+
+    // Nor is a certificate from another CA
+    other, err := Load(func() string {
+        dir := t.TempDir()
+        if err := GenerateDev(dir, "apiserver"); err != nil {
+            t.Fatal(err)
+        }
+        return dir
+    }(), "apiserver", tls.NoClientCert)
+    if err != nil {
+        t.Fatal(err)
+    }
+    otherClient := HTTPClient(other)
+    otherClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = client.Roots
+    if _, err := otherClient.Get(url); err == nil {
+        t.Error("client certificate from an unknown CA accepted")
+    }
+}
+
+func TestCertificateReload(t *testing.T) {
+    dir := t.TempDir()
+    url, client := newTestServer(t, dir)
+
+    serial := func() string {
+        t.Helper()
+        // A fresh transport forces a new handshake
+        resp, err := HTTPClient(client).Get(url)
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        return resp.TLS.PeerCertificates[0].SerialNumber.String()
+    }
+
+    before := serial()
+    if again := serial(); again != before {
+        t.Fatalf("certificate changed without a rotation")
+    }
+    if err := GenerateDev(dir, "catalogserver"); err != nil {
+        t.Fatal(err)
+    }
+    if after := serial(); after == before {
+        t.Error("rotated certificate not picked up")
+    }
+}
+
+func TestWithClientAuth(t *testing.T) {
+    dir := t.TempDir()
+    if err := GenerateDev(dir, "catalogserver", "apiserver"); err != nil {
+        t.Fatal(err)
+    }
+    server, err := Load(dir, "catalogserver", tls.RequireAndVerifyClientCert)
+    if err != nil {
+        t.Fatal(err)
+    }
+    metrics := server.WithClientAuth(tls.NoClientCert)
+    if server.ClientAuth != tls.RequireAndVerifyClientCert {
+        t.Error("WithClientAuth changed the original config")
+    }
+
+    srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    srv.Listener = tls.NewListener(srv.Listener, metrics.Server())
+    srv.Start()
+    defer srv.Close()
+
+    // A scraper trusting the CA but without a certificate gets in
+    client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: server.Roots}}}
+    resp, err := client.Get("https://" + srv.Listener.Addr().String())
+    if err != nil {
+        t.Fatalf("connection without a client certificate: %v", err)
+    }
+    resp.Body.Close()
+
+    if (*Config)(nil).WithClientAuth(tls.NoClientCert) != nil {
+        t.Error("WithClientAuth of a plaintext config is not nil")
+    }
+}
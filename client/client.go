@@ -8,13 +8,18 @@ import (
     "io/ioutil"
     "log"
     "net/http"
+    "os"
     "time"
 
-    "github.com/goperfapps/microservices/catalog"
+    "github.com/sys-apps-go/microservices/authz"
+    "github.com/sys-apps-go/microservices/catalog"
+    "github.com/sys-apps-go/microservices/certs"
     "google.golang.org/grpc"
-    //"google.golang.org/grpc/credentials/insecure"
 )
 
+// httpClient trusts the internal CA once TLS is configured
+var httpClient = http.DefaultClient
+
 type SignupRequest struct {
     FirstName string `json:"firstName"`
     LastName  string `json:"lastName"`
@@ -38,7 +43,7 @@ func makeSignupRequest(url string, req SignupRequest) (*AuthResponse, error) {
         return nil, err
     }
 
-    resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqJson))
+    resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(reqJson))
     if err != nil {
         return nil, err
     }
@@ -68,7 +73,7 @@ func makeLoginRequest(url string, req LoginRequest) (*AuthResponse, error) {
         return nil, err
     }
 
-    resp, err := http.Post(url, "application/json", bytes.NewBuffer(reqJson))
+    resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(reqJson))
     if err != nil {
         return nil, err
     }
@@ -92,6 +97,20 @@ func makeLoginRequest(url string, req LoginRequest) (*AuthResponse, error) {
     return &authRes, nil
 }
 
+// apiKeyCredentials sends an API key with every RPC, so batch jobs don't
+// need a user's password.
+type apiKeyCredentials string
+
+func (k apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+    return map[string]string{"authorization": "Bearer " + string(k)}, nil
+}
+
+// RequireTransportSecurity is false so keys can still be used against a
+// plaintext development setup.
+func (k apiKeyCredentials) RequireTransportSecurity() bool {
+    return false
+}
+
 func getProductByID(client catalog.CatalogServiceClient, productID int32) (*catalog.Product, error) {
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
@@ -106,6 +125,12 @@ func getProductByID(client catalog.CatalogServiceClient, productID int32) (*cata
 }
 
 func main() {
+    tlsConfig, err := certs.FromEnv("client")
+    if err != nil {
+        log.Fatalf("Failed to load TLS certificates: %v", err)
+    }
+    httpClient = certs.HTTPClient(tlsConfig)
+
     // Sign up request
     signupReq := SignupRequest{
         FirstName: "John",
@@ -113,7 +138,7 @@ func main() {
         Email:     "john.doe@example.com",
         Password:  "password123",
     }
-    signupURL := "http://localhost:50053/signup"
+    signupURL := authz.AuthURL() + "/signup"
     signupRes, err := makeSignupRequest(signupURL, signupReq)
     if err != nil {
         log.Fatalf("Signup request failed: %v", err)
@@ -125,7 +150,7 @@ func main() {
         Email:    "john.doe@example.com",
         Password: "password123",
     }
-    loginURL := "http://localhost:50053/login"
+    loginURL := authz.AuthURL() + "/login"
     loginRes, err := makeLoginRequest(loginURL, loginReq)
     if err != nil {
         log.Fatalf("Login request failed: %v", err)
@@ -133,7 +158,11 @@ func main() {
     fmt.Printf("Login response: %+v\n", loginRes)
 
     // Connect to catalog server
-    conn, err := grpc.Dial("localhost:50052", grpc.WithInsecure())
+    opts := []grpc.DialOption{certs.DialCredentials(tlsConfig)}
+    if key := os.Getenv("API_KEY"); key != "" {
+        opts = append(opts, grpc.WithPerRPCCredentials(apiKeyCredentials(key)))
+    }
+    conn, err := grpc.Dial("localhost:50052", opts...)
     if err != nil {
         log.Fatalf("Failed to connect to catalog server: %v", err)
     }
@@ -148,4 +177,3 @@ func main() {
     }
     fmt.Printf("Product: %+v\n", product)
 }
-
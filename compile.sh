@@ -1 +1 @@
-protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative catalog/catalog.proto
+go generate ./catalog ./auth
@@ -0,0 +1,23 @@
+// Command devcerts creates a local CA and certificates for the services, for
+// development and tests only. Run it again to rotate the service
+// certificates; running services pick the new ones up without a restart.
+package main
+
+import (
+    "flag"
+    "log"
+    "strings"
+
+    "github.com/sys-apps-go/microservices/certs"
+)
+
+func main() {
+    dir := flag.String("dir", "dev-certs", "directory to write the CA and certificates to")
+    services := flag.String("services", "authserver,apiserver,catalogserver,client", "comma separated services to issue certificates for")
+    flag.Parse()
+
+    if err := certs.GenerateDev(*dir, strings.Split(*services, ",")...); err != nil {
+        log.Fatalf("Failed to generate certificates: %v", err)
+    }
+    log.Printf("Wrote certificates to %s", *dir)
+}
@@ -0,0 +1,113 @@
+// Package jwks signs tokens with a rotating set of asymmetric keys and
+// verifies them against the set published as a JSON Web Key Set.
+package jwks
+
+import (
+    "crypto"
+    "crypto/ed25519"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "math/big"
+    "os"
+)
+
+// Supported signing algorithms.
+const (
+    RS256 = "RS256"
+    EdDSA = "EdDSA"
+)
+
+// JWK is a public key in JSON Web Key format (RFC 7517). RSA keys use N and
+// E, Ed25519 keys (RFC 8037) use Crv and X.
+type JWK struct {
+    Kty string `json:"kty"`
+    Use string `json:"use,omitempty"`
+    Alg string `json:"alg"`
+    Kid string `json:"kid"`
+    N   string `json:"n,omitempty"`
+    E   string `json:"e,omitempty"`
+    Crv string `json:"crv,omitempty"`
+    X   string `json:"x,omitempty"`
+}
+
+// Set is a JSON Web Key Set.
+type Set struct {
+    Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewJWK describes the public half of priv. The key ID is the RFC 7638
+// thumbprint, so the same key always gets the same ID.
+func NewJWK(priv crypto.Signer) (JWK, error) {
+    switch pub := priv.Public().(type) {
+    case *rsa.PublicKey:
+        n := b64.EncodeToString(pub.N.Bytes())
+        e := b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+        thumb := sha256.Sum256([]byte(`{"e":"` + e + `","kty":"RSA","n":"` + n + `"}`))
+        return JWK{Kty: "RSA", Use: "sig", Alg: RS256, Kid: b64.EncodeToString(thumb[:]), N: n, E: e}, nil
+    case ed25519.PublicKey:
+        x := b64.EncodeToString(pub)
+        thumb := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+        return JWK{Kty: "OKP", Use: "sig", Alg: EdDSA, Kid: b64.EncodeToString(thumb[:]), Crv: "Ed25519", X: x}, nil
+    }
+    return JWK{}, fmt.Errorf("unsupported key type %T", priv)
+}
+
+// PublicKey decodes k into an *rsa.PublicKey or ed25519.PublicKey.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+    switch {
+    case k.Kty == "RSA" && k.Alg == RS256:
+        n, err := b64.DecodeString(k.N)
+        if err != nil {
+            return nil, err
+        }
+        e, err := b64.DecodeString(k.E)
+        if err != nil {
+            return nil, err
+        }
+        return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+    case k.Kty == "OKP" && k.Crv == "Ed25519" && k.Alg == EdDSA:
+        x, err := b64.DecodeString(k.X)
+        if err != nil {
+            return nil, err
+        }
+        if len(x) != ed25519.PublicKeySize {
+            return nil, errors.New("bad Ed25519 key size")
+        }
+        return ed25519.PublicKey(x), nil
+    }
+    return nil, fmt.Errorf("unsupported key %s/%s", k.Kty, k.Alg)
+}
+
+// ReadPrivateKey loads a PEM encoded RSA (PKCS #1 or #8) or Ed25519
+// (PKCS #8) private key from path.
+func ReadPrivateKey(path string) (crypto.Signer, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, fmt.Errorf("%s: no PEM data", path)
+    }
+    if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+        return key, nil
+    }
+    parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    switch key := parsed.(type) {
+    case *rsa.PrivateKey:
+        return key, nil
+    case ed25519.PrivateKey:
+        return key, nil
+    }
+    return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+}
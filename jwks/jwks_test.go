@@ -0,0 +1,168 @@
+package jwks
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+)
+
+func TestRotationWithRemoteVerifier(t *testing.T) {
+    for _, alg := range []string{RS256, EdDSA} {
+        t.Run(alg, func(t *testing.T) {
+            ks, err := NewKeySet(alg, time.Hour, nil)
+            if err != nil {
+                t.Fatal(err)
+            }
+            fetches := 0
+            srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                fetches++
+                json.NewEncoder(w).Encode(ks.Public())
+            }))
+            defer srv.Close()
+            v := NewVerifier(srv.URL)
+            v.MinRefetch = 0
+
+            verify := func(token string) error {
+                _, err := jwt.Parse(token, v.Keyfunc)
+                return err
+            }
+            claims := jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+            before, _ := ks.Sign(claims)
+            if err := verify(before); err != nil {
+                t.Fatalf("token from active key: %v", err)
+            }
+
+            // The next key was published with the first fetch, so tokens it
+            // signs after rotation verify from the cache
+            if err := ks.Rotate(); err != nil {
+                t.Fatal(err)
+            }
+            after, _ := ks.Sign(claims)
+            if err := verify(after); err != nil {
+                t.Fatalf("token from rotated key: %v", err)
+            }
+            if fetches != 1 {
+                t.Errorf("pre-published key caused %d fetches, want 1", fetches)
+            }
+
+            // Two rotations later the key is unknown to the cache and has to
+            // be fetched; the retired key stays valid within the overlap
+            ks.Rotate()
+            ks.Rotate()
+            latest, _ := ks.Sign(claims)
+            if err := verify(latest); err != nil {
+                t.Fatalf("token from unseen key: %v", err)
+            }
+            if err := verify(before); err != nil {
+                t.Errorf("token from retired key within overlap: %v", err)
+            }
+
+            // Tokens must not be accepted with a different alg for the key
+            forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+            forged.Header["kid"] = ks.Public().Keys[1].Kid
+            raw, _ := forged.SignedString([]byte("secret"))
+            if err := verify(raw); err == nil {
+                t.Errorf("HS256 token accepted")
+            }
+        })
+    }
+}
+
+func TestRetiredKeysExpire(t *testing.T) {
+    ks, err := NewKeySet(EdDSA, 0, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    old, _ := ks.Sign(jwt.StandardClaims{})
+    ks.Rotate()
+    if _, err := jwt.Parse(old, ks.Keyfunc); err != nil {
+        t.Fatalf("key retired by the last rotation: %v", err)
+    }
+    ks.Rotate()
+    if _, err := jwt.Parse(old, ks.Keyfunc); err == nil {
+        t.Errorf("key past its overlap window still accepted")
+    }
+    if n := len(ks.Public().Keys); n != 3 {
+        t.Errorf("published %d keys, want next, active and last retired", n)
+    }
+}
+
+func TestVerifierFetchesWithoutBlockingReaders(t *testing.T) {
+    ks, err := NewKeySet(EdDSA, time.Hour, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var fetches int32
+    release := make(chan struct{})
+    var block atomic.Bool
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&fetches, 1)
+        if block.Load() {
+            <-release
+        }
+        json.NewEncoder(w).Encode(ks.Public())
+    }))
+    defer srv.Close()
+    v := NewVerifier(srv.URL)
+
+    claims := jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()}
+    cached, _ := ks.Sign(claims)
+    if _, err := jwt.Parse(cached, v.Keyfunc); err != nil {
+        t.Fatal(err)
+    }
+
+    // With the set stale and the endpoint hanging, a cached key still
+    // verifies straight away.
+    block.Store(true)
+    v.mu.Lock()
+    v.fetchedAt = time.Now().Add(-2 * v.MaxAge)
+    v.mu.Unlock()
+    done := make(chan error, 1)
+    go func() {
+        _, err := jwt.Parse(cached, v.Keyfunc)
+        done <- err
+    }()
+    select {
+    case err := <-done:
+        if err != nil {
+            t.Fatalf("cached key during a refresh: %v", err)
+        }
+    case <-time.After(2 * time.Second):
+        t.Fatal("verifying with a cached key waited for the fetch")
+    }
+
+    // Tokens from a key the cache lacks wait for the fetch under way
+    // rather than starting their own.
+    ks.Rotate()
+    ks.Rotate()
+    unseen, _ := ks.Sign(claims)
+    var wg sync.WaitGroup
+    errs := make(chan error, 5)
+    for i := 0; i < 5; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            _, err := jwt.Parse(unseen, v.Keyfunc)
+            errs <- err
+        }()
+    }
+    time.Sleep(50 * time.Millisecond)
+    close(release)
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        if err != nil {
+            t.Errorf("token from unseen key: %v", err)
+        }
+    }
+    if n := atomic.LoadInt32(&fetches); n != 2 {
+        t.Errorf("%d fetches, want 2", n)
+    }
+}
@@ -0,0 +1,182 @@
+package jwks
+
+import (
+    "context"
+    "crypto"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "fmt"
+    "sync"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+)
+
+// Key is a signing key of a KeySet.
+type Key struct {
+    JWK
+    Private   crypto.Signer
+    CreatedAt time.Time
+    // RetiredAt is when the key stopped signing; zero while it still does.
+    RetiredAt time.Time
+}
+
+func (k *Key) method() jwt.SigningMethod {
+    if k.Alg == EdDSA {
+        return SigningMethodEdDSA
+    }
+    return jwt.SigningMethodRS256
+}
+
+// KeySet signs tokens with one active key at a time and rotates it.
+//
+// Rotation has an overlap window on both sides: the key that will be used
+// next is published ahead of time, so verifiers that cached the set already
+// know it when it starts signing, and a retired key stays published for as
+// long as tokens it signed may still be in use.
+type KeySet struct {
+    alg     string
+    overlap time.Duration
+
+    mu      sync.RWMutex
+    active  *Key
+    next    *Key
+    retired []*Key
+}
+
+// NewKeySet creates a key set signing with alg (RS256 or EdDSA). overlap
+// must be at least the lifetime of the longest-lived token signed. If seed
+// is not nil it becomes the first active key, otherwise one is generated.
+func NewKeySet(alg string, overlap time.Duration, seed crypto.Signer) (*KeySet, error) {
+    if alg != RS256 && alg != EdDSA {
+        return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+    }
+    ks := &KeySet{alg: alg, overlap: overlap}
+
+    var err error
+    if seed != nil {
+        ks.active, err = newKey(seed)
+        if err == nil && ks.active.Alg != alg {
+            err = fmt.Errorf("seed key is %s, want %s", ks.active.Alg, alg)
+        }
+    } else {
+        ks.active, err = ks.generate()
+    }
+    if err != nil {
+        return nil, err
+    }
+    if ks.next, err = ks.generate(); err != nil {
+        return nil, err
+    }
+    return ks, nil
+}
+
+func newKey(priv crypto.Signer) (*Key, error) {
+    jwk, err := NewJWK(priv)
+    if err != nil {
+        return nil, err
+    }
+    return &Key{JWK: jwk, Private: priv, CreatedAt: time.Now()}, nil
+}
+
+func (ks *KeySet) generate() (*Key, error) {
+    var priv crypto.Signer
+    var err error
+    switch ks.alg {
+    case RS256:
+        priv, err = rsa.GenerateKey(rand.Reader, 2048)
+    case EdDSA:
+        _, priv, err = ed25519.GenerateKey(rand.Reader)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return newKey(priv)
+}
+
+// Alg returns the algorithm tokens are signed with.
+func (ks *KeySet) Alg() string {
+    return ks.alg
+}
+
+// Rotate retires the active key in favour of the pre-published next one
+// and drops retired keys whose overlap window has passed.
+func (ks *KeySet) Rotate() error {
+    next, err := ks.generate()
+    if err != nil {
+        return err
+    }
+
+    ks.mu.Lock()
+    defer ks.mu.Unlock()
+    now := time.Now()
+    ks.active.RetiredAt = now
+    kept := []*Key{ks.active}
+    for _, k := range ks.retired {
+        if now.Sub(k.RetiredAt) < ks.overlap {
+            kept = append(kept, k)
+        }
+    }
+    ks.retired = kept
+    ks.active, ks.next = ks.next, next
+    return nil
+}
+
+// RotateEvery rotates the keys every interval until ctx is done. Rotation
+// errors are passed to onError and retried at the next tick.
+func (ks *KeySet) RotateEvery(ctx context.Context, interval time.Duration, onError func(error)) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := ks.Rotate(); err != nil && onError != nil {
+                onError(err)
+            }
+        }
+    }
+}
+
+// Sign returns claims as a token signed by the active key.
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+    ks.mu.RLock()
+    key := ks.active
+    ks.mu.RUnlock()
+
+    token := jwt.NewWithClaims(key.method(), claims)
+    token.Header["kid"] = key.Kid
+    return token.SignedString(key.Private)
+}
+
+// Public returns every published key: the next, active and still
+// overlapping retired ones.
+func (ks *KeySet) Public() Set {
+    ks.mu.RLock()
+    defer ks.mu.RUnlock()
+    set := Set{Keys: []JWK{ks.next.JWK, ks.active.JWK}}
+    for _, k := range ks.retired {
+        set.Keys = append(set.Keys, k.JWK)
+    }
+    return set
+}
+
+// Keyfunc resolves the public key of a token signed by this set, for use
+// with jwt.Parse. The next key is not accepted until it becomes active.
+func (ks *KeySet) Keyfunc(t *jwt.Token) (interface{}, error) {
+    kid, _ := t.Header["kid"].(string)
+    ks.mu.RLock()
+    defer ks.mu.RUnlock()
+    candidates := append([]*Key{ks.active}, ks.retired...)
+    for _, k := range candidates {
+        if k.Kid == kid {
+            if t.Method.Alg() != k.Alg {
+                return nil, fmt.Errorf("token alg %s does not match key %s", t.Method.Alg(), k.Alg)
+            }
+            return k.Private.Public(), nil
+        }
+    }
+    return nil, fmt.Errorf("unknown key %q", kid)
+}
@@ -0,0 +1,147 @@
+package jwks
+
+import (
+    "crypto"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/dgrijalva/jwt-go"
+)
+
+// Verifier resolves token signing keys from a remote JWKS endpoint. Keys
+// are cached; the set is fetched again once it is older than MaxAge, or
+// straight away when a token names a key that is not cached, which is how a
+// rotation the cache has not seen yet gets picked up. Fetches run without
+// holding the cache, one at a time: tokens signed by a cached key are
+// verified with it while a stale set is refreshed, and only tokens waiting
+// on an unknown key wait for the fetch.
+type Verifier struct {
+    URL    string
+    Client *http.Client
+    // MaxAge is how long a fetched set is trusted. Defaults to 10 minutes.
+    MaxAge time.Duration
+    // MinRefetch limits how often unknown key IDs trigger a fetch, so
+    // tokens with made-up key IDs cannot hammer the endpoint. Defaults to
+    // 10 seconds.
+    MinRefetch time.Duration
+
+    mu        sync.Mutex
+    keys      map[string]cachedKey
+    fetchedAt time.Time
+    fetchErr  error
+    // fetching is closed when the fetch under way, if any, is done
+    fetching chan struct{}
+}
+
+type cachedKey struct {
+    alg string
+    pub crypto.PublicKey
+}
+
+// NewVerifier returns a Verifier for the key set published at url.
+func NewVerifier(url string) *Verifier {
+    return &Verifier{
+        URL:        url,
+        Client:     &http.Client{Timeout: 5 * time.Second},
+        MaxAge:     10 * time.Minute,
+        MinRefetch: 10 * time.Second,
+    }
+}
+
+// Keyfunc resolves the public key of t, for use with jwt.Parse.
+func (v *Verifier) Keyfunc(t *jwt.Token) (interface{}, error) {
+    kid, _ := t.Header["kid"].(string)
+    if kid == "" {
+        return nil, fmt.Errorf("token has no key ID")
+    }
+
+    v.mu.Lock()
+    key, ok := v.keys[kid]
+    stale := time.Since(v.fetchedAt) > v.MaxAge
+    var wait <-chan struct{}
+    switch {
+    case stale && ok:
+        v.refresh()
+    case stale || (!ok && time.Since(v.fetchedAt) > v.MinRefetch):
+        wait = v.refresh()
+    case !ok:
+        // Join a fetch someone else started; it may bring the key
+        wait = v.fetching
+    }
+    v.mu.Unlock()
+
+    if wait != nil {
+        <-wait
+        v.mu.Lock()
+        key, ok = v.keys[kid]
+        err := v.fetchErr
+        noKeys := v.keys == nil
+        v.mu.Unlock()
+        if noKeys && err != nil {
+            return nil, err
+        }
+    }
+    if !ok {
+        return nil, fmt.Errorf("unknown key %q", kid)
+    }
+    if t.Method.Alg() != key.alg {
+        return nil, fmt.Errorf("token alg %s does not match key %s", t.Method.Alg(), key.alg)
+    }
+    return key.pub, nil
+}
+
+// refresh starts fetching the key set unless a fetch is already under way,
+// and returns a channel that is closed once it is done. On failure the
+// previous keys are kept. The caller must hold v.mu.
+func (v *Verifier) refresh() <-chan struct{} {
+    if v.fetching != nil {
+        return v.fetching
+    }
+    // Failed attempts count too, so an unreachable endpoint is not retried
+    // on every request.
+    v.fetchedAt = time.Now()
+    done := make(chan struct{})
+    v.fetching = done
+    go func() {
+        keys, err := v.fetch()
+        v.mu.Lock()
+        if err == nil {
+            v.keys = keys
+        }
+        v.fetchErr = err
+        v.fetching = nil
+        v.mu.Unlock()
+        close(done)
+    }()
+    return done
+}
+
+// fetch downloads and decodes the published set.
+func (v *Verifier) fetch() (map[string]cachedKey, error) {
+    resp, err := v.Client.Get(v.URL)
+    if err != nil {
+        return nil, fmt.Errorf("fetch %s: %w", v.URL, err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("fetch %s: %s", v.URL, resp.Status)
+    }
+    var set Set
+    if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+        return nil, fmt.Errorf("decode %s: %w", v.URL, err)
+    }
+
+    keys := make(map[string]cachedKey, len(set.Keys))
+    for _, k := range set.Keys {
+        pub, err := k.PublicKey()
+        if err != nil {
+            // Skip keys of types we cannot use rather than failing the set
+            continue
+        }
+        keys[k.Kid] = cachedKey{alg: k.Alg, pub: pub}
+    }
+    return keys, nil
+}
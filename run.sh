@@ -1,10 +1,12 @@
 ./compile.sh
-go run authserver/authserver.go &
+go run ./devcerts -dir dev-certs
+export TLS_CERT_DIR=dev-certs AUTH_URL=https://localhost:50053 AUTH_PUBLIC_URL=https://localhost:50053 PASSWORD_RESET_URL=https://localhost:50061/password/reset
+go run ./authserver &
 sleep 2
-go run catalogserver/catalogserver.go &
+TLS_CLIENT_AUTH=require go run ./catalogserver &
 sleep 2
-go run apiserver/apiserver.go &
+go run ./apiserver &
 sleep 2
-curl "http://localhost:50061/getProduct?id=1"
+curl --cacert dev-certs/ca.crt "https://localhost:50061/getProduct?id=1"
 echo "\n"
 go run client/client.go
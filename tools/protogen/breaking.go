@@ -0,0 +1,197 @@
+package main
+
+import (
+    "fmt"
+
+    "google.golang.org/protobuf/types/descriptorpb"
+)
+
+// BreakingChanges lists the changes between two revisions of a set of
+// proto files that would break generated code or existing clients on the
+// wire. Additions are always allowed, as is removing a field or enum value
+// whose number and name have been reserved.
+func BreakingChanges(previous, current []*descriptorpb.FileDescriptorProto) []string {
+    var problems []string
+    report := func(format string, args ...interface{}) {
+        problems = append(problems, fmt.Sprintf(format, args...))
+    }
+
+    files := map[string]*descriptorpb.FileDescriptorProto{}
+    for _, f := range current {
+        files[f.GetName()] = f
+    }
+    for _, old := range previous {
+        f, ok := files[old.GetName()]
+        if !ok {
+            report("%s: file removed", old.GetName())
+            continue
+        }
+        if old.GetPackage() != f.GetPackage() {
+            report("%s: package changed from %q to %q", f.GetName(), old.GetPackage(), f.GetPackage())
+        }
+        if old.GetOptions().GetGoPackage() != f.GetOptions().GetGoPackage() {
+            report("%s: go_package changed from %q to %q", f.GetName(), old.GetOptions().GetGoPackage(), f.GetOptions().GetGoPackage())
+        }
+        prefix := old.GetPackage()
+        compareMessages(report, prefix, old.MessageType, f.MessageType)
+        compareEnums(report, prefix, old.EnumType, f.EnumType)
+        compareServices(report, prefix, old.Service, f.Service)
+    }
+    return problems
+}
+
+type reporter func(format string, args ...interface{})
+
+func compareMessages(report reporter, prefix string, previous, current []*descriptorpb.DescriptorProto) {
+    messages := map[string]*descriptorpb.DescriptorProto{}
+    for _, m := range current {
+        messages[m.GetName()] = m
+    }
+    for _, old := range previous {
+        name := prefix + "." + old.GetName()
+        m, ok := messages[old.GetName()]
+        if !ok {
+            report("%s: message removed", name)
+            continue
+        }
+        compareFields(report, name, old, m)
+        compareMessages(report, name, old.NestedType, m.NestedType)
+        compareEnums(report, name, old.EnumType, m.EnumType)
+    }
+}
+
+func compareFields(report reporter, message string, previous, current *descriptorpb.DescriptorProto) {
+    fields := map[int32]*descriptorpb.FieldDescriptorProto{}
+    for _, f := range current.Field {
+        fields[f.GetNumber()] = f
+    }
+    for _, old := range previous.Field {
+        name := message + "." + old.GetName()
+        f, ok := fields[old.GetNumber()]
+        if !ok {
+            if !reservedNumber(current.ReservedRange, old.GetNumber()) || !reservedName(current.ReservedName, old.GetName()) {
+                report("%s: field %d removed without reserving its number and name", name, old.GetNumber())
+            }
+            continue
+        }
+        if old.GetName() != f.GetName() {
+            report("%s: field %d renamed to %s", name, old.GetNumber(), f.GetName())
+        }
+        if old.GetType() != f.GetType() || old.GetTypeName() != f.GetTypeName() {
+            report("%s: type changed from %s to %s", name, fieldType(old), fieldType(f))
+        }
+        if old.GetLabel() != f.GetLabel() {
+            report("%s: label changed from %s to %s", name, old.GetLabel(), f.GetLabel())
+        }
+        if oneofName(previous, old) != oneofName(current, f) {
+            report("%s: moved from oneof %q to %q", name, oneofName(previous, old), oneofName(current, f))
+        }
+    }
+}
+
+func compareEnums(report reporter, prefix string, previous, current []*descriptorpb.EnumDescriptorProto) {
+    enums := map[string]*descriptorpb.EnumDescriptorProto{}
+    for _, e := range current {
+        enums[e.GetName()] = e
+    }
+    for _, old := range previous {
+        name := prefix + "." + old.GetName()
+        e, ok := enums[old.GetName()]
+        if !ok {
+            report("%s: enum removed", name)
+            continue
+        }
+        values := map[int32]*descriptorpb.EnumValueDescriptorProto{}
+        for _, v := range e.Value {
+            values[v.GetNumber()] = v
+        }
+        for _, v := range old.Value {
+            current, ok := values[v.GetNumber()]
+            switch {
+            case !ok && !reservedEnumNumber(e.ReservedRange, v.GetNumber()):
+                report("%s.%s: enum value %d removed without reserving it", name, v.GetName(), v.GetNumber())
+            case ok && current.GetName() != v.GetName():
+                report("%s.%s: enum value %d renamed to %s", name, v.GetName(), v.GetNumber(), current.GetName())
+            }
+        }
+    }
+}
+
+func compareServices(report reporter, prefix string, previous, current []*descriptorpb.ServiceDescriptorProto) {
+    services := map[string]*descriptorpb.ServiceDescriptorProto{}
+    for _, s := range current {
+        services[s.GetName()] = s
+    }
+    for _, old := range previous {
+        name := prefix + "." + old.GetName()
+        s, ok := services[old.GetName()]
+        if !ok {
+            report("%s: service removed", name)
+            continue
+        }
+        methods := map[string]*descriptorpb.MethodDescriptorProto{}
+        for _, m := range s.Method {
+            methods[m.GetName()] = m
+        }
+        for _, o := range old.Method {
+            method := name + "/" + o.GetName()
+            m, ok := methods[o.GetName()]
+            if !ok {
+                report("%s: method removed", method)
+                continue
+            }
+            if o.GetInputType() != m.GetInputType() {
+                report("%s: request type changed from %s to %s", method, o.GetInputType(), m.GetInputType())
+            }
+            if o.GetOutputType() != m.GetOutputType() {
+                report("%s: response type changed from %s to %s", method, o.GetOutputType(), m.GetOutputType())
+            }
+            if o.GetClientStreaming() != m.GetClientStreaming() || o.GetServerStreaming() != m.GetServerStreaming() {
+                report("%s: streaming changed", method)
+            }
+        }
+    }
+}
+
+func fieldType(f *descriptorpb.FieldDescriptorProto) string {
+    if f.GetTypeName() != "" {
+        return f.GetTypeName()
+    }
+    return f.GetType().String()
+}
+
+// oneofName returns the name of the real oneof f belongs to, ignoring the
+// synthetic oneofs proto3 generates for optional fields.
+func oneofName(m *descriptorpb.DescriptorProto, f *descriptorpb.FieldDescriptorProto) string {
+    if f.OneofIndex == nil || f.GetProto3Optional() {
+        return ""
+    }
+    return m.OneofDecl[f.GetOneofIndex()].GetName()
+}
+
+func reservedNumber(ranges []*descriptorpb.DescriptorProto_ReservedRange, n int32) bool {
+    for _, r := range ranges {
+        if n >= r.GetStart() && n < r.GetEnd() {
+            return true
+        }
+    }
+    return false
+}
+
+func reservedEnumNumber(ranges []*descriptorpb.EnumDescriptorProto_EnumReservedRange, n int32) bool {
+    for _, r := range ranges {
+        if n >= r.GetStart() && n <= r.GetEnd() {
+            return true
+        }
+    }
+    return false
+}
+
+func reservedName(names []string, name string) bool {
+    for _, n := range names {
+        if n == name {
+            return true
+        }
+    }
+    return false
+}
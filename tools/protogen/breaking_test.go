@@ -0,0 +1,82 @@
+package main
+
+import (
+    "testing"
+
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/types/descriptorpb"
+)
+
+func testFile() *descriptorpb.FileDescriptorProto {
+    return &descriptorpb.FileDescriptorProto{
+        Name:    proto.String("catalog/catalog.proto"),
+        Package: proto.String("catalog"),
+        Options: &descriptorpb.FileOptions{GoPackage: proto.String("github.com/sys-apps-go/microservices/catalog")},
+        MessageType: []*descriptorpb.DescriptorProto{{
+            Name: proto.String("Product"),
+            Field: []*descriptorpb.FieldDescriptorProto{
+                {Name: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()},
+                {Name: proto.String("name"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()},
+            },
+        }},
+        Service: []*descriptorpb.ServiceDescriptorProto{{
+            Name: proto.String("CatalogService"),
+            Method: []*descriptorpb.MethodDescriptorProto{{
+                Name:       proto.String("GetProductById"),
+                InputType:  proto.String(".catalog.GetProductByIdRequest"),
+                OutputType: proto.String(".catalog.Product"),
+            }},
+        }},
+    }
+}
+
+func TestBreakingChanges(t *testing.T) {
+    tests := []struct {
+        name     string
+        change   func(f *descriptorpb.FileDescriptorProto)
+        breaking bool
+    }{
+        {"unchanged", func(f *descriptorpb.FileDescriptorProto) {}, false},
+        {"field added", func(f *descriptorpb.FileDescriptorProto) {
+            m := f.MessageType[0]
+            m.Field = append(m.Field, &descriptorpb.FieldDescriptorProto{Name: proto.String("price"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_FLOAT.Enum()})
+        }, false},
+        {"field removed", func(f *descriptorpb.FileDescriptorProto) {
+            f.MessageType[0].Field = f.MessageType[0].Field[:1]
+        }, true},
+        {"field removed and reserved", func(f *descriptorpb.FileDescriptorProto) {
+            m := f.MessageType[0]
+            m.Field = m.Field[:1]
+            m.ReservedRange = []*descriptorpb.DescriptorProto_ReservedRange{{Start: proto.Int32(2), End: proto.Int32(3)}}
+            m.ReservedName = []string{"name"}
+        }, false},
+        {"field type changed", func(f *descriptorpb.FileDescriptorProto) {
+            f.MessageType[0].Field[0].Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+        }, true},
+        {"field renamed", func(f *descriptorpb.FileDescriptorProto) {
+            f.MessageType[0].Field[1].Name = proto.String("title")
+        }, true},
+        {"message removed", func(f *descriptorpb.FileDescriptorProto) {
+            f.MessageType = nil
+        }, true},
+        {"method removed", func(f *descriptorpb.FileDescriptorProto) {
+            f.Service[0].Method = nil
+        }, true},
+        {"method made streaming", func(f *descriptorpb.FileDescriptorProto) {
+            f.Service[0].Method[0].ServerStreaming = proto.Bool(true)
+        }, true},
+        {"go_package changed", func(f *descriptorpb.FileDescriptorProto) {
+            f.Options.GoPackage = proto.String("example.com/catalog")
+        }, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            current := testFile()
+            tt.change(current)
+            problems := BreakingChanges([]*descriptorpb.FileDescriptorProto{testFile()}, []*descriptorpb.FileDescriptorProto{current})
+            if (len(problems) > 0) != tt.breaking {
+                t.Errorf("got %v, want breaking=%v", problems, tt.breaking)
+            }
+        })
+    }
+}
@@ -0,0 +1,220 @@
+// Command protogen compiles .proto files and runs the code generator
+// plugins over them, standing in for protoc so that generation only needs
+// the Go toolchain. The compiler and plugins are built at the versions
+// pinned in go.mod, which makes the output reproducible.
+//
+// Before writing anything it compares the compiled files against the
+// descriptor snapshot given with -snapshot and refuses to continue if the
+// change would break existing clients, unless PROTOGEN_ALLOW_BREAKING=1 is
+// set. The snapshot is then updated, so it always describes the protos the
+// checked-in code was generated from.
+//
+// It is run through go generate, see catalog/generate.go.
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+
+    "github.com/bufbuild/protocompile"
+    "github.com/bufbuild/protocompile/linker"
+    "google.golang.org/protobuf/proto"
+    "google.golang.org/protobuf/reflect/protodesc"
+    "google.golang.org/protobuf/reflect/protoreflect"
+    "google.golang.org/protobuf/types/descriptorpb"
+    "google.golang.org/protobuf/types/pluginpb"
+)
+
+// plugins maps the names accepted by -plugin to the packages they are
+// built from.
+var plugins = map[string]string{
+    "go":           "google.golang.org/protobuf/cmd/protoc-gen-go",
+    "go-grpc":      "google.golang.org/grpc/cmd/protoc-gen-go-grpc",
+    "grpc-gateway": "github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-grpc-gateway",
+    "openapiv2":    "github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-openapiv2",
+}
+
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(s string) error { *l = append(*l, s); return nil }
+
+func main() {
+    var includes, pluginSpecs listFlag
+    root := flag.String("root", ".", "directory that proto paths, include paths and outputs are relative to")
+    snapshot := flag.String("snapshot", "", "descriptor snapshot to check for breaking changes and update")
+    flag.Var(&includes, "I", "import path, may be repeated")
+    flag.Var(&pluginSpecs, "plugin", "plugin to run as name or name=parameter, may be repeated")
+    flag.Parse()
+    log.SetFlags(0)
+    log.SetPrefix("protogen: ")
+
+    if err := os.Chdir(*root); err != nil {
+        log.Fatal(err)
+    }
+    if len(includes) == 0 {
+        includes = listFlag{"."}
+    }
+    files := flag.Args()
+
+    all, generated, err := compile(includes, files)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    if *snapshot != "" {
+        if err := checkSnapshot(*snapshot, generated); err != nil {
+            log.Fatal(err)
+        }
+    }
+
+    bin, err := os.MkdirTemp("", "protogen")
+    if err != nil {
+        log.Fatal(err)
+    }
+    defer os.RemoveAll(bin)
+    for _, spec := range pluginSpecs {
+        name, param, _ := strings.Cut(spec, "=")
+        if err := runPlugin(bin, name, param, files, all); err != nil {
+            os.RemoveAll(bin)
+            log.Fatalf("%s: %v", name, err)
+        }
+    }
+
+    if *snapshot != "" {
+        if err := writeSnapshot(*snapshot, generated); err != nil {
+            log.Fatal(err)
+        }
+    }
+}
+
+// compile parses files and returns them with all their dependencies, in
+// dependency order, along with just the requested files.
+func compile(includes, files []string) (all, generated []*descriptorpb.FileDescriptorProto, err error) {
+    compiler := protocompile.Compiler{
+        Resolver:       protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: includes}),
+        SourceInfoMode: protocompile.SourceInfoStandard,
+    }
+    results, err := compiler.Compile(context.Background(), files...)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    seen := map[string]bool{}
+    var add func(fd protoreflect.FileDescriptor)
+    add = func(fd protoreflect.FileDescriptor) {
+        if seen[fd.Path()] {
+            return
+        }
+        seen[fd.Path()] = true
+        imports := fd.Imports()
+        for i := 0; i < imports.Len(); i++ {
+            add(imports.Get(i).FileDescriptor)
+        }
+        all = append(all, protodesc.ToFileDescriptorProto(fd))
+    }
+    for _, result := range results {
+        fd := result.(linker.Result)
+        add(fd)
+        generated = append(generated, protodesc.ToFileDescriptorProto(fd))
+    }
+    return all, generated, nil
+}
+
+// runPlugin builds the plugin called name into bin and feeds it a code
+// generation request, writing out the files it returns.
+func runPlugin(bin, name, param string, files []string, all []*descriptorpb.FileDescriptorProto) error {
+    pkg, ok := plugins[name]
+    if !ok {
+        return errors.New("unknown plugin")
+    }
+    exe := filepath.Join(bin, "protoc-gen-"+name)
+    build := exec.Command("go", "build", "-o", exe, pkg)
+    build.Stderr = os.Stderr
+    if err := build.Run(); err != nil {
+        return err
+    }
+
+    req, err := proto.Marshal(&pluginpb.CodeGeneratorRequest{
+        FileToGenerate: files,
+        Parameter:      proto.String(param),
+        ProtoFile:      all,
+    })
+    if err != nil {
+        return err
+    }
+    cmd := exec.Command(exe)
+    cmd.Stdin = bytes.NewReader(req)
+    cmd.Stderr = os.Stderr
+    out, err := cmd.Output()
+    if err != nil {
+        return err
+    }
+    var resp pluginpb.CodeGeneratorResponse
+    if err := proto.Unmarshal(out, &resp); err != nil {
+        return err
+    }
+    if resp.Error != nil {
+        return errors.New(resp.GetError())
+    }
+    for _, f := range resp.File {
+        if err := os.MkdirAll(filepath.Dir(f.GetName()), 0o755); err != nil {
+            return err
+        }
+        if err := os.WriteFile(f.GetName(), []byte(f.GetContent()), 0o644); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func checkSnapshot(path string, files []*descriptorpb.FileDescriptorProto) error {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    var previous descriptorpb.FileDescriptorSet
+    if err := proto.Unmarshal(data, &previous); err != nil {
+        return fmt.Errorf("reading %s: %v", path, err)
+    }
+
+    problems := BreakingChanges(previous.File, files)
+    if len(problems) == 0 {
+        return nil
+    }
+    for _, p := range problems {
+        fmt.Fprintln(os.Stderr, "breaking change:", p)
+    }
+    if os.Getenv("PROTOGEN_ALLOW_BREAKING") == "1" {
+        log.Printf("accepting %d breaking changes as PROTOGEN_ALLOW_BREAKING=1", len(problems))
+        return nil
+    }
+    return fmt.Errorf("%d breaking changes against %s, set PROTOGEN_ALLOW_BREAKING=1 if they are intended", len(problems), path)
+}
+
+// writeSnapshot stores files without source info, so that comment and
+// formatting changes do not touch the snapshot.
+func writeSnapshot(path string, files []*descriptorpb.FileDescriptorProto) error {
+    set := &descriptorpb.FileDescriptorSet{}
+    for _, f := range files {
+        f = proto.Clone(f).(*descriptorpb.FileDescriptorProto)
+        f.SourceCodeInfo = nil
+        set.File = append(set.File, f)
+    }
+    data, err := proto.MarshalOptions{Deterministic: true}.Marshal(set)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0o644)
+}